@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"fmt"
 	"io"
@@ -65,3 +66,11 @@ func checksumFile(filePath string, isText bool) []byte {
 
 	return checksum
 }
+
+func checksumBytes(data []byte, isText bool) []byte {
+	if isText {
+		data = bytes.ReplaceAll(data, []byte{'\r'}, nil)
+	}
+	checksum := sha256.Sum256(data)
+	return checksum[:]
+}
@@ -2,18 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	pgx "github.com/jackc/pgx/v5"
 )
 
 const (
-	SeveritySilence         = 0
-	SeveritySuspend         = 1
-	SeverityNoOp            = 2
 	ServerAccountID         = -99
+	DomainBlockWorkerClass  = "DomainBlockWorker"
 	ActionCreate            = "create"
 	ActionUpdate            = "update"
 	ActionDestroy           = "destroy"
@@ -22,9 +24,46 @@ const (
 	RubyTimeFormat          = "2006-01-02 15:04:05.000000000 Z07:00"
 )
 
+// Mastodon 4.x replaced the admin and moderator flags on users with roles,
+// whose permissions are a bitmask.  Either of these two bits is enough to
+// manage domain blocks.
+const (
+	MastodonPermissionAdministrator    = 1 << 0
+	MastodonPermissionManageFederation = 1 << 5
+)
+
+const SQLSelectLocalAccountByIDMastodon = `
+SELECT id, username FROM public.accounts WHERE id = $1 AND domain IS NULL
+`
+
+const SQLSelectLocalAccountByUsernameMastodon = `
+SELECT id, username FROM public.accounts WHERE lower(username) = lower($1) AND domain IS NULL
+`
+
+const SQLSelectUserPermissionsMastodon = `
+SELECT COALESCE(r.permissions, 0)
+FROM public.users AS u
+LEFT JOIN public.user_roles AS r ON r.id = u.role_id
+WHERE u.account_id = $1
+`
+
+const SQLSelectUserIsStaffMastodon3x = `
+SELECT admin OR moderator FROM public.users WHERE account_id = $1
+`
+
+const SQLUnsilenceAccountsMastodon = `
+UPDATE public.accounts SET silenced_at = NULL
+WHERE (domain = $1 OR right(domain, length($1) + 1) = '.' || $1) AND silenced_at = $2
+`
+
+const SQLUnsuspendAccountsMastodon = `
+UPDATE public.accounts SET suspended_at = NULL, suspension_origin = NULL
+WHERE (domain = $1 OR right(domain, length($1) + 1) = '.' || $1) AND suspended_at = $2
+`
+
 const SQLSelectDomainBlocksMastodon = `
 SELECT
-	id, domain, private_comment, public_comment, created_at, updated_at, severity, reject_media, reject_reports, obfuscate
+	id, domain, COALESCE(private_comment, ''), COALESCE(public_comment, ''), created_at, updated_at, severity, reject_media, reject_reports, obfuscate
 FROM public.domain_blocks
 `
 
@@ -54,101 +93,417 @@ const SQLDeleteDomainBlocksMastodon = `
 DELETE FROM public.domain_blocks WHERE id = $1
 `
 
-const SQLInsertAdminActionLogMastodon3x = `
-INSERT INTO public.admin_action_logs
-	(created_at, updated_at, account_id, action, target_type, target_id, recorded_changes)
-VALUES
-	($1, $2, $3, $4, $5, $6, $7)
-`
+func cmdApply() {
+	switch flagFormat {
+	case FormatText:
+	case FormatJSON:
+	default:
+		fmt.Fprintf(os.Stderr, "fatal: unknown value %q for -f / --format flag, expected one of: %s\n", flagFormat, AllFormats)
+		os.Exit(1)
+	}
 
-const SQLInsertAdminActionLogMastodon4x = `
-INSERT INTO public.admin_action_logs
-	(created_at, updated_at, account_id, action, target_type, target_id, human_identifier, route_param, permalink)
-VALUES
-	($1, $2, $3, $4, $5, $6, $7, $8, $9)
-`
+	instances := selectedInstances()
+	exitCode := 0
+	for _, inst := range instances {
+		code := applyInstance(inst, len(instances) > 1)
+		if code > exitCode {
+			exitCode = code
+		}
+	}
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
+// applyInstance applies the blocklist to one instance and returns the exit
+// status.  When several instances are being applied, failures are reported
+// as errors against the instance's name, and the remaining instances are
+// still applied.
+func applyInstance(inst InstanceConfig, isMulti bool) int {
+	logf := func(level string, format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		if isMulti {
+			msg = inst.Name + ": " + msg
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s\n", level, msg)
+	}
+	fail := func(format string, args ...any) {
+		if isMulti {
+			logf("error", format, args...)
+		} else {
+			logf("fatal", format, args...)
+		}
+	}
 
-func cmdApply() {
 	switch {
-	case flagDataFile == "":
-		fmt.Fprintf(os.Stderr, "fatal: missing required flag -d / --data-file\n")
-		os.Exit(1)
-	case flagDatabaseURL == "":
-		fmt.Fprintf(os.Stderr, "fatal: missing required flag -D / --database-url\n")
-		os.Exit(1)
-	case flagSoftware == "":
-		fmt.Fprintf(os.Stderr, "fatal: missing required flag -x / --software\n")
-		os.Exit(1)
+	case inst.DataFile == "":
+		fail("missing required flag -d / --data-file")
+		return 1
+	case inst.Software == MastodonAPI && inst.APIURL == "":
+		fail("missing required flag --api-url")
+		return 1
+	case inst.Software == MastodonAPI && inst.APITokenFile == "":
+		fail("missing required flag --api-token-file")
+		return 1
+	case inst.Software != MastodonAPI && inst.DatabaseURL == "":
+		fail("missing required flag -D / --database-url")
+		return 1
 	}
 
-	var applyFn func(context.Context, BlockFile) (int, int, int)
-	switch flagSoftware {
-	case Mastodon3x:
-		applyFn = ApplyMastodon
-	case Mastodon4x:
-		applyFn = ApplyMastodon
-	default:
-		fmt.Fprintf(os.Stderr, "fatal: software %q not implemented\n", flagSoftware)
-		os.Exit(1)
+	applyFn, found := ApplyFuncFor(inst.Software)
+	if !found {
+		fail("software %q not implemented", inst.Software)
+		return 1
 	}
 
 	ctx := context.Background()
 
 	var file BlockFile
-	ReadJsonFile(&file, flagDataFile)
+	err := LoadJsonFile(&file, inst.DataFile)
+	if err != nil {
+		fail("%v", err)
+		return 1
+	}
 
-	insertCount, updateCount, deleteCount := applyFn(ctx, file)
-	if insertCount > 0 {
-		fmt.Printf("added %d new block(s)\n", insertCount)
+	opts, err := inst.ApplyOptions()
+	if errs, ok := err.(ErrorList); ok {
+		for _, err := range errs {
+			fail("%v", err)
+		}
+		return 1
+	} else if err != nil {
+		fail("%v", err)
+		return 1
 	}
-	if updateCount > 0 {
-		fmt.Printf("modified %d existing block(s)\n", updateCount)
+	opts.HTTPClient = gHTTPClient
+	opts.DryRun = flagDryRun
+	opts.Force = flagForce
+	opts.ShowImpact = flagShowImpact
+
+	if isMulti && flagFormat == FormatText {
+		fmt.Printf("==> %s <==\n", inst.Name)
 	}
-	if deleteCount > 0 {
-		fmt.Printf("deleted %d existing block(s) that are now remediated\n", deleteCount)
+
+	startedAt := time.Now().UTC()
+	plan, err := applyFn(ctx, file, opts)
+	finishedAt := time.Now().UTC()
+
+	var limitErr *LimitError
+	var lockedErr *LockedError
+	switch {
+	case errors.As(err, &lockedErr):
+		fail("%v", err)
+		return ExitLocked
+
+	case errors.As(err, &limitErr):
+		logf("error", "%v", err)
+		writeApplyOutput(inst, file, plan, false, limitErr, startedAt, finishedAt)
+		fail("refusing to commit; review the plan above and re-run with --force to apply it anyway")
+		return ExitLimitExceeded
+
+	case err != nil:
+		fail("%v", err)
+		return 1
 	}
+
+	writeApplyOutput(inst, file, plan, !flagDryRun, nil, startedAt, finishedAt)
+	return 0
 }
 
-func ApplyMastodon(ctx context.Context, file BlockFile) (insertCount int, updateCount int, deleteCount int) {
-	conn, err := pgx.Connect(ctx, flagDatabaseURL)
+func writeApplyOutput(inst InstanceConfig, file BlockFile, plan Plan, committed bool, limitErr *LimitError, startedAt time.Time, finishedAt time.Time) {
+	var err error
+	switch {
+	case flagFormat == FormatJSON:
+		report := NewApplyReport(inst.Software, file, plan, startedAt, finishedAt)
+		report.Instance = inst.Name
+		report.DryRun = flagDryRun
+		report.Committed = committed
+		if limitErr != nil {
+			report.LimitViolations = limitErr.Violations
+		}
+		err = report.WriteJSON(os.Stdout)
+
+	case !committed:
+		err = plan.WriteText(os.Stdout)
+
+	default:
+		totals := plan.Totals()
+		if totals.Inserted > 0 {
+			fmt.Printf("added %d new block(s)\n", totals.Inserted)
+		}
+		if totals.Updated > 0 {
+			fmt.Printf("modified %d existing block(s)\n", totals.Updated)
+		}
+		if totals.Deleted > 0 {
+			fmt.Printf("deleted %d existing block(s) that are now remediated\n", totals.Deleted)
+		}
+		if totals.Skipped > 0 {
+			fmt.Printf("skipped %d block(s) overridden by local decisions\n", totals.Skipped)
+		}
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: failed to connect to PostgreSQL: %v\n", err)
+		fmt.Fprintf(os.Stderr, "fatal: failed to write output: %v\n", err)
 		os.Exit(1)
 	}
+}
+
+type ApplyFunc func(context.Context, BlockFile, ApplyOptions) (Plan, error)
+
+func ApplyFuncFor(software string) (ApplyFunc, bool) {
+	switch software {
+	case Mastodon3x:
+		return ApplyMastodon, true
+	case Mastodon4x:
+		return ApplyMastodon, true
+	case MastodonAuto:
+		return ApplyMastodon, true
+	case Pleroma:
+		return ApplyPleroma, true
+	case Akkoma:
+		return ApplyPleroma, true
+	case GoToSocial:
+		return ApplyGoToSocial, true
+	case Misskey:
+		return ApplyMisskey, true
+	case Firefish:
+		return ApplyMisskey, true
+	case MastodonAPI:
+		return ApplyMastodonAPI, true
+	default:
+		return nil, false
+	}
+}
+
+type ApplyOptions struct {
+	Software    string
+	DatabaseURL string
+	APIURL      string
+	APIToken    string
+	// HTTPClient makes the requests to APIURL.
+	HTTPClient *http.Client
+	// ActingAccount is the local Mastodon account, by username or by
+	// "id:" and a numeric ID, that admin action logs attribute changes to.
+	// If empty, the instance's internal server account is used, with a
+	// warning.
+	ActingAccount string
+	// SidekiqRedisURL, if not empty, is the Redis server of Mastodon's
+	// Sidekiq, which is asked to carry out the consequences of each change,
+	// such as suspending the accounts of a newly suspended domain.
+	SidekiqRedisURL  string
+	SidekiqNamespace string
+	// SidekiqSpoolDir, if not empty, holds the Sidekiq jobs of each run
+	// until they have been pushed; see SidekiqSpool.
+	SidekiqSpoolDir string
+	// ShowImpact asks for the Impact of each plan item to be filled in.
+	ShowImpact bool
+	// LockTimeout is how long to wait for another run that is applying
+	// changes to the same database.
+	LockTimeout time.Duration
+	DryRun      bool
+	Force       bool
+	Policy      Policy
+	Overrides   Overrides
+	Limits      Limits
+}
+
+// ApplyMastodon computes the plan for bringing a Mastodon instance's
+// domain_blocks table in line with file, then executes it.  If opts.DryRun
+// is true, the plan is computed against the live database but nothing is
+// committed.
+func ApplyMastodon(ctx context.Context, file BlockFile, opts ApplyOptions) (Plan, error) {
+	conn, err := pgx.Connect(ctx, opts.DatabaseURL)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
 	defer conn.Close(ctx)
 
+	spool := SidekiqSpool{Dir: opts.SidekiqSpoolDir, RedisURL: opts.SidekiqRedisURL, Namespace: opts.SidekiqNamespace}
+	var plan Plan
+	var jobs []SidekiqJob
+	var spoolFile string
+	err = RetryTx(ctx, func() error {
+		var err error
+		plan, jobs, spoolFile, err = applyMastodonTx(ctx, conn, file, opts, spool)
+		return err
+	})
+	if err != nil || len(jobs) == 0 {
+		return plan, err
+	}
+
+	err = spool.Push(ctx, jobs, []string{spoolFile})
+	if err != nil && spoolFile != "" {
+		return plan, fmt.Errorf("the changes were committed, but Mastodon could not be asked to enforce them; the next run will try again: %w", err)
+	}
+	if err != nil {
+		return plan, fmt.Errorf("the changes were committed, but Mastodon could not be asked to enforce them: %w", err)
+	}
+	return plan, nil
+}
+
+// applyMastodonTx is one attempt at the transaction for ApplyMastodon.  It
+// returns the Sidekiq jobs that enforce the changes, which must not be
+// pushed until the transaction has committed, or DomainBlockWorker may
+// run against the rows as they were before.  They are saved to the spool
+// before the commit, so that they are not lost if the push fails, and the
+// name of the file they were saved in is returned along with them.
+func applyMastodonTx(ctx context.Context, conn *pgx.Conn, file BlockFile, opts ApplyOptions, spool SidekiqSpool) (Plan, []SidekiqJob, string, error) {
 	tx, err := conn.Begin(ctx)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: failed to Begin transaction: %v\n", err)
-		os.Exit(1)
+		return Plan{}, nil, "", fmt.Errorf("failed to Begin transaction: %w", err)
 	}
 	defer func() {
 		_ = tx.Rollback(ctx)
 	}()
 
-	existingBlocks := GetMastodonDomainBlocks(ctx, tx)
-	now := time.Now().UTC()
+	if !opts.DryRun {
+		err = LockAdvisory(ctx, tx, opts.LockTimeout)
+		if err != nil {
+			return Plan{}, nil, "", err
+		}
+	}
+
+	triggerSideEffects := opts.SidekiqRedisURL != "" && !opts.DryRun
+	if triggerSideEffects {
+		leftover, files, err := spool.Load()
+		if err == nil && len(leftover) > 0 {
+			err = spool.Push(ctx, leftover, files)
+		}
+		if err != nil {
+			return Plan{}, nil, "", fmt.Errorf("failed to push the Sidekiq jobs left over from an earlier run: %w", err)
+		}
+	}
+
+	software, err := CheckMastodonSchema(ctx, tx, opts.Software)
+	if err != nil {
+		return Plan{}, nil, "", err
+	}
+
+	accountID, err := ResolveMastodonActingAccount(ctx, tx, software, opts.ActingAccount)
+	if err != nil {
+		return Plan{}, nil, "", err
+	}
+
+	existingBlocks, err := GetMastodonDomainBlocks(ctx, tx)
+	if err != nil {
+		return Plan{}, nil, "", err
+	}
+
+	plan := PlanMastodon(file, opts.Policy, opts.Overrides, existingBlocks, time.Now().UTC())
+	if opts.ShowImpact {
+		err = AddMastodonImpact(ctx, tx, &plan)
+		if err != nil {
+			return plan, nil, "", err
+		}
+	}
+	if opts.DryRun {
+		return plan, nil, "", nil
+	}
+
+	if !opts.Force {
+		err = opts.Limits.Check(plan.Totals(), CountManagedMastodonBlocks(existingBlocks))
+		if err != nil {
+			return plan, nil, "", err
+		}
+	}
+
+	if triggerSideEffects {
+		err = UnblockMastodonAccounts(ctx, tx, plan)
+		if err != nil {
+			return plan, nil, "", err
+		}
+	}
+
+	err = ExecuteMastodonPlan(ctx, tx, accountID, plan)
+	if err != nil {
+		return plan, nil, "", err
+	}
+
+	var jobs []SidekiqJob
+	var spoolFile string
+	if triggerSideEffects {
+		jobs, err = MastodonDomainBlockJobs(plan)
+		if err == nil {
+			spoolFile, err = spool.Save(jobs)
+		}
+		if err != nil {
+			return plan, nil, "", err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return plan, nil, "", fmt.Errorf("failed to Commit transaction: %w", err)
+	}
+	return plan, jobs, spoolFile, nil
+}
 
-	for domain, block := range file.Blocks {
+// PlanMastodon decides which domain_blocks rows to insert, update, or delete,
+// without touching the database.
+func PlanMastodon(file BlockFile, policy Policy, overrides Overrides, existingBlocks map[string]MastodonDomainBlock, now time.Time) Plan {
+	blocks := file.Blocks
+	if extra := overrides.ExactDomains(AlwaysBlockOverride); len(extra) > 0 {
+		blocks = make(map[string]Block, len(file.Blocks)+len(extra))
+		for domain, block := range file.Blocks {
+			blocks[domain] = block
+		}
+		for _, domain := range extra {
+			if _, found := blocks[domain]; !found {
+				blocks[domain] = Block{}
+			}
+		}
+	}
+
+	var plan Plan
+	for domain, block := range blocks {
 		existing, hasExisting := existingBlocks[domain]
+		old := &existing
+		if !hasExisting {
+			old = nil
+		}
 
 		// If an admin has made a local decision for this domain, leave it alone.
 		if hasExisting && existing.PrivateComment != WellKnownPrivateComment {
+			if block.IsBlocked {
+				plan.Add(PlanItem{Domain: domain, Action: SkipAction, Old: old, Reason: SkipReasonLocalDecision})
+			}
 			continue
 		}
 
+		action := policy.ActionFor(block)
+
+		var reason string
+		if override, found := overrides.Match(domain); found {
+			reason = override.Description()
+			switch override.Action {
+			case IgnoreUpstreamOverride:
+				if block.IsBlocked || hasExisting {
+					plan.Add(PlanItem{Domain: domain, Action: SkipAction, Old: old, Reason: reason})
+				}
+				continue
+
+			case NeverBlockOverride:
+				if block.IsBlocked && !hasExisting {
+					plan.Add(PlanItem{Domain: domain, Action: SkipAction, Reason: reason})
+				}
+				block.IsBlocked = false
+
+			case AlwaysBlockOverride:
+				block.IsBlocked = true
+				action = override.PolicyAction
+				if override.Reason != "" {
+					block.Reason = override.Reason
+				}
+			}
+		}
+
 		switch {
 		case block.IsBlocked && hasExisting:
 			updated := existing
 			updated.PublicComment = block.Reason
-			updated.Severity = SeveritySuspend
-			updated.RejectMedia = false
-			updated.RejectReports = false
-			updated.Obfuscate = false
+			action.ApplyTo(&updated)
 			if updated != existing {
 				updated.UpdatedAt = now
-				UpdateMastodonDomainBlock(ctx, tx, updated)
-				updateCount++
+				plan.Add(PlanItem{Domain: domain, Action: UpdateAction, Old: old, New: &updated, Reason: reason})
 			}
 
 		case block.IsBlocked:
@@ -158,42 +513,69 @@ func ApplyMastodon(ctx context.Context, file BlockFile) (insertCount int, update
 			inserted.PublicComment = block.Reason
 			inserted.CreatedAt = now
 			inserted.UpdatedAt = now
-			inserted.Severity = SeveritySuspend
-			inserted.RejectMedia = false
-			inserted.RejectReports = false
-			inserted.Obfuscate = false
-			InsertMastodonDomainBlock(ctx, tx, inserted)
-			insertCount++
+			action.ApplyTo(&inserted)
+			plan.Add(PlanItem{Domain: domain, Action: InsertAction, New: &inserted, Reason: reason})
 
 		case hasExisting:
 			deleted := existing
 			deleted.UpdatedAt = now
-			DeleteMastodonDomainBlock(ctx, tx, deleted)
-			deleteCount++
+			plan.Add(PlanItem{Domain: domain, Action: DeleteAction, Old: &deleted, Reason: reason})
 		}
 	}
+	plan.Sort()
+	return plan
+}
 
-	err = tx.Commit(ctx)
+func CountManagedMastodonBlocks(blocks map[string]MastodonDomainBlock) int {
+	var n int
+	for _, block := range blocks {
+		if block.PrivateComment == WellKnownPrivateComment {
+			n++
+		}
+	}
+	return n
+}
+
+func ExecuteMastodonPlan(ctx context.Context, tx pgx.Tx, accountID int64, plan Plan) error {
+	totals := plan.Totals()
+	if totals.Inserted+totals.Updated+totals.Deleted == 0 {
+		return nil
+	}
+
+	logColumns, err := GetMastodonAdminActionLogColumns(ctx, tx)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: failed to Commit transaction: %v\n", err)
-		os.Exit(1)
+		return err
+	}
+
+	for _, item := range plan.Items {
+		switch item.Action {
+		case InsertAction:
+			err = InsertMastodonDomainBlock(ctx, tx, logColumns, accountID, item.New)
+		case UpdateAction:
+			err = UpdateMastodonDomainBlock(ctx, tx, logColumns, accountID, *item.New)
+		case DeleteAction:
+			err = DeleteMastodonDomainBlock(ctx, tx, logColumns, accountID, *item.Old)
+		}
+		if err != nil {
+			return err
+		}
 	}
-	return
+	return nil
 }
 
-func GetMastodonDomainBlocks(ctx context.Context, tx pgx.Tx) map[string]MastodonDomainBlock {
+func GetMastodonDomainBlocks(ctx context.Context, tx pgx.Tx) (map[string]MastodonDomainBlock, error) {
 	const sql = SQLSelectDomainBlocksMastodon
 
 	rows, err := tx.Query(ctx, sql)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: failed to Query %q: %v\n", sql, err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to Query %q: %w", sql, err)
 	}
 	defer rows.Close()
 
 	out := make(map[string]MastodonDomainBlock, 1024)
 	for rows.Next() {
 		var row MastodonDomainBlock
+		var severity int
 		err = rows.Scan(
 			&row.ID,
 			&row.Domain,
@@ -201,29 +583,29 @@ func GetMastodonDomainBlocks(ctx context.Context, tx pgx.Tx) map[string]Mastodon
 			&row.PublicComment,
 			&row.CreatedAt,
 			&row.UpdatedAt,
-			&row.Severity,
+			&severity,
 			&row.RejectMedia,
 			&row.RejectReports,
 			&row.Obfuscate,
 		)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "fatal: failed to process result row from Query %q: %v\n", sql, err)
-			os.Exit(1)
+			return nil, fmt.Errorf("failed to process result row from Query %q: %w", sql, err)
 		}
+		row.Severity = Severity(severity)
 		out[row.Domain] = row
 	}
 
 	rows.Close()
 	err = rows.Err()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: failed to process results from Query %q: %v\n", sql, err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to process results from Query %q: %w", sql, err)
 	}
 
-	return out
+	return out, nil
 }
 
-func InsertMastodonDomainBlock(ctx context.Context, tx pgx.Tx, block MastodonDomainBlock) {
+// InsertMastodonDomainBlock inserts block and fills in its ID.
+func InsertMastodonDomainBlock(ctx context.Context, tx pgx.Tx, logColumns MastodonAdminActionLogColumns, accountID int64, block *MastodonDomainBlock) error {
 	var args [9]any
 	var sql string
 
@@ -232,7 +614,7 @@ func InsertMastodonDomainBlock(ctx context.Context, tx pgx.Tx, block MastodonDom
 	args[2] = block.PublicComment
 	args[3] = block.CreatedAt
 	args[4] = block.UpdatedAt
-	args[5] = block.Severity
+	args[5] = int(block.Severity)
 	args[6] = block.RejectMedia
 	args[7] = block.RejectReports
 	args[8] = block.Obfuscate
@@ -241,49 +623,22 @@ func InsertMastodonDomainBlock(ctx context.Context, tx pgx.Tx, block MastodonDom
 	var insertID uint64
 	err := tx.QueryRow(ctx, sql, args[:9]...).Scan(&insertID)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: failed to QueryRow %q: %v\n", sql, err)
-		os.Exit(1)
+		return fmt.Errorf("failed to QueryRow %q: %w", sql, err)
 	}
 
 	block.ID = insertID
-
-	args[0] = block.CreatedAt
-	args[1] = block.UpdatedAt
-	args[2] = ServerAccountID
-	args[3] = ActionCreate
-	args[4] = TargetTypeDomainBlock
-	args[5] = insertID
-
-	var n int
-	switch flagSoftware {
-	case Mastodon3x:
-		n = 7
-		args[6] = block.AsYAML()
-		sql = SQLInsertAdminActionLogMastodon3x
-	default:
-		n = 9
-		args[6] = block.Domain
-		args[7] = ""
-		args[8] = ""
-		sql = SQLInsertAdminActionLogMastodon4x
-	}
-
-	_, err = tx.Exec(ctx, sql, args[:n]...)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: failed to Exec %q: %v\n", sql, err)
-		os.Exit(1)
-	}
+	return InsertMastodonAdminActionLog(ctx, tx, logColumns, accountID, ActionCreate, *block)
 }
 
-func UpdateMastodonDomainBlock(ctx context.Context, tx pgx.Tx, block MastodonDomainBlock) {
-	var args [9]any
+func UpdateMastodonDomainBlock(ctx context.Context, tx pgx.Tx, logColumns MastodonAdminActionLogColumns, accountID int64, block MastodonDomainBlock) error {
+	var args [8]any
 	var sql string
 
 	args[0] = block.ID
 	args[1] = block.PrivateComment
 	args[2] = block.PublicComment
 	args[3] = block.UpdatedAt
-	args[4] = block.Severity
+	args[4] = int(block.Severity)
 	args[5] = block.RejectMedia
 	args[6] = block.RejectReports
 	args[7] = block.Obfuscate
@@ -291,90 +646,258 @@ func UpdateMastodonDomainBlock(ctx context.Context, tx pgx.Tx, block MastodonDom
 
 	_, err := tx.Exec(ctx, sql, args[:8]...)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: failed to Exec %q: %v\n", sql, err)
-		os.Exit(1)
+		return fmt.Errorf("failed to Exec %q: %w", sql, err)
 	}
 
-	args[0] = block.CreatedAt
-	args[1] = block.UpdatedAt
-	args[2] = ServerAccountID
-	args[3] = ActionUpdate
-	args[4] = TargetTypeDomainBlock
-	args[5] = block.ID
+	return InsertMastodonAdminActionLog(ctx, tx, logColumns, accountID, ActionUpdate, block)
+}
 
-	var n int
-	switch flagSoftware {
-	case Mastodon3x:
-		n = 7
-		args[6] = block.AsYAML()
-		sql = SQLInsertAdminActionLogMastodon3x
-	default:
-		n = 9
-		args[6] = block.Domain
-		args[7] = ""
-		args[8] = ""
-		sql = SQLInsertAdminActionLogMastodon4x
-	}
+func DeleteMastodonDomainBlock(ctx context.Context, tx pgx.Tx, logColumns MastodonAdminActionLogColumns, accountID int64, block MastodonDomainBlock) error {
+	var args [1]any
+	var sql string
+
+	args[0] = block.ID
+	sql = SQLDeleteDomainBlocksMastodon
 
-	_, err = tx.Exec(ctx, sql, args[:n]...)
+	_, err := tx.Exec(ctx, sql, args[:1]...)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: failed to Exec %q: %v\n", sql, err)
-		os.Exit(1)
+		return fmt.Errorf("failed to Exec %q: %w", sql, err)
 	}
+
+	return InsertMastodonAdminActionLog(ctx, tx, logColumns, accountID, ActionDestroy, block)
 }
 
-func DeleteMastodonDomainBlock(ctx context.Context, tx pgx.Tx, block MastodonDomainBlock) {
-	var args [9]any
+// UnblockMastodonAccounts lifts the silences and suspensions that each
+// domain block about to be deleted imposed on the accounts of its domain,
+// as Mastodon's UnblockDomainService does.  Accounts are recognized by the
+// block's creation time, which BlockDomainService stamps on them.
+func UnblockMastodonAccounts(ctx context.Context, tx pgx.Tx, plan Plan) error {
+	for _, item := range plan.Items {
+		if item.Action != DeleteAction {
+			continue
+		}
+		block := *item.Old
+
+		if block.Severity != SeverityNoOp {
+			const sql = SQLUnsilenceAccountsMastodon
+			_, err := tx.Exec(ctx, sql, block.Domain, block.CreatedAt)
+			if err != nil {
+				return fmt.Errorf("failed to Exec %q: %w", sql, err)
+			}
+		}
+
+		if block.Severity == SeveritySuspend {
+			const sql = SQLUnsuspendAccountsMastodon
+			_, err := tx.Exec(ctx, sql, block.Domain, block.CreatedAt)
+			if err != nil {
+				return fmt.Errorf("failed to Exec %q: %w", sql, err)
+			}
+		}
+	}
+	return nil
+}
+
+// MastodonDomainBlockJobs returns the DomainBlockWorker jobs that Mastodon's
+// admin UI would enqueue for the inserts and updates in plan, which have
+// already been executed in the open transaction.  The worker runs BlockDomainService, which
+// silences or suspends the domain's accounts, and when an update changes
+// the severity, first undoes what the old severity did.
+func MastodonDomainBlockJobs(plan Plan) ([]SidekiqJob, error) {
+	var jobs []SidekiqJob
+	for _, item := range plan.Items {
+		var isUpdate bool
+		switch item.Action {
+		case InsertAction:
+			isUpdate = false
+		case UpdateAction:
+			isUpdate = item.Old.Severity != item.New.Severity
+		default:
+			continue
+		}
+
+		job, err := NewSidekiqJob(DomainBlockWorkerClass, item.New.ID, isUpdate)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// MastodonActingAccountIDPrefix introduces an acting account given by
+// numeric account ID rather than by username, e.g. "id:123".  Usernames may
+// consist entirely of digits, so a bare number is always a username.
+const MastodonActingAccountIDPrefix = "id:"
+
+// ParseMastodonActingAccount splits an acting account spec into either an
+// account ID, for "id:123", or a username, for "alice" or "@alice".
+func ParseMastodonActingAccount(spec string) (id int64, username string, err error) {
+	if strings.HasPrefix(spec, MastodonActingAccountIDPrefix) {
+		id, err = strconv.ParseInt(strings.TrimPrefix(spec, MastodonActingAccountIDPrefix), 10, 64)
+		if err != nil {
+			return 0, "", fmt.Errorf("acting account %q: expected a numeric account ID after %q", spec, MastodonActingAccountIDPrefix)
+		}
+		return id, "", nil
+	}
+	username = strings.TrimPrefix(spec, "@")
+	if username == "" || strings.Contains(username, "@") {
+		return 0, "", fmt.Errorf("acting account %q: expected the username of a local account, or %s followed by an account ID", spec, MastodonActingAccountIDPrefix)
+	}
+	return 0, username, nil
+}
+
+// ResolveMastodonActingAccount looks up the account to credit in the admin
+// action log.  The account may be given by username, with or without a
+// leading "@", or by numeric ID as "id:123", and must be a local account
+// whose user is an admin or moderator, which is decided by Mastodon 4.x
+// roles or by the Mastodon 3.x admin and moderator flags, according to
+// software.  ServerAccountID is used if spec names it explicitly, or, with
+// a warning, if spec is empty.
+func ResolveMastodonActingAccount(ctx context.Context, tx pgx.Tx, software string, spec string) (int64, error) {
+	if spec == "" {
+		fmt.Fprintf(os.Stderr, "warning: no acting account is configured, so the admin action log will credit changes to the internal server account; set acting_account or --acting-account to credit a moderator, or to %s%d to keep this behavior\n", MastodonActingAccountIDPrefix, ServerAccountID)
+		return ServerAccountID, nil
+	}
+
+	parsedID, parsedUsername, err := ParseMastodonActingAccount(spec)
+	if err != nil {
+		return 0, err
+	}
+
 	var sql string
+	var arg any
+	if parsedUsername == "" {
+		if parsedID == ServerAccountID {
+			return ServerAccountID, nil
+		}
+		sql = SQLSelectLocalAccountByIDMastodon
+		arg = parsedID
+	} else {
+		sql = SQLSelectLocalAccountByUsernameMastodon
+		arg = parsedUsername
+	}
 
-	args[0] = block.ID
-	sql = SQLDeleteDomainBlocksMastodon
+	var id int64
+	var username string
+	err = tx.QueryRow(ctx, sql, arg).Scan(&id, &username)
+	switch {
+	case err == pgx.ErrNoRows:
+		return 0, fmt.Errorf("acting account %q: no such local account", spec)
+	case err != nil:
+		return 0, fmt.Errorf("failed to QueryRow %q: %w", sql, err)
+	}
 
-	_, err := tx.Exec(ctx, sql, args[:1]...)
+	var isStaff bool
+	if software != Mastodon3x {
+		sql = SQLSelectUserPermissionsMastodon
+		var permissions int64
+		err = tx.QueryRow(ctx, sql, id).Scan(&permissions)
+		isStaff = (permissions & (MastodonPermissionAdministrator | MastodonPermissionManageFederation)) != 0
+	} else {
+		sql = SQLSelectUserIsStaffMastodon3x
+		err = tx.QueryRow(ctx, sql, id).Scan(&isStaff)
+	}
+	switch {
+	case err == pgx.ErrNoRows:
+		return 0, fmt.Errorf("acting account %q: @%s (ID %d) has no user, and so cannot be an admin or moderator", spec, username, id)
+	case err != nil:
+		return 0, fmt.Errorf("failed to QueryRow %q: %w", sql, err)
+	case !isStaff:
+		return 0, fmt.Errorf("acting account %q: @%s (ID %d) is not an admin or moderator", spec, username, id)
+	}
+	return id, nil
+}
+
+// MastodonAdminActionLogColumns records which of the version-dependent
+// columns of admin_action_logs are present.  Mastodon 4.0 added
+// human_identifier, route_param, and permalink, and a post-deployment
+// migration later drops recorded_changes, so depending on how far an
+// instance has been migrated it may have either set or both.
+type MastodonAdminActionLogColumns struct {
+	RecordedChanges bool
+	HumanIdentifier bool
+	RouteParam      bool
+	Permalink       bool
+}
+
+func GetMastodonAdminActionLogColumns(ctx context.Context, tx pgx.Tx) (MastodonAdminActionLogColumns, error) {
+	var out MastodonAdminActionLogColumns
+	columns, err := GetPostgresTableColumns(ctx, tx, "admin_action_logs")
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: failed to Exec %q: %v\n", sql, err)
-		os.Exit(1)
+		return out, err
 	}
+	if len(columns) == 0 {
+		return out, fmt.Errorf("table public.admin_action_logs not found; is this a Mastodon database?")
+	}
+	out.RecordedChanges = columns["recorded_changes"]
+	out.HumanIdentifier = columns["human_identifier"]
+	out.RouteParam = columns["route_param"]
+	out.Permalink = columns["permalink"]
+	return out, nil
+}
 
-	args[0] = block.CreatedAt
-	args[1] = block.UpdatedAt
-	args[2] = ServerAccountID
-	args[3] = ActionDestroy
-	args[4] = TargetTypeDomainBlock
-	args[5] = block.ID
+// MastodonAdminActionLogRow returns the columns and values of the
+// admin_action_logs row that records action being taken on block.  The
+// values match what Mastodon's Admin::ActionLog fills in for a DomainBlock
+// target: the domain serves as the human-readable identifier, and since
+// DomainBlock defines neither a route parameter nor a permalink, both are
+// NULL.
+func MastodonAdminActionLogRow(logColumns MastodonAdminActionLogColumns, accountID int64, action string, block MastodonDomainBlock) ([]string, []any) {
+	columns := make([]string, 0, 10)
+	values := make([]any, 0, 10)
+	add := func(column string, value any) {
+		columns = append(columns, column)
+		values = append(values, value)
+	}
 
-	var n int
-	switch flagSoftware {
-	case Mastodon3x:
-		n = 7
-		args[6] = block.AsYAML()
-		sql = SQLInsertAdminActionLogMastodon3x
-	default:
-		n = 9
-		args[6] = block.Domain
-		args[7] = ""
-		args[8] = ""
-		sql = SQLInsertAdminActionLogMastodon4x
+	add("created_at", block.CreatedAt)
+	add("updated_at", block.UpdatedAt)
+	add("account_id", accountID)
+	add("action", action)
+	add("target_type", TargetTypeDomainBlock)
+	add("target_id", block.ID)
+	if logColumns.RecordedChanges {
+		add("recorded_changes", block.AsYAML())
+	}
+	if logColumns.HumanIdentifier {
+		add("human_identifier", block.Domain)
 	}
+	if logColumns.RouteParam {
+		add("route_param", nil)
+	}
+	if logColumns.Permalink {
+		add("permalink", nil)
+	}
+	return columns, values
+}
+
+func InsertMastodonAdminActionLog(ctx context.Context, tx pgx.Tx, logColumns MastodonAdminActionLogColumns, accountID int64, action string, block MastodonDomainBlock) error {
+	columns, values := MastodonAdminActionLogRow(logColumns, accountID, action, block)
 
-	_, err = tx.Exec(ctx, sql, args[:n]...)
+	placeholders := make([]string, len(columns))
+	for i := range placeholders {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+	}
+	sql := "INSERT INTO public.admin_action_logs (" + strings.Join(columns, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")"
+
+	_, err := tx.Exec(ctx, sql, values...)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: failed to Exec %q: %v\n", sql, err)
-		os.Exit(1)
+		return fmt.Errorf("failed to Exec %q: %w", sql, err)
 	}
+	return nil
 }
 
 type MastodonDomainBlock struct {
-	ID             uint64
-	Domain         string
-	PrivateComment string
-	PublicComment  string
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Severity       int
-	RejectMedia    bool
-	RejectReports  bool
-	Obfuscate      bool
+	ID             uint64    `json:"id,omitempty"`
+	Domain         string    `json:"domain"`
+	PrivateComment string    `json:"privateComment"`
+	PublicComment  string    `json:"publicComment"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+	Severity       Severity  `json:"severity"`
+	RejectMedia    bool      `json:"rejectMedia"`
+	RejectReports  bool      `json:"rejectReports"`
+	Obfuscate      bool      `json:"obfuscate"`
 }
 
 func (block MastodonDomainBlock) AsYAML() string {
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMastodonAdminActionLogRow(t *testing.T) {
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	block := MastodonDomainBlock{
+		ID:             42,
+		Domain:         "bad.example",
+		PrivateComment: WellKnownPrivateComment,
+		PublicComment:  "spam",
+		CreatedAt:      now.Add(-time.Hour),
+		UpdatedAt:      now,
+		Severity:       SeveritySuspend,
+	}
+	const accountID = 7
+
+	type testCase struct {
+		name    string
+		columns MastodonAdminActionLogColumns
+		want    map[string]any
+	}
+	common := func(action string) map[string]any {
+		return map[string]any{
+			"created_at":  block.CreatedAt,
+			"updated_at":  block.UpdatedAt,
+			"account_id":  int64(accountID),
+			"action":      action,
+			"target_type": TargetTypeDomainBlock,
+			"target_id":   block.ID,
+		}
+	}
+	with := func(m map[string]any, extra map[string]any) map[string]any {
+		for k, v := range extra {
+			m[k] = v
+		}
+		return m
+	}
+
+	columnSets := []struct {
+		name    string
+		columns MastodonAdminActionLogColumns
+		extra   map[string]any
+	}{
+		{
+			name:    "mastodon-3x",
+			columns: MastodonAdminActionLogColumns{RecordedChanges: true},
+			extra:   map[string]any{"recorded_changes": block.AsYAML()},
+		},
+		{
+			name:    "mastodon-4x-before-post-deployment",
+			columns: MastodonAdminActionLogColumns{RecordedChanges: true, HumanIdentifier: true, RouteParam: true, Permalink: true},
+			extra:   map[string]any{"recorded_changes": block.AsYAML(), "human_identifier": block.Domain, "route_param": nil, "permalink": nil},
+		},
+		{
+			name:    "mastodon-4x",
+			columns: MastodonAdminActionLogColumns{HumanIdentifier: true, RouteParam: true, Permalink: true},
+			extra:   map[string]any{"human_identifier": block.Domain, "route_param": nil, "permalink": nil},
+		},
+	}
+	actions := []struct {
+		planAction PlanAction
+		logAction  string
+	}{
+		{InsertAction, ActionCreate},
+		{UpdateAction, ActionUpdate},
+		{DeleteAction, ActionDestroy},
+	}
+
+	var testCases []testCase
+	for _, set := range columnSets {
+		for _, action := range actions {
+			testCases = append(testCases, testCase{
+				name:    set.name + "/" + action.planAction.String(),
+				columns: set.columns,
+				want:    with(common(action.logAction), set.extra),
+			})
+		}
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			action := tc.want["action"].(string)
+			columns, values := MastodonAdminActionLogRow(tc.columns, accountID, action, block)
+			if len(columns) != len(values) {
+				t.Fatalf("got %d columns but %d values", len(columns), len(values))
+			}
+			got := make(map[string]any, len(columns))
+			for i, column := range columns {
+				if _, dup := got[column]; dup {
+					t.Errorf("column %q appears twice", column)
+				}
+				got[column] = values[i]
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("row mismatch:\n\tgot  %#v\n\twant %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseMastodonActingAccount(t *testing.T) {
+	type testCase struct {
+		spec         string
+		wantID       int64
+		wantUsername string
+		wantErr      bool
+	}
+	testCases := []testCase{
+		{spec: "rapidblock", wantUsername: "rapidblock"},
+		{spec: "@rapidblock", wantUsername: "rapidblock"},
+		{spec: "12345", wantUsername: "12345"},
+		{spec: "id:12345", wantID: 12345},
+		{spec: "id:-99", wantID: ServerAccountID},
+		{spec: "id:alice", wantErr: true},
+		{spec: "alice@remote.example", wantErr: true},
+		{spec: "@", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.spec, func(t *testing.T) {
+			id, username, err := ParseMastodonActingAccount(tc.spec)
+			switch {
+			case tc.wantErr && err == nil:
+				t.Fatalf("expected an error, got ID %d, username %q", id, username)
+			case !tc.wantErr && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case id != tc.wantID || username != tc.wantUsername:
+				t.Errorf("expected ID %d, username %q, got ID %d, username %q", tc.wantID, tc.wantUsername, id, username)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+)
+
+// cmdChangePassphrase re-encrypts a private key under a new passphrase.  It
+// also accepts an unencrypted key, so it doubles as the way to encrypt an
+// existing key.  A minisign secret key stays a minisign secret key.
+func cmdChangePassphrase() {
+	key := selectedKey()
+
+	switch {
+	case key.PrivateKeyFile == "":
+		fmt.Fprintf(os.Stderr, "fatal: missing required flag -k / --private-key-file\n")
+		os.Exit(1)
+	}
+
+	var pubKey ed25519.PublicKey
+	if key.PublicKeyFile != "" {
+		pubKey, _ = readPublicKey(key.PublicKeyFile)
+	}
+	privKey, keyID := readPrivateKey(key.PrivateKeyFile)
+	if pubKey != nil {
+		checkKeyPair(pubKey, privKey)
+	}
+
+	passphrase, err := readPassphrase(fmt.Sprintf("New passphrase for %q: ", key.PrivateKeyFile), flagNewPassphraseFD, "--new-passphrase-fd", NewPassphraseEnv, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", key.PrivateKeyFile, err)
+		os.Exit(1)
+	}
+
+	var raw []byte
+	if keyID != nil {
+		raw = encodeMinisignSecretKey(key.PrivateKeyFile, privKey, keyID, passphrase)
+	} else {
+		raw = encryptPrivateKey(key.PrivateKeyFile, privKey.Seed(), passphrase)
+	}
+	err = WriteFileAtomic(key.PrivateKeyFile, raw, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(1)
+	}
+}
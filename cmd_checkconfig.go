@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func cmdCheckConfig() {
+	switch {
+	case flagConfigFile == "":
+		fmt.Fprintf(os.Stderr, "fatal: missing required flag -C / --config-file\n")
+		os.Exit(1)
+	}
+
+	// Validation errors do not stop us from checking the files, so that
+	// every problem is reported in a single pass.
+	cfg, err := LoadConfig(flagConfigFile)
+	errs, isList := err.(ErrorList)
+	if err != nil && !isList {
+		exitWithErrors(err)
+	}
+	errs = appendErrors(errs, cfg.Check())
+	if len(errs) > 0 {
+		exitWithErrors(errs)
+	}
+
+	fmt.Printf("OK: %d key(s), %d source(s), %d instance(s)\n", len(cfg.Keys), len(cfg.Sources), len(cfg.Instances))
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func cmdDaemon() {
+	switch {
+	case flagConfigFile == "":
+		fmt.Fprintf(os.Stderr, "fatal: missing required flag -C / --config-file\n")
+		os.Exit(1)
+	}
+
+	signals := make(chan os.Signal, 4)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
+
+	d, err := NewScheduler(flagConfigFile, gHTTPClient)
+	if err != nil {
+		exitWithErrors(err)
+	}
+
+	err = d.Run(context.Background(), signals)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -55,8 +55,16 @@ func (list firstColumnDomainNameSort) Len() int {
 }
 
 func (list firstColumnDomainNameSort) Less(i, j int) bool {
-	a := list[i][0]
-	b := list[j][0]
+	return domainNameLess(list[i][0], list[j][0])
+}
+
+func (list firstColumnDomainNameSort) Swap(i, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+
+var _ sort.Interface = firstColumnDomainNameSort(nil)
+
+func domainNameLess(a string, b string) bool {
 	aList := splitDomainName(a)
 	bList := splitDomainName(b)
 	aLen := uint(len(aList))
@@ -78,12 +86,6 @@ func (list firstColumnDomainNameSort) Less(i, j int) bool {
 	return aLen < bLen
 }
 
-func (list firstColumnDomainNameSort) Swap(i, j int) {
-	list[i], list[j] = list[j], list[i]
-}
-
-var _ sort.Interface = firstColumnDomainNameSort(nil)
-
 var splitDomainNameCache map[string][]string
 
 func splitDomainName(str string) []string {
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+func cmdFetch() {
+	sources := selectedSources()
+	exitCode := 0
+	for _, src := range sources {
+		code := fetchSource(src, len(sources) > 1)
+		if code > exitCode {
+			exitCode = code
+		}
+	}
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
+// fetchSource fetches one source and returns the exit status.  When several
+// sources are being fetched, failures are reported as errors against the
+// source's name, and the remaining sources are still fetched.
+func fetchSource(src SourceConfig, isMulti bool) int {
+	prefix := ""
+	level := "fatal"
+	if isMulti {
+		prefix = src.Name + ": "
+		level = "error"
+	}
+
+	var missing string
+	switch {
+	case src.BlocklistURL == "":
+		missing = "-u / --blocklist-url"
+	case src.SignatureURL == "":
+		missing = "-U / --signature-url"
+	case src.PublicKeyFile == "" && src.Keyring == "":
+		missing = "-p / --public-key-file or --keyring"
+	case src.DataFile == "":
+		missing = "-d / --data-file"
+	case src.SigFile == "":
+		missing = "-s / --signature-file"
+	case src.KeyTransitionURL != "" && src.KeyTransitionFile == "":
+		missing = "--key-transition-file"
+	}
+	if missing != "" {
+		fmt.Fprintf(os.Stderr, "%s: %smissing required flag %s\n", level, prefix, missing)
+		return 1
+	}
+
+	opts, err := src.FetchOptions()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s%v\n", level, prefix, err)
+		return 1
+	}
+
+	ctx := context.Background()
+	result, err := FetchBlocklist(ctx, gHTTPClient, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s%v\n", level, prefix, err)
+		return 1
+	}
+
+	if result.Changed {
+		fmt.Printf("OK: %supdated\n", prefix)
+	} else {
+		fmt.Printf("OK: %snot modified\n", prefix)
+	}
+	return 0
+}
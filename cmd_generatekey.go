@@ -7,23 +7,57 @@ import (
 )
 
 func cmdGenerateKey() {
+	key := selectedKey()
+
 	switch {
-	case flagPublicKeyFile == "":
+	case key.PublicKeyFile == "":
 		fmt.Fprintf(os.Stderr, "fatal: missing required flag -p / --public-key-file\n")
 		os.Exit(1)
 
-	case flagPrivateKeyFile == "":
+	case key.PrivateKeyFile == "":
 		fmt.Fprintf(os.Stderr, "fatal: missing required flag -k / --private-key-file\n")
 		os.Exit(1)
 	}
 
+	switch flagKeyFormat {
+	case KeyFormatBase64:
+	case KeyFormatMinisign:
+	default:
+		fmt.Fprintf(os.Stderr, "fatal: unknown value %q for --key-format flag, expected one of: %s\n", flagKeyFormat, AllKeyFormats)
+		os.Exit(1)
+	}
+
 	pubKey, privKey, err := ed25519.GenerateKey(nil)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "fatal: failed to generate key: %v\n", err)
 		os.Exit(1)
 	}
 
+	var passphrase []byte
+	if flagEncrypt {
+		passphrase, err = readPassphrase(fmt.Sprintf("New passphrase for %q: ", key.PrivateKeyFile), flagPassphraseFD, "--passphrase-fd", PassphraseEnv, true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", key.PrivateKeyFile, err)
+			os.Exit(1)
+		}
+	}
+
+	if flagKeyFormat == KeyFormatMinisign {
+		keyID, err := NewMinisignKeyID()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+			os.Exit(1)
+		}
+		WriteFile(key.PublicKeyFile, MinisignPublicKey{KeyID: keyID, PublicKey: pubKey}.Encode(), false)
+		WriteFile(key.PrivateKeyFile, encodeMinisignSecretKey(key.PrivateKeyFile, privKey, keyID, passphrase), true)
+		return
+	}
+
 	seed := privKey.Seed()
-	WriteKeySigFile(flagPublicKeyFile, pubKey[:], false)
-	WriteKeySigFile(flagPrivateKeyFile, seed[:], true)
+	WriteKeySigFile(key.PublicKeyFile, pubKey[:], false)
+	if passphrase != nil {
+		WriteFile(key.PrivateKeyFile, encryptPrivateKey(key.PrivateKeyFile, seed, passphrase), true)
+	} else {
+		WriteKeySigFile(key.PrivateKeyFile, seed[:], true)
+	}
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+)
+
+// ImpactEntry is one domain in the output of impact mode.
+type ImpactEntry struct {
+	Domain   string     `json:"domain"`
+	Action   PlanAction `json:"action"`
+	Severity Severity   `json:"severity"`
+	DomainImpact
+}
+
+func cmdImpact() {
+	switch flagFormat {
+	case FormatText:
+	case FormatJSON:
+	default:
+		fmt.Fprintf(os.Stderr, "fatal: unknown value %q for -f / --format flag, expected one of: %s\n", flagFormat, AllFormats)
+		os.Exit(1)
+	}
+
+	inst := selectedInstance()
+
+	switch {
+	case inst.DataFile == "":
+		fmt.Fprintf(os.Stderr, "fatal: missing required flag -d / --data-file\n")
+		os.Exit(1)
+	case inst.DatabaseURL == "":
+		fmt.Fprintf(os.Stderr, "fatal: missing required flag -D / --database-url\n")
+		os.Exit(1)
+	}
+
+	impactFn, found := ImpactFuncFor(inst.Software)
+	if !found {
+		fmt.Fprintf(os.Stderr, "fatal: software %q not implemented\n", inst.Software)
+		os.Exit(1)
+	}
+
+	var file BlockFile
+	err := LoadJsonFile(&file, inst.DataFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(1)
+	}
+
+	opts, err := inst.ApplyOptions()
+	if err != nil {
+		exitWithErrors(err)
+	}
+
+	ctx := context.Background()
+	plan, err := impactFn(ctx, file, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(1)
+	}
+
+	entries := ImpactEntries(plan, int64(flagImpactThreshold))
+
+	if flagFormat == FormatJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		e.SetEscapeHTML(false)
+		err = e.Encode(entries)
+	} else {
+		err = writeImpactText(entries)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: failed to write output: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// ImpactFunc computes the plan for applying file, without executing it,
+// and fills in the Impact of each item that blocks something new.
+type ImpactFunc func(context.Context, BlockFile, ApplyOptions) (Plan, error)
+
+func ImpactFuncFor(software string) (ImpactFunc, bool) {
+	switch software {
+	case Mastodon3x:
+		return ImpactMastodon, true
+	case Mastodon4x:
+		return ImpactMastodon, true
+	case MastodonAuto:
+		return ImpactMastodon, true
+	default:
+		return nil, false
+	}
+}
+
+func ImpactMastodon(ctx context.Context, file BlockFile, opts ApplyOptions) (Plan, error) {
+	conn, err := pgx.Connect(ctx, opts.DatabaseURL)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to Begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	_, err = CheckMastodonSchema(ctx, tx, opts.Software)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	existingBlocks, err := GetMastodonDomainBlocks(ctx, tx)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	plan := PlanMastodon(file, opts.Policy, opts.Overrides, existingBlocks, time.Now().UTC())
+	err = AddMastodonImpact(ctx, tx, &plan)
+	if err != nil {
+		return plan, err
+	}
+	return plan, nil
+}
+
+// ImpactEntries lists the items of plan that would cut off at least
+// threshold local users, most affected first.
+func ImpactEntries(plan Plan, threshold int64) []ImpactEntry {
+	entries := make([]ImpactEntry, 0, len(plan.Items))
+	for _, item := range plan.Items {
+		if item.Impact == nil || item.Impact.LocalUsers < threshold {
+			continue
+		}
+		entries = append(entries, ImpactEntry{
+			Domain:       item.Domain,
+			Action:       item.Action,
+			Severity:     item.New.Severity,
+			DomainImpact: *item.Impact,
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].LocalUsers > entries[j].LocalUsers
+	})
+	return entries
+}
+
+func writeImpactText(entries []ImpactEntry) error {
+	if len(entries) <= 0 {
+		_, err := fmt.Printf("no new blocks affect %d or more local users\n", flagImpactThreshold)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOMAIN\tACTION\tSEVERITY\tREMOTE ACCOUNTS\tLOCAL USERS\tFOLLOWS\tFOLLOWERS")
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\n", entry.Domain, entry.Action, entry.Severity, entry.RemoteAccounts, entry.LocalUsers, entry.Follows, entry.Followers)
+	}
+	return tw.Flush()
+}
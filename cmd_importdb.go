@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+)
+
+func cmdImportDB() {
+	inst := selectedInstance()
+
+	switch {
+	case flagDataFile == "":
+		fmt.Fprintf(os.Stderr, "fatal: missing required flag -d / --data-file\n")
+		os.Exit(1)
+	case inst.DatabaseURL == "":
+		fmt.Fprintf(os.Stderr, "fatal: missing required flag -D / --database-url\n")
+		os.Exit(1)
+	}
+
+	importFn, found := ImportFuncFor(inst.Software)
+	if !found {
+		fmt.Fprintf(os.Stderr, "fatal: software %q not implemented\n", inst.Software)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	file, err := importFn(ctx, inst.Software, inst.DatabaseURL, flagLocalOnly)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(1)
+	}
+
+	WriteJsonFile(flagDataFile, file, false)
+	fmt.Printf("exported %d block(s)\n", len(file.Blocks))
+}
+
+// ImportFunc reads an instance's existing domain blocks as a BlockFile.  If
+// localOnly is true, blocks that were created by RapidBlock are left out.
+type ImportFunc func(ctx context.Context, software string, databaseURL string, localOnly bool) (BlockFile, error)
+
+func ImportFuncFor(software string) (ImportFunc, bool) {
+	switch software {
+	case Mastodon3x:
+		return ImportMastodon, true
+	case Mastodon4x:
+		return ImportMastodon, true
+	case MastodonAuto:
+		return ImportMastodon, true
+	default:
+		return nil, false
+	}
+}
+
+func ImportMastodon(ctx context.Context, software string, databaseURL string, localOnly bool) (BlockFile, error) {
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		return BlockFile{}, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return BlockFile{}, fmt.Errorf("failed to Begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	_, err = CheckMastodonSchema(ctx, tx, software)
+	if err != nil {
+		return BlockFile{}, err
+	}
+
+	existingBlocks, err := GetMastodonDomainBlocks(ctx, tx)
+	if err != nil {
+		return BlockFile{}, err
+	}
+
+	return BlockFileFromMastodon(existingBlocks, localOnly, time.Now().UTC()), nil
+}
+
+// BlockFileFromMastodon converts domain blocks into the v1 blockfile format.
+// Each block is tagged with its severity, and with reject_media,
+// reject_reports, and obfuscate if those are set, so that a policy file can
+// map it back to the same kind of block when the file is applied elsewhere.
+// Blocks with severity noop only reject media or reports, so they are
+// exported as not blocked: applying them as blocks would turn them into
+// suspensions under the default policy.
+func BlockFileFromMastodon(blocks map[string]MastodonDomainBlock, localOnly bool, now time.Time) BlockFile {
+	var file BlockFile
+	file.Spec = BlockFileSpecV1
+	file.PublishedAt = now
+	file.Blocks = make(map[string]Block, len(blocks))
+	for domain, row := range blocks {
+		if localOnly && row.PrivateComment == WellKnownPrivateComment {
+			continue
+		}
+		tags := []string{row.Severity.String()}
+		if row.RejectMedia {
+			tags = append(tags, "reject_media")
+		}
+		if row.RejectReports {
+			tags = append(tags, "reject_reports")
+		}
+		if row.Obfuscate {
+			tags = append(tags, "obfuscate")
+		}
+		file.Blocks[domain] = Block{
+			IsBlocked:     row.Severity != SeverityNoOp,
+			Reason:        row.PublicComment,
+			Tags:          tags,
+			DateRequested: row.CreatedAt.UTC(),
+			DateDecided:   row.UpdatedAt.UTC(),
+		}
+	}
+	return file
+}
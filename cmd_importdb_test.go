@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBlockFileFromMastodon(t *testing.T) {
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	type testCase struct {
+		name      string
+		row       MastodonDomainBlock
+		localOnly bool
+		want      *Block
+	}
+	testCases := []testCase{
+		{
+			name: "suspend",
+			row:  MastodonDomainBlock{Severity: SeveritySuspend, PublicComment: "spam", RejectMedia: true, RejectReports: true},
+			want: &Block{IsBlocked: true, Reason: "spam", Tags: []string{"suspend", "reject_media", "reject_reports"}},
+		},
+		{
+			name: "silence-obfuscated",
+			row:  MastodonDomainBlock{Severity: SeveritySilence, Obfuscate: true},
+			want: &Block{IsBlocked: true, Tags: []string{"silence", "obfuscate"}},
+		},
+		{
+			name: "media-only",
+			row:  MastodonDomainBlock{Severity: SeverityNoOp, RejectMedia: true},
+			want: &Block{IsBlocked: false, Tags: []string{"noop", "reject_media"}},
+		},
+		{
+			name: "noop",
+			row:  MastodonDomainBlock{Severity: SeverityNoOp},
+			want: &Block{IsBlocked: false, Tags: []string{"noop"}},
+		},
+		{
+			name:      "local-only-skips-managed",
+			row:       MastodonDomainBlock{Severity: SeveritySuspend, PrivateComment: WellKnownPrivateComment},
+			localOnly: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.row.Domain = "bad.example"
+			tc.row.CreatedAt = now.Add(-time.Hour)
+			tc.row.UpdatedAt = now
+			file := BlockFileFromMastodon(map[string]MastodonDomainBlock{tc.row.Domain: tc.row}, tc.localOnly, now)
+			got, found := file.Blocks[tc.row.Domain]
+			if tc.want == nil {
+				if found {
+					t.Fatalf("expected no block, got %+v", got)
+				}
+				return
+			}
+			want := *tc.want
+			want.DateRequested = tc.row.CreatedAt
+			want.DateDecided = tc.row.UpdatedAt
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("block mismatch:\n\tgot  %#v\n\twant %#v", got, want)
+			}
+		})
+	}
+}
@@ -1,19 +1,19 @@
 package main
 
 import (
-	"crypto/ed25519"
-	"encoding/base64"
 	"fmt"
 	"os"
 )
 
 func cmdSign() {
+	key := selectedKey()
+
 	switch {
-	case flagPublicKeyFile == "":
+	case key.PublicKeyFile == "":
 		fmt.Fprintf(os.Stderr, "fatal: missing required flag -p / --public-key-file\n")
 		os.Exit(1)
 
-	case flagPrivateKeyFile == "":
+	case key.PrivateKeyFile == "":
 		fmt.Fprintf(os.Stderr, "fatal: missing required flag -k / --private-key-file\n")
 		os.Exit(1)
 
@@ -26,15 +26,19 @@ func cmdSign() {
 		os.Exit(1)
 	}
 
-	pubKey := ed25519.PublicKey(ReadKeySigFile(flagPublicKeyFile, ed25519.PublicKeySize))
-	privKey := ed25519.NewKeyFromSeed(ReadKeySigFile(flagPrivateKeyFile, ed25519.SeedSize))
-	computedPubKey := privKey.Public().(ed25519.PublicKey)
-	if !pubKey.Equal(computedPubKey) {
-		str0 := base64.StdEncoding.EncodeToString(computedPubKey[:])
-		str1 := base64.StdEncoding.EncodeToString(pubKey[:])
-		fmt.Fprintf(os.Stderr, "fatal: private key does not match public key!\n\tEd25519 public key calculated from private key: %s\n\tEd25519 public key provided: %s\n", str0, str1)
+	switch flagSignatureFormat {
+	case SigFormatEnvelope:
+	case SigFormatLegacy:
+	case SigFormatMinisign:
+	default:
+		fmt.Fprintf(os.Stderr, "fatal: unknown value %q for --signature-format flag, expected one of: %s\n", flagSignatureFormat, AllSigFormats)
+		os.Exit(1)
 	}
 
-	checksum := checksumFile(flagDataFile, flagText)
-	signFile(privKey, pubKey, checksum, flagSigFile)
+	pubKey, privKey, keyID := readKeyPair(key)
+	if flagSignatureFormat == SigFormatMinisign {
+		signFileMinisign(privKey, pubKey, keyID, flagDataFile, flagSigFile)
+		return
+	}
+	signFile(privKey, pubKey, flagDataFile, flagText, flagSigFile)
 }
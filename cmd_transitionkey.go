@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func cmdTransitionKey() {
+	key := selectedKey()
+
+	switch {
+	case key.PublicKeyFile == "":
+		fmt.Fprintf(os.Stderr, "fatal: missing required flag -p / --public-key-file\n")
+		os.Exit(1)
+
+	case key.PrivateKeyFile == "":
+		fmt.Fprintf(os.Stderr, "fatal: missing required flag -k / --private-key-file\n")
+		os.Exit(1)
+
+	case flagNewPublicKeyFile == "":
+		fmt.Fprintf(os.Stderr, "fatal: missing required flag --new-public-key-file\n")
+		os.Exit(1)
+
+	case flagKeyTransitionFile == "":
+		fmt.Fprintf(os.Stderr, "fatal: missing required flag --key-transition-file\n")
+		os.Exit(1)
+
+	case flagOverlap < 0:
+		fmt.Fprintf(os.Stderr, "fatal: --overlap must not be negative\n")
+		os.Exit(1)
+	}
+
+	pubKey, privKey, _ := readKeyPair(key)
+	newPubKey, _ := readPublicKey(flagNewPublicKeyFile)
+	if newPubKey.Equal(pubKey) {
+		fmt.Fprintf(os.Stderr, "fatal: %q: new key is the same as the current key\n", flagNewPublicKeyFile)
+		os.Exit(1)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	stmt := KeyTransition{
+		Spec:       KeyTransitionSpecV1,
+		OldKey:     KeyFingerprint(pubKey),
+		NewKey:     newPubKey,
+		NewKeyName: strings.TrimSuffix(filepath.Base(flagNewPublicKeyFile), keyringPubSuffix),
+		NotBefore:  now,
+	}
+	if flagOverlap > 0 {
+		notAfter := now.Add(flagOverlap)
+		stmt.OldKeyNotAfter = &notAfter
+	}
+
+	t, err := NewSignedKeyTransition(privKey, stmt, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", flagKeyTransitionFile, err)
+		os.Exit(1)
+	}
+	raw, err := t.Encode()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", flagKeyTransitionFile, err)
+		os.Exit(1)
+	}
+
+	// Check the statement as a subscriber would see it.
+	var kr Keyring
+	kr.Add(TrustedKey{PublicKey: pubKey})
+	t, err = DecodeKeyTransition(raw)
+	if err == nil {
+		err = kr.ApplyTransition(t, now)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: failed to verify key transition after creation: %v\n", err)
+		os.Exit(1)
+	}
+
+	WriteFile(flagKeyTransitionFile, raw, false)
+}
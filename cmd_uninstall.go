@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+)
+
+// ConvertedPrivateComment replaces WellKnownPrivateComment on blocks that
+// uninstall --keep-blocks hands over to the local admins, so that future
+// runs of apply treat them as local decisions.
+const ConvertedPrivateComment = "Formerly managed by RapidBlock"
+
+const (
+	UninstallReasonRemove  = "uninstall"
+	UninstallReasonConvert = "uninstall, converted to local block"
+)
+
+func cmdUninstall() {
+	inst := selectedInstance()
+
+	switch {
+	case inst.DatabaseURL == "":
+		fmt.Fprintf(os.Stderr, "fatal: missing required flag -D / --database-url\n")
+		os.Exit(1)
+	}
+
+	uninstallFn, found := UninstallFuncFor(inst.Software)
+	if !found {
+		fmt.Fprintf(os.Stderr, "fatal: software %q not implemented\n", inst.Software)
+		os.Exit(1)
+	}
+
+	switch flagFormat {
+	case FormatText:
+	case FormatJSON:
+	default:
+		fmt.Fprintf(os.Stderr, "fatal: unknown value %q for -f / --format flag, expected one of: %s\n", flagFormat, AllFormats)
+		os.Exit(1)
+	}
+
+	opts := UninstallOptions{
+		Software:      inst.Software,
+		DatabaseURL:   inst.DatabaseURL,
+		ActingAccount: inst.ActingAccount,
+		LockTimeout:   inst.LockTimeout,
+		DryRun:        flagDryRun,
+		Force:         flagForce,
+		KeepBlocks:    flagKeepBlocks,
+		Limits:        inst.Limits,
+	}
+
+	ctx := context.Background()
+	startedAt := time.Now().UTC()
+	plan, err := uninstallFn(ctx, opts)
+	finishedAt := time.Now().UTC()
+
+	var limitErr *LimitError
+	var lockedErr *LockedError
+	switch {
+	case errors.As(err, &lockedErr):
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(ExitLocked)
+
+	case errors.As(err, &limitErr):
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		writeApplyOutput(inst, BlockFile{}, plan, false, limitErr, startedAt, finishedAt)
+		fmt.Fprintf(os.Stderr, "fatal: refusing to commit; review the plan above and re-run with --force to apply it anyway\n")
+		os.Exit(ExitLimitExceeded)
+
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(1)
+
+	case flagFormat == FormatText && !flagDryRun:
+		totals := plan.Totals()
+		if totals.Updated > 0 {
+			fmt.Printf("converted %d block(s) to local blocks\n", totals.Updated)
+		}
+		if totals.Deleted > 0 {
+			fmt.Printf("deleted %d block(s)\n", totals.Deleted)
+		}
+		fmt.Println("OK: RapidBlock has been removed from this instance")
+		return
+	}
+
+	writeApplyOutput(inst, BlockFile{}, plan, !flagDryRun, nil, startedAt, finishedAt)
+}
+
+type UninstallOptions struct {
+	Software      string
+	DatabaseURL   string
+	ActingAccount string
+	LockTimeout   time.Duration
+	DryRun        bool
+	Force         bool
+	KeepBlocks    bool
+	Limits        Limits
+}
+
+// UninstallFunc removes every block that RapidBlock manages, or if
+// opts.KeepBlocks is set, hands them over to the local admins.
+type UninstallFunc func(context.Context, UninstallOptions) (Plan, error)
+
+func UninstallFuncFor(software string) (UninstallFunc, bool) {
+	switch software {
+	case Mastodon3x:
+		return UninstallMastodon, true
+	case Mastodon4x:
+		return UninstallMastodon, true
+	case MastodonAuto:
+		return UninstallMastodon, true
+	default:
+		return nil, false
+	}
+}
+
+func UninstallMastodon(ctx context.Context, opts UninstallOptions) (Plan, error) {
+	conn, err := pgx.Connect(ctx, opts.DatabaseURL)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var plan Plan
+	err = RetryTx(ctx, func() error {
+		var err error
+		plan, err = uninstallMastodonTx(ctx, conn, opts)
+		return err
+	})
+	return plan, err
+}
+
+func uninstallMastodonTx(ctx context.Context, conn *pgx.Conn, opts UninstallOptions) (Plan, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to Begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if !opts.DryRun {
+		err = LockAdvisory(ctx, tx, opts.LockTimeout)
+		if err != nil {
+			return Plan{}, err
+		}
+	}
+
+	software, err := CheckMastodonSchema(ctx, tx, opts.Software)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	accountID, err := ResolveMastodonActingAccount(ctx, tx, software, opts.ActingAccount)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	existingBlocks, err := GetMastodonDomainBlocks(ctx, tx)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	plan := PlanMastodonUninstall(existingBlocks, opts.KeepBlocks, time.Now().UTC())
+	if opts.DryRun {
+		return plan, nil
+	}
+
+	if !opts.Force {
+		err = opts.Limits.Check(plan.Totals(), CountManagedMastodonBlocks(existingBlocks))
+		if err != nil {
+			return plan, err
+		}
+	}
+
+	err = ExecuteMastodonPlan(ctx, tx, accountID, plan)
+	if err != nil {
+		return plan, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return plan, fmt.Errorf("failed to Commit transaction: %w", err)
+	}
+	return plan, nil
+}
+
+// PlanMastodonUninstall plans the removal of every domain_blocks row that
+// carries WellKnownPrivateComment.  Blocks made by the local admins are not
+// touched.
+func PlanMastodonUninstall(existingBlocks map[string]MastodonDomainBlock, keepBlocks bool, now time.Time) Plan {
+	var plan Plan
+	for domain, existing := range existingBlocks {
+		if existing.PrivateComment != WellKnownPrivateComment {
+			continue
+		}
+
+		old := existing
+		if keepBlocks {
+			updated := existing
+			updated.PrivateComment = ConvertedPrivateComment
+			updated.UpdatedAt = now
+			plan.Add(PlanItem{Domain: domain, Action: UpdateAction, Old: &old, New: &updated, Reason: UninstallReasonConvert})
+			continue
+		}
+
+		old.UpdatedAt = now
+		plan.Add(PlanItem{Domain: domain, Action: DeleteAction, Old: &old, Reason: UninstallReasonRemove})
+	}
+	plan.Sort()
+	return plan
+}
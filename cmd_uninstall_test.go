@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlanMastodonUninstall(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	newExistingBlocks := func() map[string]MastodonDomainBlock {
+		return map[string]MastodonDomainBlock{
+			"b.example":     newTestManagedBlock("b.example", "spam", created),
+			"a.example":     newTestManagedBlock("a.example", "harassment", created),
+			"local.example": {Domain: "local.example", PrivateComment: "blocked by hand", Severity: SeveritySilence},
+		}
+	}
+
+	type wantItem struct {
+		domain string
+		action PlanAction
+		reason string
+	}
+	type testCase struct {
+		name       string
+		keepBlocks bool
+		want       []wantItem
+	}
+	testCases := []testCase{
+		{
+			name: "remove",
+			want: []wantItem{
+				{domain: "a.example", action: DeleteAction, reason: UninstallReasonRemove},
+				{domain: "b.example", action: DeleteAction, reason: UninstallReasonRemove},
+			},
+		},
+		{
+			name:       "keep-blocks",
+			keepBlocks: true,
+			want: []wantItem{
+				{domain: "a.example", action: UpdateAction, reason: UninstallReasonConvert},
+				{domain: "b.example", action: UpdateAction, reason: UninstallReasonConvert},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			existingBlocks := newExistingBlocks()
+			plan := PlanMastodonUninstall(existingBlocks, tc.keepBlocks, now)
+			var got []wantItem
+			for _, item := range plan.Items {
+				got = append(got, wantItem{domain: item.Domain, action: item.Action, reason: item.Reason})
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("PlanMastodonUninstall:\n\tgot  %+v\n\twant %+v", got, tc.want)
+			}
+			if !tc.keepBlocks {
+				return
+			}
+
+			for _, item := range plan.Items {
+				want := existingBlocks[item.Domain]
+				want.PrivateComment = ConvertedPrivateComment
+				want.UpdatedAt = now
+				if *item.New != want {
+					t.Errorf("%s: converted block:\n\tgot  %+v\n\twant %+v", item.Domain, *item.New, want)
+				}
+				existingBlocks[item.Domain] = *item.New
+			}
+
+			// Converted blocks are local decisions from then on.
+			file := BlockFile{Blocks: map[string]Block{"a.example": {IsBlocked: false}}}
+			for _, item := range PlanMastodon(file, DefaultPolicy(), Overrides{}, existingBlocks, now).Items {
+				if item.Action != SkipAction {
+					t.Errorf("PlanMastodon: expected converted block %s to be left alone, got %s", item.Domain, item.Action)
+				}
+			}
+		})
+	}
+}
@@ -1,28 +1,37 @@
 package main
 
 import (
-	"crypto/ed25519"
 	"fmt"
 	"os"
 )
 
 func cmdVerify() {
+	src := selectedSource()
+
 	switch {
-	case flagPublicKeyFile == "":
-		fmt.Fprintf(os.Stderr, "fatal: missing required flag -p / --public-key-file\n")
+	case src.PublicKeyFile == "" && src.Keyring == "":
+		fmt.Fprintf(os.Stderr, "fatal: missing required flag -p / --public-key-file or --keyring\n")
 		os.Exit(1)
 
-	case flagDataFile == "":
+	case src.DataFile == "":
 		fmt.Fprintf(os.Stderr, "fatal: missing required flag -d / --data-file\n")
 		os.Exit(1)
 
-	case flagSigFile == "":
+	case src.SigFile == "":
 		fmt.Fprintf(os.Stderr, "fatal: missing required flag -s / --signature-file\n")
 		os.Exit(1)
 	}
 
-	pubKey := ed25519.PublicKey(ReadKeySigFile(flagPublicKeyFile, ed25519.PublicKeySize))
-	checksum := checksumFile(flagDataFile, flagText)
-	verifyFile(pubKey, checksum, flagSigFile)
-	fmt.Println("OK")
+	keyring, err := src.LoadKeyring()
+	if err != nil {
+		exitWithErrors(err)
+	}
+	report := verifyFile(keyring, src.Threshold, src.DataFile, src.Text, src.SigFile)
+	for _, signer := range report.Signers {
+		fmt.Println(signer)
+	}
+	if report.TrustedComment != "" {
+		fmt.Printf("trusted comment: %s\n", report.TrustedComment)
+	}
+	fmt.Printf("OK: %d valid signer(s), %d required\n", report.ValidCount(), report.Threshold)
 }
@@ -0,0 +1,702 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	getopt "github.com/pborman/getopt/v2"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const DefaultCacheRoot = "/var/cache/rapidblock"
+
+// ErrorList collects every problem found while validating a configuration,
+// so that they can all be reported at once.
+type ErrorList []error
+
+func (errs ErrorList) Error() string {
+	strs := make([]string, len(errs))
+	for i, err := range errs {
+		strs[i] = err.Error()
+	}
+	return strings.Join(strs, "\n")
+}
+
+// exitWithErrors reports err and exits.  An ErrorList is reported one error
+// per line.
+func exitWithErrors(err error) {
+	if errs, ok := err.(ErrorList); ok {
+		printErrors(errs)
+		fmt.Fprintf(os.Stderr, "fatal: found %d error(s)\n", len(errs))
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+	os.Exit(1)
+}
+
+func printErrors(err error) {
+	if errs, ok := err.(ErrorList); ok {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+}
+
+func appendErrors(errs ErrorList, err error) ErrorList {
+	if list, ok := err.(ErrorList); ok {
+		return append(errs, list...)
+	}
+	if err != nil {
+		return append(errs, err)
+	}
+	return errs
+}
+
+// Config is the YAML configuration file named by -C / --config-file.  It
+// declares the keys used to sign blocklists, the blocklists to subscribe
+// to, and the instances to apply them to.  Any value given on the command
+// line overrides the value from the file.
+type Config struct {
+	Keys      []KeyConfig      `yaml:"keys"`
+	Sources   []SourceConfig   `yaml:"sources"`
+	Instances []InstanceConfig `yaml:"instances"`
+}
+
+type KeyConfig struct {
+	Name           string `yaml:"name"`
+	PublicKeyFile  string `yaml:"public_key_file"`
+	PrivateKeyFile string `yaml:"private_key_file"`
+}
+
+type SourceConfig struct {
+	Name              string `yaml:"name"`
+	BlocklistURL      string `yaml:"blocklist_url"`
+	SignatureURL      string `yaml:"signature_url"`
+	KeyTransitionURL  string `yaml:"key_transition_url"`
+	Key               string `yaml:"key"`
+	PublicKeyFile     string `yaml:"public_key_file"`
+	Keyring           string `yaml:"keyring"`
+	Threshold         int    `yaml:"threshold"`
+	Text              bool   `yaml:"text"`
+	CacheDir          string `yaml:"cache_dir"`
+	DataFile          string `yaml:"data_file"`
+	SigFile           string `yaml:"signature_file"`
+	KeyTransitionFile string `yaml:"key_transition_file"`
+}
+
+type InstanceConfig struct {
+	Name             string        `yaml:"name"`
+	Source           string        `yaml:"source"`
+	Software         string        `yaml:"software"`
+	DatabaseURL      string        `yaml:"database_url"`
+	APIURL           string        `yaml:"api_url"`
+	APITokenFile     string        `yaml:"api_token_file"`
+	ActingAccount    string        `yaml:"acting_account"`
+	SidekiqRedisURL  string        `yaml:"sidekiq_redis_url"`
+	SidekiqNamespace string        `yaml:"sidekiq_namespace"`
+	SidekiqSpoolDir  string        `yaml:"sidekiq_spool_dir"`
+	LockTimeout      time.Duration `yaml:"lock_timeout"`
+	DataFile         string        `yaml:"data_file"`
+	Policy           *Policy       `yaml:"policy"`
+	PolicyFile       string        `yaml:"policy_file"`
+	Overrides        []Override    `yaml:"overrides"`
+	OverrideFile     string        `yaml:"override_file"`
+	Limits           Limits        `yaml:"limits"`
+	Interval         time.Duration `yaml:"interval"`
+	Jitter           time.Duration `yaml:"jitter"`
+	RetryMin         time.Duration `yaml:"retry_min"`
+	RetryMax         time.Duration `yaml:"retry_max"`
+}
+
+func DefaultInstanceConfig() InstanceConfig {
+	return InstanceConfig{
+		Software: MastodonAuto,
+		Limits:   NoLimits(),
+		Interval: time.Hour,
+		Jitter:   15 * time.Minute,
+		RetryMin: time.Minute,
+		RetryMax: time.Hour,
+	}
+}
+
+func (inst *InstanceConfig) UnmarshalYAML(node *yaml.Node) error {
+	type rawInstanceConfig InstanceConfig
+	policy := DefaultPolicy()
+	raw := rawInstanceConfig(DefaultInstanceConfig())
+	raw.Policy = &policy
+	err := node.Decode(&raw)
+	if err != nil {
+		return err
+	}
+	if !yamlHasKey(node, "policy") {
+		raw.Policy = nil
+	}
+	*inst = InstanceConfig(raw)
+	return nil
+}
+
+func yamlHasKey(node *yaml.Node, key string) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
+var _ yaml.Unmarshaler = (*InstanceConfig)(nil)
+
+// LoadConfig reads and validates a configuration file, filling in the
+// values that are implied by other values: a source's public key file from
+// its named key, a source's data and signature files from its cache
+// directory, and an instance's source when there is only one to choose
+// from.  A source's key transition statement is cached next to its
+// blocklist, if it has a key_transition_url.
+func LoadConfig(filePath string) (Config, error) {
+	var cfg Config
+	err := LoadYamlFile(&cfg, filePath)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.resolve()
+
+	var errs ErrorList
+	for _, err := range cfg.Validate() {
+		errs = append(errs, fmt.Errorf("%q: %w", filePath, err))
+	}
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) resolve() {
+	for i := range cfg.Sources {
+		src := &cfg.Sources[i]
+		if key, found := cfg.Key(src.Key); found && src.PublicKeyFile == "" {
+			src.PublicKeyFile = key.PublicKeyFile
+		}
+		if src.CacheDir == "" && src.Name != "" {
+			src.CacheDir = filepath.Join(DefaultCacheRoot, src.Name)
+		}
+		if src.DataFile == "" && src.CacheDir != "" {
+			src.DataFile = filepath.Join(src.CacheDir, "blocklist.json")
+		}
+		if src.SigFile == "" && src.CacheDir != "" {
+			src.SigFile = filepath.Join(src.CacheDir, "blocklist.json.sig")
+		}
+		if src.Threshold == 0 {
+			src.Threshold = 1
+		}
+		if src.KeyTransitionFile == "" && src.KeyTransitionURL != "" && src.CacheDir != "" {
+			src.KeyTransitionFile = filepath.Join(src.CacheDir, "key"+keyringTransitionSuffix)
+		}
+	}
+	for i := range cfg.Instances {
+		inst := &cfg.Instances[i]
+		if inst.Source == "" && len(cfg.Sources) == 1 {
+			inst.Source = cfg.Sources[0].Name
+		}
+		if src, found := cfg.Source(inst.Source); found && inst.DataFile == "" {
+			inst.DataFile = src.DataFile
+		}
+		if inst.SidekiqSpoolDir == "" && inst.Name != "" {
+			inst.SidekiqSpoolDir = filepath.Join(DefaultCacheRoot, "sidekiq", inst.Name)
+		}
+	}
+}
+
+func (cfg Config) Key(name string) (KeyConfig, bool) {
+	for _, key := range cfg.Keys {
+		if key.Name == name {
+			return key, true
+		}
+	}
+	return KeyConfig{}, false
+}
+
+func (cfg Config) Source(name string) (SourceConfig, bool) {
+	for _, src := range cfg.Sources {
+		if src.Name == name {
+			return src, true
+		}
+	}
+	return SourceConfig{}, false
+}
+
+func (cfg Config) Instance(name string) (InstanceConfig, bool) {
+	for _, inst := range cfg.Instances {
+		if inst.Name == name {
+			return inst, true
+		}
+	}
+	return InstanceConfig{}, false
+}
+
+func (cfg Config) Validate() []error {
+	var errs []error
+
+	checkName := func(seen map[string]int, what string, index int, name string) {
+		where := fmt.Sprintf("%s[%d]", what, index)
+		switch prev, found := seen[name]; {
+		case name == "":
+			errs = append(errs, fmt.Errorf("%s: missing name", where))
+		case found:
+			errs = append(errs, fmt.Errorf("%s: name %q duplicates %s[%d]", where, name, what, prev))
+		default:
+			seen[name] = index
+		}
+	}
+
+	checkURL := func(where string, field string, str string) {
+		if str == "" {
+			errs = append(errs, fmt.Errorf("%s: missing %s", where, field))
+			return
+		}
+		u, err := url.Parse(str)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", where, field, err))
+			return
+		}
+		if u.Scheme != "https" && u.Scheme != "http" {
+			errs = append(errs, fmt.Errorf("%s: %s: expected an http or https URL, got %q", where, field, str))
+		}
+	}
+
+	seen := make(map[string]int, len(cfg.Keys))
+	for index, key := range cfg.Keys {
+		checkName(seen, "keys", index, key.Name)
+		if key.PublicKeyFile == "" {
+			errs = append(errs, fmt.Errorf("keys[%d]: missing public_key_file", index))
+		}
+	}
+
+	seen = make(map[string]int, len(cfg.Sources))
+	for index, src := range cfg.Sources {
+		where := fmt.Sprintf("sources[%d]", index)
+		checkName(seen, "sources", index, src.Name)
+		checkURL(where, "blocklist_url", src.BlocklistURL)
+		checkURL(where, "signature_url", src.SignatureURL)
+		if _, found := cfg.Key(src.Key); src.Key != "" && !found {
+			errs = append(errs, fmt.Errorf("%s: unknown key %q", where, src.Key))
+		}
+		if src.PublicKeyFile == "" && src.Keyring == "" {
+			errs = append(errs, fmt.Errorf("%s: missing key, public_key_file, or keyring", where))
+		}
+		if src.Threshold < 1 {
+			errs = append(errs, fmt.Errorf("%s: threshold must be positive", where))
+		}
+		if src.KeyTransitionURL != "" {
+			checkURL(where, "key_transition_url", src.KeyTransitionURL)
+		}
+		if src.DataFile == "" || src.SigFile == "" || (src.KeyTransitionURL != "" && src.KeyTransitionFile == "") {
+			errs = append(errs, fmt.Errorf("%s: missing cache_dir", where))
+		}
+	}
+
+	seen = make(map[string]int, len(cfg.Instances))
+	for index, inst := range cfg.Instances {
+		where := fmt.Sprintf("instances[%d]", index)
+		checkName(seen, "instances", index, inst.Name)
+		if _, found := cfg.Source(inst.Source); !found {
+			if inst.Source == "" {
+				errs = append(errs, fmt.Errorf("%s: missing source", where))
+			} else {
+				errs = append(errs, fmt.Errorf("%s: unknown source %q", where, inst.Source))
+			}
+		}
+		errs = append(errs, inst.Validate(where)...)
+	}
+	return errs
+}
+
+func (inst InstanceConfig) Validate(where string) []error {
+	var errs []error
+	if _, found := ApplyFuncFor(inst.Software); !found {
+		errs = append(errs, fmt.Errorf("%s: unknown software %q, expected one of: %s", where, inst.Software, AllSoftware))
+	}
+	switch {
+	case inst.Software != MastodonAPI && inst.DatabaseURL == "":
+		errs = append(errs, fmt.Errorf("%s: missing database_url", where))
+	case inst.Software == MastodonAPI && inst.APIURL == "":
+		errs = append(errs, fmt.Errorf("%s: missing api_url", where))
+	case inst.Software == MastodonAPI && inst.APITokenFile == "":
+		errs = append(errs, fmt.Errorf("%s: missing api_token_file", where))
+	}
+	switch inst.Software {
+	case Mastodon3x, Mastodon4x, MastodonAuto:
+		if inst.ActingAccount != "" {
+			if _, _, err := ParseMastodonActingAccount(inst.ActingAccount); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", where, err))
+			}
+		}
+	default:
+		if inst.ActingAccount != "" {
+			errs = append(errs, fmt.Errorf("%s: acting_account is only supported for software %s, %s, and %s", where, MastodonAuto, Mastodon4x, Mastodon3x))
+		}
+		if inst.SidekiqRedisURL != "" {
+			errs = append(errs, fmt.Errorf("%s: sidekiq_redis_url is only supported for software %s, %s, and %s", where, MastodonAuto, Mastodon4x, Mastodon3x))
+		}
+	}
+	if inst.SidekiqRedisURL != "" {
+		if u, err := url.Parse(inst.SidekiqRedisURL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss" && u.Scheme != "unix") {
+			errs = append(errs, fmt.Errorf("%s: sidekiq_redis_url must be a redis, rediss, or unix URL", where))
+		}
+	}
+	if inst.Policy != nil && inst.PolicyFile != "" {
+		errs = append(errs, fmt.Errorf("%s: policy and policy_file are mutually exclusive", where))
+	}
+	for _, err := range (Overrides{Entries: inst.Overrides}).Validate() {
+		errs = append(errs, fmt.Errorf("%s: %w", where, err))
+	}
+	if inst.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("%s: interval must be positive", where))
+	}
+	if inst.Jitter < 0 {
+		errs = append(errs, fmt.Errorf("%s: jitter must not be negative", where))
+	}
+	if inst.LockTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%s: lock_timeout must not be negative", where))
+	}
+	if inst.RetryMin <= 0 {
+		errs = append(errs, fmt.Errorf("%s: retry_min must be positive", where))
+	}
+	if inst.RetryMax < inst.RetryMin {
+		errs = append(errs, fmt.Errorf("%s: retry_max must not be less than retry_min", where))
+	}
+	return errs
+}
+
+// ApplyOptions loads the policy and override files named by inst.
+func (inst InstanceConfig) ApplyOptions() (ApplyOptions, error) {
+	opts := ApplyOptions{
+		Software:         inst.Software,
+		DatabaseURL:      inst.DatabaseURL,
+		APIURL:           inst.APIURL,
+		ActingAccount:    inst.ActingAccount,
+		SidekiqRedisURL:  inst.SidekiqRedisURL,
+		SidekiqNamespace: inst.SidekiqNamespace,
+		SidekiqSpoolDir:  inst.SidekiqSpoolDir,
+		LockTimeout:      inst.LockTimeout,
+		Policy:           DefaultPolicy(),
+		Overrides:        Overrides{Entries: inst.Overrides},
+		Limits:           inst.Limits,
+	}
+
+	var errs ErrorList
+	if inst.APITokenFile != "" {
+		raw, err := os.ReadFile(inst.APITokenFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: failed to read file: %w", inst.APITokenFile, err))
+		}
+		opts.APIToken = strings.TrimSpace(string(raw))
+	}
+	if inst.Policy != nil {
+		opts.Policy = *inst.Policy
+	}
+	if inst.PolicyFile != "" {
+		policy, err := LoadPolicyFile(inst.PolicyFile)
+		opts.Policy = policy
+		errs = appendErrors(errs, err)
+	}
+	if inst.OverrideFile != "" {
+		overrides, err := LoadOverridesFile(inst.OverrideFile)
+		opts.Overrides.Entries = append(opts.Overrides.Entries, overrides.Entries...)
+		errs = appendErrors(errs, err)
+	}
+	if len(errs) > 0 {
+		return opts, errs
+	}
+	return opts, nil
+}
+
+// FetchOptions reads the keys named by src.
+func (src SourceConfig) FetchOptions() (FetchOptions, error) {
+	opts := FetchOptions{
+		BlocklistURL:      src.BlocklistURL,
+		SignatureURL:      src.SignatureURL,
+		KeyTransitionURL:  src.KeyTransitionURL,
+		Threshold:         src.Threshold,
+		IsText:            src.Text,
+		DataFile:          src.DataFile,
+		SigFile:           src.SigFile,
+		KeyTransitionFile: src.KeyTransitionFile,
+	}
+
+	var err error
+	opts.Keyring, err = src.LoadKeyring()
+	return opts, err
+}
+
+// LoadKeyring reads the public key file and the keyring named by src, then
+// applies the key transition statements found in the keyring, as well as
+// the cached copy of the one published at key_transition_url.
+func (src SourceConfig) LoadKeyring() (Keyring, error) {
+	var kr Keyring
+	var errs ErrorList
+	if src.PublicKeyFile != "" {
+		errs = appendErrors(errs, kr.Load(src.PublicKeyFile))
+	}
+	if src.Keyring != "" {
+		errs = appendErrors(errs, kr.Load(src.Keyring))
+	}
+	if src.KeyTransitionFile != "" {
+		errs = appendErrors(errs, kr.LoadTransition(src.KeyTransitionFile))
+	}
+	kr.Resolve(time.Now())
+	if holders := kr.HolderCount(); len(errs) == 0 && src.Threshold > holders {
+		errs = append(errs, fmt.Errorf("threshold %d exceeds the %d distinct key holder(s) in the keyring", src.Threshold, holders))
+	}
+	if len(errs) > 0 {
+		return kr, errs
+	}
+	return kr, nil
+}
+
+// Check goes beyond Validate by also reading every file that cfg refers to,
+// and parsing every database URL.
+func (cfg Config) Check() error {
+	var errs ErrorList
+	for _, key := range cfg.Keys {
+		if key.PublicKeyFile != "" {
+			raw, err := os.ReadFile(key.PublicKeyFile)
+			if err == nil {
+				_, _, err = DecodePublicKey(raw)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%q: %w", key.PublicKeyFile, err))
+			}
+		}
+		if key.PrivateKeyFile != "" {
+			if _, err := os.Stat(key.PrivateKeyFile); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	for _, src := range cfg.Sources {
+		if src.PublicKeyFile != "" || src.Keyring != "" {
+			_, err := src.FetchOptions()
+			errs = appendErrors(errs, err)
+		}
+	}
+	for index, inst := range cfg.Instances {
+		_, err := inst.ApplyOptions()
+		errs = appendErrors(errs, err)
+		if inst.DatabaseURL != "" && isPostgresURL(inst.Software, inst.DatabaseURL) {
+			if _, err := pgx.ParseConfig(inst.DatabaseURL); err != nil {
+				errs = append(errs, fmt.Errorf("instances[%d]: database_url: %w", index, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+var gConfig *Config
+
+// loadConfigFromFlags reads the file named by -C / --config-file, if any.
+func loadConfigFromFlags() {
+	if flagConfigFile == "" {
+		return
+	}
+	cfg, err := LoadConfig(flagConfigFile)
+	if err != nil {
+		exitWithErrors(err)
+	}
+	gConfig = &cfg
+}
+
+// selectedKey returns the key chosen by --key, or the only key in the
+// configuration file, with any key-related flags applied on top.
+func selectedKey() KeyConfig {
+	var key KeyConfig
+	switch {
+	case gConfig == nil:
+		// pass
+	case flagKeyName != "":
+		var found bool
+		key, found = gConfig.Key(flagKeyName)
+		if !found {
+			fmt.Fprintf(os.Stderr, "fatal: %q: no key named %q\n", flagConfigFile, flagKeyName)
+			os.Exit(1)
+		}
+	case len(gConfig.Keys) == 1:
+		key = gConfig.Keys[0]
+	}
+	if getopt.IsSet("public-key-file") {
+		key.PublicKeyFile = flagPublicKeyFile
+	}
+	if getopt.IsSet("private-key-file") {
+		key.PrivateKeyFile = flagPrivateKeyFile
+	}
+	return key
+}
+
+// selectedSources returns the source chosen by --source, or every source in
+// the configuration file, with any source-related flags applied on top.
+// Without a configuration file, the flags alone describe a single source.
+func selectedSources() []SourceConfig {
+	list := []SourceConfig{{Threshold: 1}}
+	switch {
+	case gConfig == nil:
+		// pass
+	case flagSourceName != "":
+		src, found := gConfig.Source(flagSourceName)
+		if !found {
+			fmt.Fprintf(os.Stderr, "fatal: %q: no source named %q\n", flagConfigFile, flagSourceName)
+			os.Exit(1)
+		}
+		list = []SourceConfig{src}
+	case len(gConfig.Sources) > 0:
+		list = append([]SourceConfig(nil), gConfig.Sources...)
+	}
+	for i := range list {
+		src := &list[i]
+		if getopt.IsSet("blocklist-url") {
+			src.BlocklistURL = flagBlocklistURL
+		}
+		if getopt.IsSet("signature-url") {
+			src.SignatureURL = flagSignatureURL
+		}
+		if getopt.IsSet("key-transition-url") {
+			src.KeyTransitionURL = flagKeyTransitionURL
+		}
+		if getopt.IsSet("public-key-file") {
+			src.PublicKeyFile = flagPublicKeyFile
+		}
+		if getopt.IsSet("keyring") {
+			src.Keyring = flagKeyring
+		}
+		if getopt.IsSet("threshold") {
+			src.Threshold = flagThreshold
+		}
+		if getopt.IsSet("text") {
+			src.Text = flagText
+		}
+		if getopt.IsSet("data-file") {
+			src.DataFile = flagDataFile
+		}
+		if getopt.IsSet("signature-file") {
+			src.SigFile = flagSigFile
+		}
+		if getopt.IsSet("key-transition-file") {
+			src.KeyTransitionFile = flagKeyTransitionFile
+		}
+	}
+	return list
+}
+
+// selectedSource is like selectedSources, for modes that need exactly one.
+func selectedSource() SourceConfig {
+	list := selectedSources()
+	if len(list) > 1 {
+		fmt.Fprintf(os.Stderr, "fatal: %q: %d sources configured, select one with --source\n", flagConfigFile, len(list))
+		os.Exit(1)
+	}
+	return list[0]
+}
+
+// selectedInstances returns the instance chosen by --instance, or every
+// instance in the configuration file, with any instance-related flags
+// applied on top.  Without a configuration file, the flags alone describe a
+// single instance.
+func selectedInstances() []InstanceConfig {
+	list := []InstanceConfig{DefaultInstanceConfig()}
+	switch {
+	case gConfig == nil:
+		// pass
+	case flagInstanceName != "":
+		inst, found := gConfig.Instance(flagInstanceName)
+		if !found {
+			fmt.Fprintf(os.Stderr, "fatal: %q: no instance named %q\n", flagConfigFile, flagInstanceName)
+			os.Exit(1)
+		}
+		list = []InstanceConfig{inst}
+	case len(gConfig.Instances) > 0:
+		list = append([]InstanceConfig(nil), gConfig.Instances...)
+	}
+	for i := range list {
+		inst := &list[i]
+		if getopt.IsSet("software") {
+			inst.Software = flagSoftware
+		}
+		if getopt.IsSet("database-url") {
+			inst.DatabaseURL = flagDatabaseURL
+		}
+		if getopt.IsSet("api-url") {
+			inst.APIURL = flagAPIURL
+		}
+		if getopt.IsSet("api-token-file") {
+			inst.APITokenFile = flagAPITokenFile
+		}
+		if getopt.IsSet("acting-account") {
+			inst.ActingAccount = flagActingAccount
+		}
+		if getopt.IsSet("sidekiq-redis-url") {
+			inst.SidekiqRedisURL = flagSidekiqRedisURL
+		}
+		if getopt.IsSet("sidekiq-namespace") {
+			inst.SidekiqNamespace = flagSidekiqNamespace
+		}
+		if getopt.IsSet("sidekiq-spool-dir") {
+			inst.SidekiqSpoolDir = flagSidekiqSpoolDir
+		}
+		if getopt.IsSet("lock-timeout") {
+			inst.LockTimeout = flagLockTimeout
+		}
+		if getopt.IsSet("data-file") {
+			inst.DataFile = flagDataFile
+		}
+		if getopt.IsSet("policy-file") {
+			inst.Policy = nil
+			inst.PolicyFile = flagPolicyFile
+		}
+		if getopt.IsSet("override-file") {
+			inst.Overrides = nil
+			inst.OverrideFile = flagOverrideFile
+		}
+		if getopt.IsSet("max-inserts") {
+			inst.Limits.MaxInserts = flagMaxInserts
+		}
+		if getopt.IsSet("max-updates") {
+			inst.Limits.MaxUpdates = flagMaxUpdates
+		}
+		if getopt.IsSet("max-deletes") {
+			inst.Limits.MaxDeletes = flagMaxDeletes
+		}
+		if getopt.IsSet("max-insert-percent") {
+			inst.Limits.MaxInsertPercent = flagMaxInsertPercent
+		}
+		if getopt.IsSet("max-update-percent") {
+			inst.Limits.MaxUpdatePercent = flagMaxUpdatePercent
+		}
+		if getopt.IsSet("max-delete-percent") {
+			inst.Limits.MaxDeletePercent = flagMaxDeletePercent
+		}
+	}
+	return list
+}
+
+// selectedInstance is like selectedInstances, for modes that need exactly
+// one.
+func selectedInstance() InstanceConfig {
+	list := selectedInstances()
+	if len(list) > 1 {
+		fmt.Fprintf(os.Stderr, "fatal: %q: %d instances configured, select one with -I / --instance\n", flagConfigFile, len(list))
+		os.Exit(1)
+	}
+	return list[0]
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const configTestYAML = `
+keys:
+  - name: upstream
+    public_key_file: /etc/rapidblock/upstream.pub
+sources:
+  - name: rapidblock
+    blocklist_url: https://rapidblock.org/blocklist.json
+    signature_url: https://rapidblock.org/blocklist.json.sig
+    key_transition_url: https://rapidblock.org/key-transition.json
+    key: upstream
+instances:
+  - name: mastodon
+    database_url: postgres:///mastodon
+    interval: 30m
+  - name: gts
+    software: gotosocial
+    database_url: sqlite:///var/lib/gotosocial/sqlite.db
+    policy:
+      default:
+        severity: silence
+`
+
+func TestLoadConfig(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestFile(t, filePath, []byte(configTestYAML))
+	cfg, err := LoadConfig(filePath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	src, found := cfg.Source("rapidblock")
+	if !found {
+		t.Fatalf("Source: %q not found", "rapidblock")
+	}
+	wantSource := SourceConfig{
+		Name:              "rapidblock",
+		BlocklistURL:      "https://rapidblock.org/blocklist.json",
+		SignatureURL:      "https://rapidblock.org/blocklist.json.sig",
+		KeyTransitionURL:  "https://rapidblock.org/key-transition.json",
+		Key:               "upstream",
+		PublicKeyFile:     "/etc/rapidblock/upstream.pub",
+		Threshold:         1,
+		CacheDir:          "/var/cache/rapidblock/rapidblock",
+		DataFile:          "/var/cache/rapidblock/rapidblock/blocklist.json",
+		SigFile:           "/var/cache/rapidblock/rapidblock/blocklist.json.sig",
+		KeyTransitionFile: "/var/cache/rapidblock/rapidblock/key" + keyringTransitionSuffix,
+	}
+	if src != wantSource {
+		t.Errorf("source:\n\tgot  %+v\n\twant %+v", src, wantSource)
+	}
+
+	type wantInstance struct {
+		name            string
+		software        string
+		interval        time.Duration
+		sidekiqSpoolDir string
+		hasPolicy       bool
+	}
+	want := []wantInstance{
+		{name: "mastodon", software: MastodonAuto, interval: 30 * time.Minute, sidekiqSpoolDir: "/var/cache/rapidblock/sidekiq/mastodon"},
+		{name: "gts", software: GoToSocial, interval: time.Hour, sidekiqSpoolDir: "/var/cache/rapidblock/sidekiq/gts", hasPolicy: true},
+	}
+	if len(cfg.Instances) != len(want) {
+		t.Fatalf("instances: expected %d, got %d", len(want), len(cfg.Instances))
+	}
+	for i, inst := range cfg.Instances {
+		got := wantInstance{name: inst.Name, software: inst.Software, interval: inst.Interval, sidekiqSpoolDir: inst.SidekiqSpoolDir, hasPolicy: inst.Policy != nil}
+		if got != want[i] {
+			t.Errorf("instances[%d]:\n\tgot  %+v\n\twant %+v", i, got, want[i])
+		}
+		if inst.Source != "rapidblock" || inst.DataFile != wantSource.DataFile {
+			t.Errorf("instances[%d]: expected the only source and its data file, got %q and %q", i, inst.Source, inst.DataFile)
+		}
+		if inst.Limits != NoLimits() || inst.RetryMin != time.Minute {
+			t.Errorf("instances[%d]: defaults were not applied: %+v", i, inst)
+		}
+	}
+	if sev := cfg.Instances[1].Policy.Default.Severity; sev != SeveritySilence {
+		t.Errorf("instances[1]: expected the inline policy, got default severity %v", sev)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	validSource := func() SourceConfig {
+		return SourceConfig{
+			Name:          "src",
+			BlocklistURL:  "https://example.com/blocklist.json",
+			SignatureURL:  "https://example.com/blocklist.json.sig",
+			PublicKeyFile: "/etc/rapidblock/src.pub",
+		}
+	}
+	validInstance := func() InstanceConfig {
+		inst := DefaultInstanceConfig()
+		inst.Name = "inst"
+		inst.DatabaseURL = "postgres:///mastodon"
+		return inst
+	}
+
+	type testCase struct {
+		name    string
+		mutate  func(cfg *Config)
+		wantErr string
+	}
+	testCases := []testCase{
+		{name: "ok", mutate: func(cfg *Config) {}},
+		{name: "missing-source-name", mutate: func(cfg *Config) { cfg.Sources[0].Name = ""; cfg.Sources[0].CacheDir = "/tmp/x" }, wantErr: "sources[0]: missing name"},
+		{name: "duplicate-source", mutate: func(cfg *Config) {
+			cfg.Sources = append(cfg.Sources, cfg.Sources[0])
+			cfg.Instances[0].Source = "src"
+		}, wantErr: `sources[1]: name "src" duplicates sources[0]`},
+		{name: "bad-url", mutate: func(cfg *Config) { cfg.Sources[0].BlocklistURL = "ftp://example.com/x" }, wantErr: "sources[0]: blocklist_url: expected an http or https URL"},
+		{name: "unknown-key", mutate: func(cfg *Config) { cfg.Sources[0].Key = "nope" }, wantErr: `sources[0]: unknown key "nope"`},
+		{name: "no-key", mutate: func(cfg *Config) { cfg.Sources[0].PublicKeyFile = "" }, wantErr: "sources[0]: missing key, public_key_file, or keyring"},
+		{name: "bad-threshold", mutate: func(cfg *Config) { cfg.Sources[0].Threshold = -1 }, wantErr: "sources[0]: threshold must be positive"},
+		{name: "ambiguous-source", mutate: func(cfg *Config) {
+			other := cfg.Sources[0]
+			other.Name = "other"
+			cfg.Sources = append(cfg.Sources, other)
+		}, wantErr: "instances[0]: missing source"},
+		{name: "unknown-source", mutate: func(cfg *Config) { cfg.Instances[0].Source = "nope" }, wantErr: `instances[0]: unknown source "nope"`},
+		{name: "unknown-software", mutate: func(cfg *Config) { cfg.Instances[0].Software = "friendica" }, wantErr: `instances[0]: unknown software "friendica"`},
+		{name: "api-needs-url", mutate: func(cfg *Config) { cfg.Instances[0].Software = MastodonAPI }, wantErr: "instances[0]: missing api_url"},
+		{name: "acting-account-unsupported", mutate: func(cfg *Config) {
+			cfg.Instances[0].Software = GoToSocial
+			cfg.Instances[0].ActingAccount = "admin"
+		}, wantErr: "instances[0]: acting_account is only supported"},
+		{name: "bad-redis-url", mutate: func(cfg *Config) { cfg.Instances[0].SidekiqRedisURL = "http://localhost" }, wantErr: "instances[0]: sidekiq_redis_url must be a redis"},
+		{name: "policy-and-file", mutate: func(cfg *Config) {
+			policy := DefaultPolicy()
+			cfg.Instances[0].Policy = &policy
+			cfg.Instances[0].PolicyFile = "/etc/rapidblock/policy.yaml"
+		}, wantErr: "instances[0]: policy and policy_file are mutually exclusive"},
+		{name: "bad-interval", mutate: func(cfg *Config) { cfg.Instances[0].Interval = 0 }, wantErr: "instances[0]: interval must be positive"},
+		{name: "bad-retry", mutate: func(cfg *Config) { cfg.Instances[0].RetryMax = time.Second }, wantErr: "instances[0]: retry_max must not be less than retry_min"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Config{Sources: []SourceConfig{validSource()}, Instances: []InstanceConfig{validInstance()}}
+			tc.mutate(&cfg)
+			cfg.resolve()
+			errs := cfg.Validate()
+			switch {
+			case tc.wantErr == "" && len(errs) != 0:
+				t.Errorf("Validate: unexpected errors %v", errs)
+			case tc.wantErr != "" && (len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), tc.wantErr)):
+				t.Errorf("Validate: expected one error starting with %q, got %v", tc.wantErr, errs)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrorList(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestFile(t, filePath, []byte("instances:\n  - name: a\n  - name: a\n"))
+	_, err := LoadConfig(filePath)
+	var errs ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("LoadConfig: expected an ErrorList, got %v", err)
+	}
+	for _, err := range errs {
+		if !strings.HasPrefix(err.Error(), `"`+filePath+`": `) {
+			t.Errorf("LoadConfig: error does not name the file: %v", err)
+		}
+	}
+	if !strings.Contains(errs.Error(), `instances[1]: name "a" duplicates instances[0]`) {
+		t.Errorf("LoadConfig: duplicate instance not reported: %v", errs)
+	}
+}
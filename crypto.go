@@ -3,32 +3,250 @@ package main
 import (
 	"crypto/ed25519"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 )
 
-func signFile(privKey ed25519.PrivateKey, pubKey ed25519.PublicKey, checksum []byte, sigFileName string) {
-	signature := ed25519.Sign(privKey, checksum)
-	if !ed25519.Verify(pubKey, checksum, signature) {
+// readKeyPair reads the key pair named by key, and checks that the two
+// halves match.  The key ID is nil unless either file is in the minisign
+// format.
+func readKeyPair(key KeyConfig) (ed25519.PublicKey, ed25519.PrivateKey, []byte) {
+	pubKey, pubKeyID := readPublicKey(key.PublicKeyFile)
+	privKey, keyID := readPrivateKey(key.PrivateKeyFile)
+	checkKeyPair(pubKey, privKey)
+	if keyID == nil {
+		keyID = pubKeyID
+	}
+	return pubKey, privKey, keyID
+}
+
+func checkKeyPair(pubKey ed25519.PublicKey, privKey ed25519.PrivateKey) {
+	computedPubKey := privKey.Public().(ed25519.PublicKey)
+	if !pubKey.Equal(computedPubKey) {
+		str0 := base64.StdEncoding.EncodeToString(computedPubKey[:])
+		str1 := base64.StdEncoding.EncodeToString(pubKey[:])
+		fmt.Fprintf(os.Stderr, "fatal: private key does not match public key!\n\tEd25519 public key calculated from private key: %s\n\tEd25519 public key provided: %s\n", str0, str1)
+		os.Exit(1)
+	}
+}
+
+// readPublicKey reads a public key file in either format.  The key ID is
+// nil unless the file is in the minisign format.
+func readPublicKey(filePath string) (ed25519.PublicKey, []byte) {
+	pubKey, keyID, err := DecodePublicKey(ReadFile(filePath))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", filePath, err)
+		os.Exit(1)
+	}
+	return pubKey, keyID
+}
+
+// readPrivateKey reads a private key file in any format, asking for the
+// passphrase if it is encrypted.  The key ID is nil unless the file is a
+// minisign secret key.
+func readPrivateKey(filePath string) (ed25519.PrivateKey, []byte) {
+	getPassphrase := func() ([]byte, error) {
+		return readPassphrase(fmt.Sprintf("Passphrase for %q: ", filePath), flagPassphraseFD, "--passphrase-fd", PassphraseEnv, false)
+	}
+
+	raw := ReadFile(filePath)
+	switch {
+	case IsMinisignFile(raw):
+		key, err := DecodeMinisignSecretKey(raw, getPassphrase)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", filePath, err)
+			os.Exit(1)
+		}
+		return key.PrivateKey, key.KeyID
+
+	case !IsEncryptedKey(raw):
+		seed, err := DecodeKeySig(raw, ed25519.SeedSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", filePath, err)
+			os.Exit(1)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+
+	key, err := DecodeEncryptedKey(raw)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", filePath, err)
+		os.Exit(1)
+	}
+	passphrase, err := getPassphrase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", filePath, err)
+		os.Exit(1)
+	}
+	seed, err := key.Decrypt(passphrase)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", filePath, err)
+		os.Exit(1)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// encryptPrivateKey encrypts seed for writing to filePath.
+func encryptPrivateKey(filePath string, seed []byte, passphrase []byte) []byte {
+	key, err := EncryptKey(seed, passphrase)
+	var raw []byte
+	if err == nil {
+		raw, err = key.Encode()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", filePath, err)
+		os.Exit(1)
+	}
+	return raw
+}
+
+// encodeMinisignSecretKey encodes privKey as a minisign secret key for
+// writing to filePath, encrypted if passphrase is not nil.
+func encodeMinisignSecretKey(filePath string, privKey ed25519.PrivateKey, keyID []byte, passphrase []byte) []byte {
+	raw, err := MinisignSecretKey{KeyID: keyID, PrivateKey: privKey}.Encode(passphrase)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", filePath, err)
+		os.Exit(1)
+	}
+	return raw
+}
+
+// signFileMinisign writes a minisign signature of the data file.  A
+// minisign signature holds a single signature, so it is never added to.
+func signFileMinisign(privKey ed25519.PrivateKey, pubKey ed25519.PublicKey, keyID []byte, dataFileName string, sigFileName string) {
+	if keyID == nil {
+		keyID = DefaultMinisignKeyID(pubKey)
+	}
+	trustedComment := flagTrustedComment
+	if trustedComment == "" {
+		trustedComment = DefaultMinisignTrustedComment(dataFileName, time.Now())
+	}
+	if strings.ContainsAny(trustedComment, "\r\n") {
+		fmt.Fprintf(os.Stderr, "fatal: --trusted-comment must be a single line\n")
+		os.Exit(1)
+	}
+
+	data := ReadFile(dataFileName)
+	sig := NewMinisignSignature(privKey, keyID, data, trustedComment)
+	if sig.Verify(pubKey, data) != nil {
+		fmt.Fprintf(os.Stderr, "fatal: failed to verify signature after creation!\n")
+		os.Exit(1)
+	}
+	WriteFile(sigFileName, sig.Encode(), false)
+}
+
+// signFile writes a signature of the data file.  With --append, the
+// signature is added to the existing envelope, so that each signer can add
+// theirs in turn; signatures in it that were made for other data, such as
+// an earlier version of the data file, are dropped, since they would no
+// longer verify.  Without --append, the signature file must not exist.
+func signFile(privKey ed25519.PrivateKey, pubKey ed25519.PublicKey, dataFileName string, isText bool, sigFileName string) {
+	checksum := checksumFile(dataFileName, isText)
+	if flagSignatureFormat == SigFormatLegacy {
+		signature := ed25519.Sign(privKey, checksum)
+		if !ed25519.Verify(pubKey, checksum, signature) {
+			fmt.Fprintf(os.Stderr, "fatal: failed to verify signature after creation!\n")
+			os.Exit(1)
+		}
+		WriteKeySigFile(sigFileName, signature, false)
+		return
+	}
+
+	entry := NewSignatureEntry(privKey, SignaturePurposeBlocklist, checksum, isText, time.Now())
+	if entry.Verify(pubKey, SignaturePurposeBlocklist, checksum) != nil {
 		fmt.Fprintf(os.Stderr, "fatal: failed to verify signature after creation!\n")
 		os.Exit(1)
 	}
-	WriteKeySigFile(sigFileName, signature, false)
+
+	env := SignatureEnvelope{Spec: SignatureSpecV1}
+	if !flagAppend {
+		if _, err := os.Stat(sigFileName); err == nil {
+			fmt.Fprintf(os.Stderr, "fatal: %q: file already exists; to add a signature to it, use --append\n", sigFileName)
+			os.Exit(1)
+		}
+		env.Add(entry)
+		raw, err := env.Encode()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", sigFileName, err)
+			os.Exit(1)
+		}
+		WriteFile(sigFileName, raw, false)
+		return
+	}
+
+	existing, err := os.ReadFile(sigFileName)
+	exists := (err == nil)
+	switch {
+	case exists && !IsSignatureEnvelope(existing):
+		fmt.Fprintf(os.Stderr, "fatal: %q: cannot add a signature to a %s or %s signature file\n", sigFileName, SigFormatLegacy, SigFormatMinisign)
+		os.Exit(1)
+	case exists:
+		env, err = DecodeSignatureEnvelope(existing)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", sigFileName, err)
+			os.Exit(1)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		fmt.Fprintf(os.Stderr, "fatal: %q: failed to read file: %v\n", sigFileName, err)
+		os.Exit(1)
+	}
+
+	dropped := env.DropStale(func(entryIsText bool) []byte {
+		if entryIsText == isText {
+			return checksum
+		}
+		return checksumFile(dataFileName, entryIsText)
+	})
+	for _, stale := range dropped {
+		fmt.Fprintf(os.Stderr, "warning: %q: dropped signature by %s, which was made for other data\n", sigFileName, stale.KeyFingerprint)
+	}
+	verb := "added"
+	if env.Add(entry) {
+		verb = "replaced"
+	}
+
+	raw, err := env.Encode()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", sigFileName, err)
+		os.Exit(1)
+	}
+	if !exists {
+		WriteFile(sigFileName, raw, false)
+		return
+	}
+	err = WriteFileAtomic(sigFileName, raw, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "info: %q: %s signature by %s, %d signature(s) in total\n", sigFileName, verb, entry.KeyFingerprint, len(env.Signatures))
 }
 
-func verifyFile(pubKey ed25519.PublicKey, checksum []byte, sigFileName string) {
-	signature := ReadKeySigFile(sigFileName, ed25519.SignatureSize)
+func verifyFile(keyring Keyring, threshold int, dataFileName string, isText bool, sigFileName string) SignatureReport {
+	report, err := VerifyCachedBlocklist(keyring, threshold, dataFileName, isText, sigFileName, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %v\n", err)
+		os.Exit(1)
+	}
+	return report
+}
+
+func verifySignature(pubKey ed25519.PublicKey, checksum []byte, signature []byte, isText bool) error {
 	if !ed25519.Verify(pubKey, checksum, signature) {
 		str0 := base64.StdEncoding.EncodeToString(checksum)
 		str1 := base64.StdEncoding.EncodeToString(pubKey)
 		str2 := base64.StdEncoding.EncodeToString(signature)
-		fmt.Fprintf(os.Stderr, "fatal: signature verification failed!\n\tSHA-256 checksum: %s\n\tEd25519 public key: %s\n\tEd25519 signature: %s\n", str0, str1, str2)
-		switch {
-		case flagText:
-			fmt.Fprintf(os.Stderr, "\tmaybe try again without --text?\n")
-		default:
-			fmt.Fprintf(os.Stderr, "\tmaybe try again with --text?\n")
-		}
-		os.Exit(1)
+		return fmt.Errorf("signature verification failed!\n\tSHA-256 checksum: %s\n\tEd25519 public key: %s\n\tEd25519 signature: %s\n\t%s", str0, str1, str2, textHint(isText))
+	}
+	return nil
+}
+
+func textHint(isText bool) string {
+	if isText {
+		return "maybe try again without --text?"
 	}
+	return "maybe try again with --text?"
 }
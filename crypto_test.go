@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readTestEnvelope(t *testing.T, filePath string) SignatureEnvelope {
+	t.Helper()
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	env, err := DecodeSignatureEnvelope(raw)
+	if err != nil {
+		t.Fatalf("DecodeSignatureEnvelope: %v", err)
+	}
+	return env
+}
+
+func TestSignFileAppend(t *testing.T) {
+	savedFormat, savedAppend := flagSignatureFormat, flagAppend
+	t.Cleanup(func() {
+		flagSignatureFormat, flagAppend = savedFormat, savedAppend
+	})
+	flagSignatureFormat = SigFormatEnvelope
+
+	dir := t.TempDir()
+	dataFile := filepath.Join(dir, "blocklist.json")
+	sigFile := filepath.Join(dir, "blocklist.json.sig")
+	writeTestFile(t, dataFile, []byte("first\n"))
+	pubKeyA, privKeyA := newTestKey(t)
+	pubKeyB, privKeyB := newTestKey(t)
+
+	type step struct {
+		name     string
+		data     string
+		pubKey   ed25519.PublicKey
+		privKey  ed25519.PrivateKey
+		isAppend bool
+		isNew    bool
+		want     []string
+	}
+	steps := []step{
+		{name: "first", pubKey: pubKeyA, privKey: privKeyA, want: []string{KeyFingerprint(pubKeyA)}},
+		{name: "append", pubKey: pubKeyB, privKey: privKeyB, isAppend: true, want: []string{KeyFingerprint(pubKeyA), KeyFingerprint(pubKeyB)}},
+		{name: "append-again", pubKey: pubKeyB, privKey: privKeyB, isAppend: true, want: []string{KeyFingerprint(pubKeyA), KeyFingerprint(pubKeyB)}},
+		{name: "append-changed", data: "second\n", pubKey: pubKeyA, privKey: privKeyA, isAppend: true, want: []string{KeyFingerprint(pubKeyA)}},
+		{name: "append-other", pubKey: pubKeyB, privKey: privKeyB, isAppend: true, want: []string{KeyFingerprint(pubKeyA), KeyFingerprint(pubKeyB)}},
+		{name: "new", pubKey: pubKeyB, privKey: privKeyB, isNew: true, want: []string{KeyFingerprint(pubKeyB)}},
+	}
+	for _, s := range steps {
+		if s.data != "" {
+			writeTestFile(t, dataFile, []byte(s.data))
+		}
+		if s.isNew {
+			err := os.Remove(sigFile)
+			if err != nil {
+				t.Fatalf("Remove: %v", err)
+			}
+		}
+		flagAppend = s.isAppend
+		signFile(s.privKey, s.pubKey, dataFile, false, sigFile)
+
+		env := readTestEnvelope(t, sigFile)
+		var got []string
+		for _, entry := range env.Signatures {
+			got = append(got, entry.KeyFingerprint)
+			if entry.KeyFingerprint == KeyFingerprint(s.pubKey) {
+				if err := entry.Verify(s.pubKey, SignaturePurposeBlocklist, checksumFile(dataFile, false)); err != nil {
+					t.Errorf("%s: new signature does not verify: %v", s.name, err)
+				}
+			}
+			if !entry.IsFor(checksumFile(dataFile, false)) {
+				t.Errorf("%s: signature by %s is for other data", s.name, entry.KeyFingerprint)
+			}
+		}
+		if len(got) != len(s.want) {
+			t.Fatalf("%s: expected signers %q, got %q", s.name, s.want, got)
+		}
+		for i := range got {
+			if got[i] != s.want[i] {
+				t.Fatalf("%s: expected signers %q, got %q", s.name, s.want, got)
+			}
+		}
+	}
+}
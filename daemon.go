@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Scheduler periodically fetches, verifies, and applies the blocklist to each
+// configured instance, each on its own schedule.  All work happens on a
+// single goroutine at a time, so instances are never applied concurrently.
+type Scheduler struct {
+	ConfigFile string
+	Client     *http.Client
+
+	instances []*daemonInstance
+}
+
+type daemonInstance struct {
+	config       InstanceConfig
+	apply        ApplyFunc
+	fetch        FetchOptions
+	opts         ApplyOptions
+	nextRun      time.Time
+	failures     int
+	lastChecksum []byte
+}
+
+func NewScheduler(configFile string, client *http.Client) (*Scheduler, error) {
+	s := &Scheduler{ConfigFile: configFile, Client: client}
+	err := s.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the configuration file.  If the new configuration is
+// invalid, the old configuration stays in effect.  Instances that keep
+// their name keep their schedule, but are re-applied on their next run even
+// if the blocklist has not changed, since their policy may have.
+func (s *Scheduler) Reload() error {
+	cfg, err := LoadConfig(s.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	var errs ErrorList
+	if len(cfg.Instances) <= 0 {
+		errs = append(errs, fmt.Errorf("%q: no instances configured", s.ConfigFile))
+	}
+
+	fetchBySource := make(map[string]FetchOptions, len(cfg.Sources))
+	for _, src := range cfg.Sources {
+		fetchBySource[src.Name], err = src.FetchOptions()
+		errs = appendErrors(errs, err)
+	}
+
+	oldByName := make(map[string]*daemonInstance, len(s.instances))
+	for _, inst := range s.instances {
+		oldByName[inst.config.Name] = inst
+	}
+
+	now := time.Now()
+	instances := make([]*daemonInstance, 0, len(cfg.Instances))
+	for _, instCfg := range cfg.Instances {
+		applyFn, _ := ApplyFuncFor(instCfg.Software)
+		inst := &daemonInstance{
+			config:  instCfg,
+			apply:   applyFn,
+			fetch:   fetchBySource[instCfg.Source],
+			nextRun: now.Add(randomDuration(instCfg.Jitter)),
+		}
+		if old, found := oldByName[instCfg.Name]; found {
+			inst.nextRun = old.nextRun
+			inst.failures = old.failures
+		}
+		inst.opts, err = instCfg.ApplyOptions()
+		inst.opts.HTTPClient = s.Client
+		errs = appendErrors(errs, err)
+		instances = append(instances, inst)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	s.instances = instances
+	return nil
+}
+
+// Run loops until it receives SIGTERM or SIGINT.  SIGHUP reloads the
+// configuration.  A termination signal that arrives while an instance is
+// being applied lets that instance finish first; a second one cancels it.
+func (s *Scheduler) Run(ctx context.Context, signals <-chan os.Signal) error {
+	for {
+		inst := s.nextInstance()
+		timer := time.NewTimer(time.Until(inst.nextRun))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+
+		case sig := <-signals:
+			timer.Stop()
+			if s.handleIdleSignal(sig) {
+				return nil
+			}
+
+		case <-timer.C:
+			if s.runWhileHandlingSignals(ctx, inst, signals) {
+				return nil
+			}
+		}
+	}
+}
+
+func (s *Scheduler) handleIdleSignal(sig os.Signal) (shouldStop bool) {
+	if sig == syscall.SIGHUP {
+		s.reloadAndReport()
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "info: received %v, shutting down\n", sig)
+	return true
+}
+
+func (s *Scheduler) runWhileHandlingSignals(ctx context.Context, inst *daemonInstance, signals <-chan os.Signal) (shouldStop bool) {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.runInstance(runCtx, inst)
+	}()
+
+	var reloadPending bool
+	for {
+		select {
+		case err := <-done:
+			s.reschedule(inst, err)
+			if reloadPending {
+				s.reloadAndReport()
+			}
+			return shouldStop
+
+		case sig := <-signals:
+			switch {
+			case sig == syscall.SIGHUP:
+				reloadPending = true
+			case shouldStop:
+				fmt.Fprintf(os.Stderr, "info: received %v again, cancelling %s\n", sig, inst.config.Name)
+				cancel()
+			default:
+				fmt.Fprintf(os.Stderr, "info: received %v, finishing %s before shutting down\n", sig, inst.config.Name)
+				shouldStop = true
+			}
+		}
+	}
+}
+
+func (s *Scheduler) reloadAndReport() {
+	err := s.Reload()
+	if err != nil {
+		printErrors(err)
+		fmt.Fprintf(os.Stderr, "error: %q: failed to reload configuration, keeping the previous one\n", s.ConfigFile)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "info: %q: reloaded configuration\n", s.ConfigFile)
+}
+
+func (s *Scheduler) nextInstance() *daemonInstance {
+	var next *daemonInstance
+	for _, inst := range s.instances {
+		if next == nil || inst.nextRun.Before(next.nextRun) {
+			next = inst
+		}
+	}
+	return next
+}
+
+func (s *Scheduler) runInstance(ctx context.Context, inst *daemonInstance) error {
+	name := inst.config.Name
+
+	result, err := FetchBlocklist(ctx, s.Client, inst.fetch)
+	if err != nil {
+		return err
+	}
+
+	if inst.lastChecksum != nil && bytes.Equal(inst.lastChecksum, result.Checksum) {
+		fmt.Fprintf(os.Stderr, "info: %s: blocklist unchanged since last successful apply\n", name)
+		return nil
+	}
+
+	plan, err := inst.apply(ctx, result.File, inst.opts)
+	if err != nil {
+		return err
+	}
+
+	totals := plan.Totals()
+	fmt.Fprintf(os.Stderr, "info: %s: added %d, modified %d, deleted %d, skipped %d block(s)\n", name, totals.Inserted, totals.Updated, totals.Deleted, totals.Skipped)
+	inst.lastChecksum = result.Checksum
+	return nil
+}
+
+func (s *Scheduler) reschedule(inst *daemonInstance, err error) {
+	cfg := inst.config
+	now := time.Now()
+
+	if err == nil {
+		inst.failures = 0
+		inst.nextRun = now.Add(cfg.Interval + randomDuration(cfg.Jitter))
+		return
+	}
+
+	inst.failures++
+	delay := cfg.RetryMin
+	for i := 1; i < inst.failures && delay < cfg.RetryMax; i++ {
+		delay *= 2
+	}
+	if delay > cfg.RetryMax {
+		delay = cfg.RetryMax
+	}
+	delay += randomDuration(delay / 4)
+	inst.nextRun = now.Add(delay)
+	fmt.Fprintf(os.Stderr, "error: %s: %v\n", cfg.Name, err)
+	fmt.Fprintf(os.Stderr, "info: %s: attempt %d failed, retrying in %v\n", cfg.Name, inst.failures, delay.Round(time.Second))
+}
+
+// randomDuration returns a uniformly random duration in [0, max).
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0
+	}
+	return time.Duration(n.Int64())
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSchedulerReschedule(t *testing.T) {
+	cfg := InstanceConfig{Name: "test", Interval: time.Hour, RetryMin: time.Minute, RetryMax: 5 * time.Minute}
+
+	type testCase struct {
+		name         string
+		failures     int
+		err          error
+		wantFailures int
+		wantDelay    time.Duration
+		wantJitter   time.Duration
+	}
+	testCases := []testCase{
+		{name: "success", failures: 3, wantDelay: time.Hour},
+		{name: "first-failure", err: errors.New("boom"), wantFailures: 1, wantDelay: time.Minute, wantJitter: 15 * time.Second},
+		{name: "second-failure", failures: 1, err: errors.New("boom"), wantFailures: 2, wantDelay: 2 * time.Minute, wantJitter: 30 * time.Second},
+		{name: "third-failure", failures: 2, err: errors.New("boom"), wantFailures: 3, wantDelay: 4 * time.Minute, wantJitter: time.Minute},
+		{name: "capped", failures: 50, err: errors.New("boom"), wantFailures: 51, wantDelay: 5 * time.Minute, wantJitter: 75 * time.Second},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var s Scheduler
+			inst := &daemonInstance{config: cfg, failures: tc.failures}
+			before := time.Now()
+			s.reschedule(inst, tc.err)
+			after := time.Now()
+
+			if inst.failures != tc.wantFailures {
+				t.Errorf("reschedule: expected %d failure(s), got %d", tc.wantFailures, inst.failures)
+			}
+			earliest := before.Add(tc.wantDelay)
+			latest := after.Add(tc.wantDelay + tc.wantJitter)
+			if inst.nextRun.Before(earliest) || inst.nextRun.After(latest) {
+				t.Errorf("reschedule: expected the next run in [%v, %v], got %v", earliest, latest, inst.nextRun)
+			}
+		})
+	}
+}
+
+func TestSchedulerNextInstance(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := Scheduler{instances: []*daemonInstance{
+		{config: InstanceConfig{Name: "a"}, nextRun: now.Add(time.Hour)},
+		{config: InstanceConfig{Name: "b"}, nextRun: now.Add(time.Minute)},
+		{config: InstanceConfig{Name: "c"}, nextRun: now.Add(time.Minute)},
+	}}
+	if got := s.nextInstance().config.Name; got != "b" {
+		t.Errorf("nextInstance: expected the earliest instance %q, got %q", "b", got)
+	}
+}
+
+func TestSchedulerRunInstanceUnchanged(t *testing.T) {
+	fake, srv := newFakeBlocklistServer(t)
+	signer := newFetchTestSigner(t)
+	data, sig := signer.Blocklist("bad.example")
+	fake.Set("/blocklist.json", data)
+	fake.Set("/blocklist.json.sig", sig)
+
+	var applied []BlockFile
+	inst := &daemonInstance{
+		config: InstanceConfig{Name: "test"},
+		fetch:  newTestFetchOptions(t, srv, signer.keyring),
+		apply: func(ctx context.Context, file BlockFile, opts ApplyOptions) (Plan, error) {
+			applied = append(applied, file)
+			return Plan{}, nil
+		},
+	}
+	s := Scheduler{Client: srv.Client(), instances: []*daemonInstance{inst}}
+
+	type step struct {
+		name        string
+		domain      string
+		failApply   bool
+		wantApplied int
+	}
+	steps := []step{
+		{name: "first", wantApplied: 1},
+		{name: "unchanged", wantApplied: 1},
+		{name: "changed", domain: "worse.example", wantApplied: 2},
+		{name: "failed", domain: "worst.example", failApply: true, wantApplied: 3},
+		{name: "retry-after-failure", wantApplied: 4},
+		{name: "unchanged-after-retry", wantApplied: 4},
+	}
+	for _, st := range steps {
+		if st.domain != "" {
+			data, sig := signer.Blocklist(st.domain)
+			fake.Set("/blocklist.json", data)
+			fake.Set("/blocklist.json.sig", sig)
+		}
+		apply := inst.apply
+		if st.failApply {
+			inst.apply = func(ctx context.Context, file BlockFile, opts ApplyOptions) (Plan, error) {
+				_, _ = apply(ctx, file, opts)
+				return Plan{}, errors.New("database unavailable")
+			}
+		}
+		err := s.runInstance(context.Background(), inst)
+		inst.apply = apply
+		if st.failApply != (err != nil) {
+			t.Fatalf("%s: runInstance: unexpected error %v", st.name, err)
+		}
+		if len(applied) != st.wantApplied {
+			t.Errorf("%s: expected %d apply(s), got %d", st.name, st.wantApplied, len(applied))
+		}
+	}
+	if _, found := applied[len(applied)-1].Blocks["worst.example"]; !found {
+		t.Errorf("runInstance: the retry did not apply the latest blocklist: %+v", applied[len(applied)-1])
+	}
+}
@@ -8,8 +8,9 @@
 # When installed via a package manager, this script lives at:
 #   /opt/rapidblock/scripts/cron.sh
 #
-# The configuration file lives at:
+# The configuration files live at:
 #   /etc/defaults/rapidblock
+#   /etc/rapidblock/config.yaml
 #
 # The crontab file that runs this script lives at:
 #   /etc/cron.d/rapidblock
@@ -53,21 +54,16 @@ if (( ! ENABLED )); then
   exit 0
 fi
 
-tmproot="$(mktemp -d -t "rapidblock.$$.XXXXXXXX")"
-trap 'cd /; rm -rf "$tmproot"' EXIT
-cd "$tmproot"
+CONFIG_FILE="${CONFIG_FILE:-/etc/rapidblock/config.yaml}"
 
-curl -fsSLR -o blocklist.json     "$BLOCKLIST_URL"
-curl -fsSLR -o blocklist.json.sig "$SIGNATURE_URL"
-
-rapidblock -m verify \
-  -p "$PUBLIC_KEY_FILE" \
-  -d blocklist.json \
-  -s blocklist.json.sig \
-  -t >/dev/null
-
-for item in "${INSTANCES[@]}"; do
-  software="${item%%|*}"
-  pgurl="${item#*|}"
-  rapidblock -m apply -d blocklist.json -x "$software" -D "$pgurl"
+# The old settings would otherwise be silently ignored, and the instances
+# they named would stop receiving updates.
+for name in BLOCKLIST_URL SIGNATURE_URL PUBLIC_KEY_FILE CACHE_DIR INSTANCES; do
+  if [ -n "${!name+isset}" ]; then
+    echo "rapidblock: /etc/default/rapidblock sets $name, which is no longer read; move these settings to $CONFIG_FILE, as described in /etc/default/rapidblock" >&2
+    exit 1
+  fi
 done
+
+rapidblock -m fetch -C "$CONFIG_FILE" >/dev/null
+rapidblock -m apply -C "$CONFIG_FILE"
@@ -164,3 +164,192 @@ var (
 	_ encoding.TextMarshaler   = GIOType(0)
 	_ encoding.TextUnmarshaler = (*GIOType)(nil)
 )
+
+type Severity byte
+
+const (
+	SeveritySilence Severity = iota
+	SeveritySuspend
+	SeverityNoOp
+)
+
+var severityDataArray = [...]EnumData[Severity]{
+	{SeveritySilence, "SeveritySilence", "silence", nil},
+	{SeveritySuspend, "SeveritySuspend", "suspend", nil},
+	{SeverityNoOp, "SeverityNoOp", "noop", nil},
+}
+
+func (enum Severity) Data() EnumData[Severity] {
+	i := uint(enum)
+	j := uint(len(severityDataArray))
+	if i < j {
+		return severityDataArray[i]
+	}
+	goName := fmt.Sprintf("Severity(%d)", i)
+	name := fmt.Sprintf("severity-%d", i)
+	return EnumData[Severity]{enum, goName, name, nil}
+}
+
+func (enum Severity) GoString() string {
+	return enum.Data().GoName
+}
+
+func (enum Severity) String() string {
+	return enum.Data().Name
+}
+
+func (enum Severity) MarshalText() ([]byte, error) {
+	str := enum.String()
+	return []byte(str), nil
+}
+
+func (enum *Severity) UnmarshalText(raw []byte) error {
+	str := string(raw)
+	for _, data := range severityDataArray {
+		if strings.EqualFold(str, data.Name) {
+			*enum = data.Value
+			return nil
+		}
+		for _, alias := range data.Aliases {
+			if strings.EqualFold(str, alias) {
+				*enum = data.Value
+				return nil
+			}
+		}
+	}
+	*enum = 0
+	return fmt.Errorf("unknown Severity enum value %q", str)
+}
+
+var (
+	_ encoding.TextMarshaler   = Severity(0)
+	_ encoding.TextUnmarshaler = (*Severity)(nil)
+)
+
+type PlanAction byte
+
+const (
+	NoAction PlanAction = iota
+	InsertAction
+	UpdateAction
+	DeleteAction
+	SkipAction
+)
+
+var planActionDataArray = [...]EnumData[PlanAction]{
+	{NoAction, "NoAction", "none", nil},
+	{InsertAction, "InsertAction", "insert", []string{"add", "create"}},
+	{UpdateAction, "UpdateAction", "update", []string{"modify"}},
+	{DeleteAction, "DeleteAction", "delete", []string{"remove", "destroy"}},
+	{SkipAction, "SkipAction", "skip", []string{"ignore"}},
+}
+
+func (enum PlanAction) Data() EnumData[PlanAction] {
+	i := uint(enum)
+	j := uint(len(planActionDataArray))
+	if i < j {
+		return planActionDataArray[i]
+	}
+	goName := fmt.Sprintf("PlanAction(%d)", i)
+	name := fmt.Sprintf("action-%d", i)
+	return EnumData[PlanAction]{enum, goName, name, nil}
+}
+
+func (enum PlanAction) GoString() string {
+	return enum.Data().GoName
+}
+
+func (enum PlanAction) String() string {
+	return enum.Data().Name
+}
+
+func (enum PlanAction) MarshalText() ([]byte, error) {
+	str := enum.String()
+	return []byte(str), nil
+}
+
+func (enum *PlanAction) UnmarshalText(raw []byte) error {
+	str := string(raw)
+	for _, data := range planActionDataArray {
+		if strings.EqualFold(str, data.Name) {
+			*enum = data.Value
+			return nil
+		}
+		for _, alias := range data.Aliases {
+			if strings.EqualFold(str, alias) {
+				*enum = data.Value
+				return nil
+			}
+		}
+	}
+	*enum = 0
+	return fmt.Errorf("unknown PlanAction enum value %q", str)
+}
+
+var (
+	_ encoding.TextMarshaler   = PlanAction(0)
+	_ encoding.TextUnmarshaler = (*PlanAction)(nil)
+)
+
+type OverrideAction byte
+
+const (
+	NoOverride OverrideAction = iota
+	NeverBlockOverride
+	AlwaysBlockOverride
+	IgnoreUpstreamOverride
+)
+
+var overrideActionDataArray = [...]EnumData[OverrideAction]{
+	{NoOverride, "NoOverride", "none", nil},
+	{NeverBlockOverride, "NeverBlockOverride", "never-block", []string{"allow"}},
+	{AlwaysBlockOverride, "AlwaysBlockOverride", "always-block", []string{"block"}},
+	{IgnoreUpstreamOverride, "IgnoreUpstreamOverride", "ignore-upstream", []string{"ignore"}},
+}
+
+func (enum OverrideAction) Data() EnumData[OverrideAction] {
+	i := uint(enum)
+	j := uint(len(overrideActionDataArray))
+	if i < j {
+		return overrideActionDataArray[i]
+	}
+	goName := fmt.Sprintf("OverrideAction(%d)", i)
+	name := fmt.Sprintf("override-%d", i)
+	return EnumData[OverrideAction]{enum, goName, name, nil}
+}
+
+func (enum OverrideAction) GoString() string {
+	return enum.Data().GoName
+}
+
+func (enum OverrideAction) String() string {
+	return enum.Data().Name
+}
+
+func (enum OverrideAction) MarshalText() ([]byte, error) {
+	str := enum.String()
+	return []byte(str), nil
+}
+
+func (enum *OverrideAction) UnmarshalText(raw []byte) error {
+	str := string(raw)
+	for _, data := range overrideActionDataArray {
+		if strings.EqualFold(str, data.Name) {
+			*enum = data.Value
+			return nil
+		}
+		for _, alias := range data.Aliases {
+			if strings.EqualFold(str, alias) {
+				*enum = data.Value
+				return nil
+			}
+		}
+	}
+	*enum = 0
+	return fmt.Errorf("unknown OverrideAction enum value %q", str)
+}
+
+var (
+	_ encoding.TextMarshaler   = OverrideAction(0)
+	_ encoding.TextUnmarshaler = (*OverrideAction)(nil)
+)
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"unicode/utf8"
+)
+
+// Erlang External Term Format, as produced by :erlang.term_to_binary/1.
+// Only the term types that can appear in a Pleroma ConfigDB value are
+// supported.  Decoding and re-encoding a term is lossless for those types,
+// except that atoms are always re-encoded in their UTF-8 form.
+const (
+	etfVersion         = 131
+	etfCompressed      = 80
+	etfNewFloat        = 70
+	etfSmallInteger    = 97
+	etfInteger         = 98
+	etfAtom            = 100
+	etfSmallTuple      = 104
+	etfLargeTuple      = 105
+	etfNil             = 106
+	etfString          = 107
+	etfList            = 108
+	etfBinary          = 109
+	etfSmallBig        = 110
+	etfLargeBig        = 111
+	etfSmallAtom       = 115
+	etfMap             = 116
+	etfAtomUTF8        = 118
+	etfSmallAtomUTF8   = 119
+	etfMaxDecodedBytes = 64 << 20 // 64 MiB
+)
+
+var errETFTruncated = errors.New("truncated Erlang term")
+
+type ErlTerm any
+
+type ErlAtom string
+
+type ErlBinary []byte
+
+type ErlInt int64
+
+type ErlFloat float64
+
+// ErlBigInt holds an integer too large for ErlInt, as its sign and
+// little-endian magnitude.
+type ErlBigInt struct {
+	Negative  bool
+	Magnitude []byte
+}
+
+// ErlString is a list of bytes in the compact STRING_EXT form.
+type ErlString []byte
+
+type ErlTuple []ErlTerm
+
+// ErlList is a list.  Tail is nil for a proper list.
+type ErlList struct {
+	Elements []ErlTerm
+	Tail     ErlTerm
+}
+
+type ErlMapEntry struct {
+	Key   ErlTerm
+	Value ErlTerm
+}
+
+type ErlMap []ErlMapEntry
+
+func DecodeErlTerm(raw []byte) (ErlTerm, error) {
+	if len(raw) < 1 || raw[0] != etfVersion {
+		return nil, fmt.Errorf("not an Erlang term: missing version byte %d", etfVersion)
+	}
+	raw = raw[1:]
+
+	if len(raw) >= 5 && raw[0] == etfCompressed {
+		size := binary.BigEndian.Uint32(raw[1:5])
+		if size > etfMaxDecodedBytes {
+			return nil, fmt.Errorf("compressed Erlang term is too large: %d bytes", size)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(raw[5:]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress Erlang term: %w", err)
+		}
+		inflated, err := io.ReadAll(io.LimitReader(zr, int64(size)+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress Erlang term: %w", err)
+		}
+		if uint32(len(inflated)) != size {
+			return nil, fmt.Errorf("failed to decompress Erlang term: expected %d bytes, got %d", size, len(inflated))
+		}
+		raw = inflated
+	}
+
+	d := etfDecoder{raw: raw}
+	term, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.raw) {
+		return nil, fmt.Errorf("%d trailing bytes after Erlang term", len(d.raw)-d.pos)
+	}
+	return term, nil
+}
+
+func EncodeErlTerm(term ErlTerm) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(etfVersion)
+	err := encodeErlTerm(&buf, term)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type etfDecoder struct {
+	raw []byte
+	pos int
+}
+
+func (d *etfDecoder) take(n int) ([]byte, error) {
+	if n < 0 || len(d.raw)-d.pos < n {
+		return nil, errETFTruncated
+	}
+	out := d.raw[d.pos : d.pos+n]
+	d.pos += n
+	return out, nil
+}
+
+func (d *etfDecoder) u8() (int, error) {
+	b, err := d.take(1)
+	if err != nil {
+		return 0, err
+	}
+	return int(b[0]), nil
+}
+
+func (d *etfDecoder) u16() (int, error) {
+	b, err := d.take(2)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(b)), nil
+}
+
+func (d *etfDecoder) u32() (int, error) {
+	b, err := d.take(4)
+	if err != nil {
+		return 0, err
+	}
+	n := binary.BigEndian.Uint32(b)
+	if n > etfMaxDecodedBytes {
+		return 0, fmt.Errorf("Erlang term length %d is too large", n)
+	}
+	return int(n), nil
+}
+
+func (d *etfDecoder) decode() (ErlTerm, error) {
+	tag, err := d.u8()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case etfSmallInteger:
+		n, err := d.u8()
+		return ErlInt(n), err
+
+	case etfInteger:
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		return ErlInt(int32(binary.BigEndian.Uint32(b))), nil
+
+	case etfNewFloat:
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return ErlFloat(math.Float64frombits(binary.BigEndian.Uint64(b))), nil
+
+	case etfSmallBig, etfLargeBig:
+		var n int
+		if tag == etfSmallBig {
+			n, err = d.u8()
+		} else {
+			n, err = d.u32()
+		}
+		if err != nil {
+			return nil, err
+		}
+		sign, err := d.u8()
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.take(n)
+		if err != nil {
+			return nil, err
+		}
+		return ErlBigInt{Negative: sign != 0, Magnitude: append([]byte(nil), b...)}, nil
+
+	case etfAtom, etfSmallAtom, etfAtomUTF8, etfSmallAtomUTF8:
+		var n int
+		if tag == etfSmallAtom || tag == etfSmallAtomUTF8 {
+			n, err = d.u8()
+		} else {
+			n, err = d.u16()
+		}
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.take(n)
+		if err != nil {
+			return nil, err
+		}
+		if tag == etfAtom || tag == etfSmallAtom {
+			// Latin-1: every byte is its own code point.
+			runes := make([]rune, len(b))
+			for i, ch := range b {
+				runes[i] = rune(ch)
+			}
+			return ErlAtom(runes), nil
+		}
+		return ErlAtom(b), nil
+
+	case etfSmallTuple, etfLargeTuple:
+		var n int
+		if tag == etfSmallTuple {
+			n, err = d.u8()
+		} else {
+			n, err = d.u32()
+		}
+		if err != nil {
+			return nil, err
+		}
+		tuple := make(ErlTuple, 0, minInt(n, len(d.raw)-d.pos))
+		for i := 0; i < n; i++ {
+			elem, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			tuple = append(tuple, elem)
+		}
+		return tuple, nil
+
+	case etfNil:
+		return ErlList{}, nil
+
+	case etfString:
+		n, err := d.u16()
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.take(n)
+		if err != nil {
+			return nil, err
+		}
+		return ErlString(append([]byte(nil), b...)), nil
+
+	case etfList:
+		n, err := d.u32()
+		if err != nil {
+			return nil, err
+		}
+		list := ErlList{Elements: make([]ErlTerm, 0, minInt(n, len(d.raw)-d.pos))}
+		for i := 0; i < n; i++ {
+			elem, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			list.Elements = append(list.Elements, elem)
+		}
+		tail, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := tail.(ErlList); !ok || len(t.Elements) != 0 || t.Tail != nil {
+			list.Tail = tail
+		}
+		return list, nil
+
+	case etfBinary:
+		n, err := d.u32()
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.take(n)
+		if err != nil {
+			return nil, err
+		}
+		return ErlBinary(append([]byte(nil), b...)), nil
+
+	case etfMap:
+		n, err := d.u32()
+		if err != nil {
+			return nil, err
+		}
+		m := make(ErlMap, 0, minInt(n, len(d.raw)-d.pos))
+		for i := 0; i < n; i++ {
+			key, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.decode()
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, ErlMapEntry{Key: key, Value: value})
+		}
+		return m, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported Erlang term tag %d at offset %d", tag, d.pos-1)
+	}
+}
+
+func encodeErlTerm(buf *bytes.Buffer, term ErlTerm) error {
+	var tmp [8]byte
+
+	putU16 := func(n int) {
+		binary.BigEndian.PutUint16(tmp[:2], uint16(n))
+		buf.Write(tmp[:2])
+	}
+	putU32 := func(n int) {
+		binary.BigEndian.PutUint32(tmp[:4], uint32(n))
+		buf.Write(tmp[:4])
+	}
+
+	switch t := term.(type) {
+	case ErlInt:
+		switch {
+		case t >= 0 && t <= math.MaxUint8:
+			buf.WriteByte(etfSmallInteger)
+			buf.WriteByte(byte(t))
+		case t >= math.MinInt32 && t <= math.MaxInt32:
+			buf.WriteByte(etfInteger)
+			putU32(int(uint32(int32(t))))
+		default:
+			return fmt.Errorf("Erlang integer %d is out of range", int64(t))
+		}
+
+	case ErlFloat:
+		buf.WriteByte(etfNewFloat)
+		binary.BigEndian.PutUint64(tmp[:8], math.Float64bits(float64(t)))
+		buf.Write(tmp[:8])
+
+	case ErlBigInt:
+		if len(t.Magnitude) <= math.MaxUint8 {
+			buf.WriteByte(etfSmallBig)
+			buf.WriteByte(byte(len(t.Magnitude)))
+		} else {
+			buf.WriteByte(etfLargeBig)
+			putU32(len(t.Magnitude))
+		}
+		if t.Negative {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		buf.Write(t.Magnitude)
+
+	case ErlAtom:
+		if !utf8.ValidString(string(t)) || utf8.RuneCountInString(string(t)) > 255 {
+			return fmt.Errorf("invalid Erlang atom %q", string(t))
+		}
+		if len(t) <= math.MaxUint8 {
+			buf.WriteByte(etfSmallAtomUTF8)
+			buf.WriteByte(byte(len(t)))
+		} else {
+			buf.WriteByte(etfAtomUTF8)
+			putU16(len(t))
+		}
+		buf.WriteString(string(t))
+
+	case ErlTuple:
+		if len(t) <= math.MaxUint8 {
+			buf.WriteByte(etfSmallTuple)
+			buf.WriteByte(byte(len(t)))
+		} else {
+			buf.WriteByte(etfLargeTuple)
+			putU32(len(t))
+		}
+		for _, elem := range t {
+			err := encodeErlTerm(buf, elem)
+			if err != nil {
+				return err
+			}
+		}
+
+	case ErlString:
+		if len(t) > math.MaxUint16 {
+			return fmt.Errorf("Erlang string of %d bytes is too long", len(t))
+		}
+		buf.WriteByte(etfString)
+		putU16(len(t))
+		buf.Write(t)
+
+	case ErlList:
+		if len(t.Elements) == 0 && t.Tail == nil {
+			buf.WriteByte(etfNil)
+			break
+		}
+		buf.WriteByte(etfList)
+		putU32(len(t.Elements))
+		for _, elem := range t.Elements {
+			err := encodeErlTerm(buf, elem)
+			if err != nil {
+				return err
+			}
+		}
+		tail := t.Tail
+		if tail == nil {
+			tail = ErlList{}
+		}
+		err := encodeErlTerm(buf, tail)
+		if err != nil {
+			return err
+		}
+
+	case ErlBinary:
+		buf.WriteByte(etfBinary)
+		putU32(len(t))
+		buf.Write(t)
+
+	case ErlMap:
+		buf.WriteByte(etfMap)
+		putU32(len(t))
+		for _, entry := range t {
+			err := encodeErlTerm(buf, entry.Key)
+			if err != nil {
+				return err
+			}
+			err = encodeErlTerm(buf, entry.Value)
+			if err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("cannot encode %T as an Erlang term", term)
+	}
+	return nil
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeTestHex(t *testing.T, str string) []byte {
+	t.Helper()
+	raw, err := hex.DecodeString(str)
+	if err != nil {
+		t.Fatalf("bad hex fixture %q: %v", str, err)
+	}
+	return raw
+}
+
+// TestErlTermRoundTrip decodes the output of :erlang.term_to_binary/1 for
+// each supported term type and re-encodes it.  OTP 26 and later write
+// atoms as SMALL_ATOM_UTF8_EXT, which EncodeErlTerm also does, so their
+// output round-trips exactly; the ATOM_EXT of older releases is re-encoded
+// in the newer form, which every release since OTP 20 can read.
+func TestErlTermRoundTrip(t *testing.T) {
+	type testCase struct {
+		name  string
+		expr  string
+		raw   string
+		want  ErlTerm
+		reraw string
+	}
+	testCases := []testCase{
+		{name: "nil", expr: `[]`, raw: "836a", want: ErlList{}},
+		{name: "string", expr: `"abc"`, raw: "836b0003616263", want: ErlString("abc")},
+		{name: "small-integer", expr: `1`, raw: "836101", want: ErlInt(1)},
+		{name: "integer", expr: `256`, raw: "836200000100", want: ErlInt(256)},
+		{name: "negative-integer", expr: `-1`, raw: "8362ffffffff", want: ErlInt(-1)},
+		{name: "small-big", expr: `1 bsl 31`, raw: "836e040000000080", want: ErlBigInt{Magnitude: []byte{0, 0, 0, 0x80}}},
+		{name: "negative-small-big", expr: `-(1 bsl 64)`, raw: "836e0901000000000000000001", want: ErlBigInt{Negative: true, Magnitude: []byte{0, 0, 0, 0, 0, 0, 0, 0, 1}}},
+		{name: "float", expr: `1.5`, raw: "83463ff8000000000000", want: ErlFloat(1.5)},
+		{name: "empty-tuple", expr: `{}`, raw: "836800", want: ErlTuple{}},
+		{name: "improper-list", expr: `[1|2]`, raw: "836c0000000161016102", want: ErlList{Elements: []ErlTerm{ErlInt(1)}, Tail: ErlInt(2)}},
+		{name: "mixed-list", expr: `[<<"a">>, "bc"]`, raw: "836c000000026d00000001616b000262636a", want: ErlList{Elements: []ErlTerm{ErlBinary("a"), ErlString("bc")}}},
+		{name: "map", expr: `#{a => <<"b">>}`, raw: "8374000000017701616d0000000162", want: ErlMap{{Key: ErlAtom("a"), Value: ErlBinary("b")}}},
+		{name: "atom-otp26", expr: `'héllo'`, raw: "83770668c3a96c6c6f", want: ErlAtom("héllo")},
+		{name: "atom-otp25", expr: `'héllo'`, raw: "8364000568e96c6c6f", want: ErlAtom("héllo"), reraw: "83770668c3a96c6c6f"},
+		{name: "keyword-otp25", expr: `[{reject, []}]`, raw: "836c00000001680264000672656a6563746a6a", want: ErlList{Elements: []ErlTerm{ErlTuple{ErlAtom("reject"), ErlList{}}}}, reraw: "836c000000016802770672656a6563746a6a"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			term, err := DecodeErlTerm(decodeTestHex(t, tc.raw))
+			if err != nil {
+				t.Fatalf("DecodeErlTerm(term_to_binary(%s)): %v", tc.expr, err)
+			}
+			if !reflect.DeepEqual(term, tc.want) {
+				t.Fatalf("DecodeErlTerm(term_to_binary(%s)):\n\tgot  %#v\n\twant %#v", tc.expr, term, tc.want)
+			}
+			raw, err := EncodeErlTerm(term)
+			if err != nil {
+				t.Fatalf("EncodeErlTerm(%s): %v", tc.expr, err)
+			}
+			want := tc.reraw
+			if want == "" {
+				want = tc.raw
+			}
+			if got := hex.EncodeToString(raw); got != want {
+				t.Errorf("EncodeErlTerm(%s):\n\tgot  %s\n\twant %s", tc.expr, got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeErlTermCompressed(t *testing.T) {
+	inner := decodeTestHex(t, "6c000000026d00000001616b000262636a")
+	var zbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	_, _ = zw.Write(inner)
+	_ = zw.Close()
+
+	raw := []byte{etfVersion, etfCompressed, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(raw[2:6], uint32(len(inner)))
+	raw = append(raw, zbuf.Bytes()...)
+	term, err := DecodeErlTerm(raw)
+	if err != nil {
+		t.Fatalf("DecodeErlTerm: %v", err)
+	}
+	want := ErlList{Elements: []ErlTerm{ErlBinary("a"), ErlString("bc")}}
+	if !reflect.DeepEqual(term, want) {
+		t.Errorf("DecodeErlTerm:\n\tgot  %#v\n\twant %#v", term, want)
+	}
+
+	binary.BigEndian.PutUint32(raw[2:6], uint32(len(inner)+1))
+	if _, err = DecodeErlTerm(raw); err == nil || !strings.Contains(err.Error(), "expected") {
+		t.Errorf("DecodeErlTerm: expected a size mismatch error, got %v", err)
+	}
+}
+
+func TestDecodeErlTermErrors(t *testing.T) {
+	type testCase struct {
+		name    string
+		raw     string
+		wantErr string
+	}
+	testCases := []testCase{
+		{name: "empty", raw: "", wantErr: "missing version byte"},
+		{name: "no-version", raw: "6a", wantErr: "missing version byte"},
+		{name: "truncated", raw: "836d0000000561", wantErr: "truncated"},
+		{name: "trailing", raw: "836a6a", wantErr: "trailing bytes"},
+		{name: "pid", raw: "8358", wantErr: "unsupported Erlang term tag 88"},
+		{name: "huge-length", raw: "836dffffffff", wantErr: "too large"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecodeErlTerm(decodeTestHex(t, tc.raw))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("DecodeErlTerm: expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const maxFetchSize = 64 << 20 // 64 MiB
+
+// HTTPTimeout bounds each HTTP request, including reading the response
+// body, so that a server that stalls cannot hang a run, or the daemon's
+// schedule, forever.
+const HTTPTimeout = 5 * time.Minute
+
+// gHTTPClient is the client for every HTTP request that RapidBlock makes.
+var gHTTPClient = &http.Client{Timeout: HTTPTimeout}
+
+// cacheReadAttempts is how many times VerifyCachedBlocklist reads the
+// cached blocklist and its signature.  FetchBlocklist replaces them one
+// file after the other, so a reader that looks between the two renames
+// sees one new file and one old one; if the pair fails to verify and
+// either file has changed in the meantime, it is read again.
+const (
+	cacheReadAttempts   = 3
+	cacheReadRetryDelay = 100 * time.Millisecond
+)
+
+var errHTTPNotFound = errors.New("unexpected status 404")
+
+type FetchOptions struct {
+	BlocklistURL      string
+	SignatureURL      string
+	KeyTransitionURL  string
+	Keyring           Keyring
+	Threshold         int
+	IsText            bool
+	DataFile          string
+	SigFile           string
+	KeyTransitionFile string
+}
+
+// FetchState remembers the HTTP cache validators for the cached copies of
+// the blocklist and its signature, so that the next fetch can be made
+// conditional.  It lives next to the cached blocklist.
+type FetchState struct {
+	Blocklist     HTTPValidators `json:"blocklist"`
+	Signature     HTTPValidators `json:"signature"`
+	KeyTransition HTTPValidators `json:"keyTransition"`
+}
+
+type HTTPValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+type FetchResult struct {
+	Changed  bool
+	Checksum []byte
+	File     BlockFile
+}
+
+// FetchBlocklist downloads the blocklist and its signature, verifies the
+// signature, and only then replaces the cached copies at opts.DataFile and
+// opts.SigFile.  If verification fails, the cached copies are untouched.
+// The state file is written last, so that if the run stops between the
+// two renames, the next fetch is unconditional and repairs the pair.
+//
+// If opts.KeyTransitionURL is set, the key transition statement published
+// there is downloaded and verified first, so that a blocklist signed by a
+// newly introduced key verifies on the same run.  A 404 means that none has
+// been published.
+func FetchBlocklist(ctx context.Context, client *http.Client, opts FetchOptions) (FetchResult, error) {
+	statePath := opts.DataFile + ".state"
+
+	cachedData, dataErr := os.ReadFile(opts.DataFile)
+	cachedSig, sigErr := os.ReadFile(opts.SigFile)
+	haveCache := (dataErr == nil && sigErr == nil)
+
+	var state FetchState
+	if haveCache {
+		if raw, err := os.ReadFile(statePath); err == nil {
+			// A corrupt state file only costs us an unconditional fetch.
+			_ = json.Unmarshal(raw, &state)
+		}
+	}
+
+	data, dataValidators, dataNotModified, err := fetchURL(ctx, client, opts.BlocklistURL, state.Blocklist, haveCache)
+	if err != nil {
+		return FetchResult{}, err
+	}
+	if dataNotModified {
+		data = cachedData
+	}
+
+	sig, sigValidators, sigNotModified, err := fetchURL(ctx, client, opts.SignatureURL, state.Signature, haveCache)
+	if err != nil {
+		return FetchResult{}, err
+	}
+	if sigNotModified {
+		sig = cachedSig
+	}
+
+	keyring := opts.Keyring
+	var transition, cachedTransition []byte
+	var transitionValidators HTTPValidators
+	if opts.KeyTransitionURL != "" {
+		var transitionErr error
+		cachedTransition, transitionErr = os.ReadFile(opts.KeyTransitionFile)
+		haveTransitionCache := (haveCache && transitionErr == nil)
+
+		var transitionNotModified bool
+		transition, transitionValidators, transitionNotModified, err = fetchURL(ctx, client, opts.KeyTransitionURL, state.KeyTransition, haveTransitionCache)
+		switch {
+		case errors.Is(err, errHTTPNotFound):
+			transition = nil
+		case err != nil:
+			return FetchResult{}, err
+		default:
+			if transitionNotModified {
+				transition = cachedTransition
+			}
+			keyring = keyring.Clone()
+			t, err := DecodeKeyTransition(transition)
+			if err == nil {
+				err = keyring.ApplyTransition(t, time.Now())
+			}
+			if err != nil {
+				return FetchResult{}, fmt.Errorf("%s: %w", opts.KeyTransitionURL, err)
+			}
+		}
+	}
+
+	report, err := VerifySignatureData(keyring, sig, signedBytes(data), opts.IsText, opts.Threshold, time.Now())
+	if err != nil {
+		return FetchResult{}, fmt.Errorf("%s: %w", opts.SignatureURL, err)
+	}
+
+	var file BlockFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return FetchResult{}, fmt.Errorf("%s: failed to decode JSON data: %w", opts.BlocklistURL, err)
+	}
+
+	changed := !haveCache || !bytes.Equal(data, cachedData) || !bytes.Equal(sig, cachedSig)
+	if changed {
+		dir := filepath.Dir(opts.DataFile)
+		err = os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return FetchResult{}, fmt.Errorf("%q: failed to create directory: %w", dir, err)
+		}
+		err = WriteFileAtomic(opts.DataFile, data, false)
+		if err != nil {
+			return FetchResult{}, err
+		}
+		err = WriteFileAtomic(opts.SigFile, sig, false)
+		if err != nil {
+			return FetchResult{}, err
+		}
+	}
+
+	if transition != nil && !bytes.Equal(transition, cachedTransition) {
+		dir := filepath.Dir(opts.KeyTransitionFile)
+		err = os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return FetchResult{}, fmt.Errorf("%q: failed to create directory: %w", dir, err)
+		}
+		err = WriteFileAtomic(opts.KeyTransitionFile, transition, false)
+		if err != nil {
+			return FetchResult{}, err
+		}
+	}
+
+	newState := FetchState{Blocklist: dataValidators, Signature: sigValidators, KeyTransition: transitionValidators}
+	if changed || newState != state {
+		raw, err := json.Marshal(newState)
+		if err != nil {
+			return FetchResult{}, fmt.Errorf("%q: failed to encode JSON data: %w", statePath, err)
+		}
+		err = WriteFileAtomic(statePath, raw, false)
+		if err != nil {
+			return FetchResult{}, err
+		}
+	}
+
+	return FetchResult{Changed: changed, Checksum: report.Checksum, File: file}, nil
+}
+
+// VerifyCachedBlocklist verifies the cached copies of a blocklist and its
+// signature, as FetchBlocklist left them, against the keys in keyring.  See
+// cacheReadAttempts.
+func VerifyCachedBlocklist(keyring Keyring, threshold int, dataFile string, isText bool, sigFile string, now time.Time) (SignatureReport, error) {
+	data, sig, err := readCachedBlocklist(dataFile, sigFile)
+	if err != nil {
+		return SignatureReport{Threshold: threshold}, err
+	}
+	for attempt := 1; ; attempt++ {
+		report, err := VerifySignatureData(keyring, sig, signedBytes(data), isText, threshold, now)
+		if err == nil {
+			return report, nil
+		}
+
+		if attempt < cacheReadAttempts {
+			time.Sleep(cacheReadRetryDelay)
+			newData, newSig, readErr := readCachedBlocklist(dataFile, sigFile)
+			if readErr != nil {
+				return report, readErr
+			}
+			if !bytes.Equal(newData, data) || !bytes.Equal(newSig, sig) {
+				data, sig = newData, newSig
+				continue
+			}
+		}
+		return report, fmt.Errorf("%q: %w", sigFile, err)
+	}
+}
+
+func readCachedBlocklist(dataFile string, sigFile string) ([]byte, []byte, error) {
+	data, err := os.ReadFile(dataFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%q: failed to read file: %w", dataFile, err)
+	}
+	sig, err := os.ReadFile(sigFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%q: failed to read file: %w", sigFile, err)
+	}
+	return data, sig, nil
+}
+
+// signedBytes gives VerifySignatureData the data that was signed, held in
+// memory.
+func signedBytes(data []byte) SignedData {
+	return SignedData{
+		Checksum: func(isText bool) []byte {
+			return checksumBytes(data, isText)
+		},
+		Raw: func() []byte {
+			return data
+		},
+	}
+}
+
+func fetchURL(ctx context.Context, client *http.Client, urlstr string, prev HTTPValidators, conditional bool) ([]byte, HTTPValidators, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlstr, http.NoBody)
+	if err != nil {
+		return nil, prev, false, fmt.Errorf("%s: %s: failed to create request: %w", http.MethodGet, urlstr, err)
+	}
+
+	req.Header.Set("user-agent", fmt.Sprintf(UserAgentFormat, Version))
+	if conditional && prev.ETag != "" {
+		req.Header.Set("if-none-match", prev.ETag)
+	}
+	if conditional && prev.LastModified != "" {
+		req.Header.Set("if-modified-since", prev.LastModified)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, prev, false, fmt.Errorf("%s: %s: request failed: %w", http.MethodGet, urlstr, err)
+	}
+
+	rawBody, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchSize+1))
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, prev, false, fmt.Errorf("%s: %s: I/O error in response body: %w", http.MethodGet, urlstr, err)
+	}
+
+	err = resp.Body.Close()
+	if err != nil {
+		return nil, prev, false, fmt.Errorf("%s: %s: I/O error in response body: %w", http.MethodGet, urlstr, err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && conditional:
+		return nil, prev, true, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, prev, false, fmt.Errorf("%s: %s: %w", http.MethodGet, urlstr, errHTTPNotFound)
+	case resp.StatusCode != http.StatusOK:
+		return nil, prev, false, fmt.Errorf("%s: %s: unexpected status %03d", http.MethodGet, urlstr, resp.StatusCode)
+	case len(rawBody) > maxFetchSize:
+		return nil, prev, false, fmt.Errorf("%s: %s: response body exceeds %d bytes", http.MethodGet, urlstr, maxFetchSize)
+	}
+
+	validators := HTTPValidators{
+		ETag:         resp.Header.Get("etag"),
+		LastModified: resp.Header.Get("last-modified"),
+	}
+	return rawBody, validators, false, nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBlocklistServer serves files by path, with an ETag that changes
+// whenever a file does, and answers a matching If-None-Match with 304.
+type fakeBlocklistServer struct {
+	mu          sync.Mutex
+	files       map[string][]byte
+	versions    map[string]int
+	notModified int
+}
+
+func newFakeBlocklistServer(t *testing.T) (*fakeBlocklistServer, *httptest.Server) {
+	t.Helper()
+	fake := &fakeBlocklistServer{files: make(map[string][]byte), versions: make(map[string]int)}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return fake, srv
+}
+
+func (fake *fakeBlocklistServer) Set(path string, data []byte) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.files[path] = data
+	fake.versions[path]++
+}
+
+func (fake *fakeBlocklistServer) NotModified() int {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return fake.notModified
+}
+
+func (fake *fakeBlocklistServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	data, found := fake.files[r.URL.Path]
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	etag := fmt.Sprintf(`"%s-%d"`, r.URL.Path, fake.versions[r.URL.Path])
+	w.Header().Set("etag", etag)
+	if r.Header.Get("if-none-match") == etag {
+		fake.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	_, _ = w.Write(data)
+}
+
+type fetchTestSigner struct {
+	t       *testing.T
+	privKey ed25519.PrivateKey
+	keyring Keyring
+}
+
+func newFetchTestSigner(t *testing.T) fetchTestSigner {
+	t.Helper()
+	pubKey, privKey := newTestKey(t)
+	var kr Keyring
+	kr.Add(TrustedKey{Name: "test", PublicKey: pubKey})
+	return fetchTestSigner{t: t, privKey: privKey, keyring: kr}
+}
+
+// Blocklist returns a blocklist that blocks domain, and its signature.
+func (signer fetchTestSigner) Blocklist(domain string) ([]byte, []byte) {
+	signer.t.Helper()
+	file := BlockFile{
+		Spec:        BlockFileSpecV1,
+		PublishedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Blocks:      map[string]Block{domain: {IsBlocked: true, Reason: "spam"}},
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		signer.t.Fatalf("json.Marshal: %v", err)
+	}
+	env := SignatureEnvelope{Spec: SignatureSpecV1}
+	env.Add(NewSignatureEntry(signer.privKey, SignaturePurposeBlocklist, checksumBytes(data, false), false, time.Now().Add(-time.Minute)))
+	sig, err := env.Encode()
+	if err != nil {
+		signer.t.Fatalf("Encode: %v", err)
+	}
+	return data, sig
+}
+
+func newTestFetchOptions(t *testing.T, srv *httptest.Server, keyring Keyring) FetchOptions {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "cache")
+	return FetchOptions{
+		BlocklistURL: srv.URL + "/blocklist.json",
+		SignatureURL: srv.URL + "/blocklist.json.sig",
+		Keyring:      keyring,
+		Threshold:    1,
+		DataFile:     filepath.Join(dir, "blocklist.json"),
+		SigFile:      filepath.Join(dir, "blocklist.json.sig"),
+	}
+}
+
+func readTestFile(t *testing.T, filePath string) string {
+	t.Helper()
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	return string(raw)
+}
+
+func TestFetchBlocklistNotModified(t *testing.T) {
+	fake, srv := newFakeBlocklistServer(t)
+	signer := newFetchTestSigner(t)
+	data, sig := signer.Blocklist("bad.example")
+	fake.Set("/blocklist.json", data)
+	fake.Set("/blocklist.json.sig", sig)
+	opts := newTestFetchOptions(t, srv, signer.keyring)
+
+	result, err := FetchBlocklist(context.Background(), srv.Client(), opts)
+	if err != nil {
+		t.Fatalf("FetchBlocklist: %v", err)
+	}
+	if !result.Changed {
+		t.Errorf("FetchBlocklist: first fetch should report a change")
+	}
+	if got := readTestFile(t, opts.DataFile); got != string(data) {
+		t.Errorf("DataFile: expected %q, got %q", data, got)
+	}
+	if !strings.Contains(readTestFile(t, opts.DataFile+".state"), "etag") {
+		t.Errorf("state file does not record the ETags")
+	}
+
+	result, err = FetchBlocklist(context.Background(), srv.Client(), opts)
+	if err != nil {
+		t.Fatalf("FetchBlocklist: second fetch: %v", err)
+	}
+	if n := fake.NotModified(); n != 2 {
+		t.Errorf("expected both requests of the second fetch to be answered with 304, got %d", n)
+	}
+	if result.Changed {
+		t.Errorf("FetchBlocklist: second fetch should not report a change")
+	}
+	if _, found := result.File.Blocks["bad.example"]; !found {
+		t.Errorf("FetchBlocklist: blocklist was not read from the cache: %+v", result.File)
+	}
+	if got := readTestFile(t, opts.DataFile); got != string(data) {
+		t.Errorf("DataFile: cached copy changed after 304: %q", got)
+	}
+}
+
+func TestFetchBlocklistBadSignature(t *testing.T) {
+	fake, srv := newFakeBlocklistServer(t)
+	signer := newFetchTestSigner(t)
+	data, sig := signer.Blocklist("bad.example")
+	fake.Set("/blocklist.json", data)
+	fake.Set("/blocklist.json.sig", sig)
+	opts := newTestFetchOptions(t, srv, signer.keyring)
+
+	_, err := FetchBlocklist(context.Background(), srv.Client(), opts)
+	if err != nil {
+		t.Fatalf("FetchBlocklist: %v", err)
+	}
+	state := readTestFile(t, opts.DataFile+".state")
+
+	// The blocklist changes, but the signature still covers the old one.
+	tampered, _ := signer.Blocklist("good.example")
+	fake.Set("/blocklist.json", tampered)
+	_, err = FetchBlocklist(context.Background(), srv.Client(), opts)
+	if err == nil || !strings.Contains(err.Error(), "signature verification failed") {
+		t.Fatalf("FetchBlocklist: expected a signature error, got %v", err)
+	}
+
+	if got := readTestFile(t, opts.DataFile); got != string(data) {
+		t.Errorf("DataFile: cached copy was replaced by unverified data: %q", got)
+	}
+	if got := readTestFile(t, opts.SigFile); got != string(sig) {
+		t.Errorf("SigFile: cached copy was replaced: %q", got)
+	}
+	if got := readTestFile(t, opts.DataFile+".state"); got != state {
+		t.Errorf("state file was updated by a failed fetch: %q", got)
+	}
+}
+
+func TestFetchBlocklistAtomicReplace(t *testing.T) {
+	fake, srv := newFakeBlocklistServer(t)
+	signer := newFetchTestSigner(t)
+	data, sig := signer.Blocklist("bad.example")
+	fake.Set("/blocklist.json", data)
+	fake.Set("/blocklist.json.sig", sig)
+	opts := newTestFetchOptions(t, srv, signer.keyring)
+
+	_, err := FetchBlocklist(context.Background(), srv.Client(), opts)
+	if err != nil {
+		t.Fatalf("FetchBlocklist: %v", err)
+	}
+
+	// A reader that opened the old copy keeps seeing all of it: the new
+	// copy is renamed into place rather than written over the old one.
+	old, err := os.Open(opts.DataFile)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer old.Close()
+
+	newData, newSig := signer.Blocklist("worse.example")
+	fake.Set("/blocklist.json", newData)
+	fake.Set("/blocklist.json.sig", newSig)
+	result, err := FetchBlocklist(context.Background(), srv.Client(), opts)
+	if err != nil {
+		t.Fatalf("FetchBlocklist: second fetch: %v", err)
+	}
+	if !result.Changed {
+		t.Errorf("FetchBlocklist: second fetch should report a change")
+	}
+
+	oldData, err := io.ReadAll(old)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(oldData) != string(data) {
+		t.Errorf("old copy was modified in place: %q", oldData)
+	}
+	if got := readTestFile(t, opts.DataFile); got != string(newData) {
+		t.Errorf("DataFile: expected %q, got %q", newData, got)
+	}
+	if got := readTestFile(t, opts.SigFile); got != string(newSig) {
+		t.Errorf("SigFile: expected %q, got %q", newSig, got)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(opts.DataFile))
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ".tmp") {
+			t.Errorf("temporary file %q was left behind", entry.Name())
+		}
+	}
+}
+
+func TestVerifyCachedBlocklistTorn(t *testing.T) {
+	fake, srv := newFakeBlocklistServer(t)
+	signer := newFetchTestSigner(t)
+	data, sig := signer.Blocklist("bad.example")
+	fake.Set("/blocklist.json", data)
+	fake.Set("/blocklist.json.sig", sig)
+	opts := newTestFetchOptions(t, srv, signer.keyring)
+
+	_, err := FetchBlocklist(context.Background(), srv.Client(), opts)
+	if err != nil {
+		t.Fatalf("FetchBlocklist: %v", err)
+	}
+
+	// A reader that looks after the data file has been replaced, but
+	// before the signature file has, reads the pair again once both are.
+	newData, newSig := signer.Blocklist("worse.example")
+	writeTestFile(t, opts.DataFile, newData)
+	done := make(chan error, 1)
+	go func() {
+		time.Sleep(cacheReadRetryDelay / 2)
+		done <- WriteFileAtomic(opts.SigFile, newSig, false)
+	}()
+	report, err := VerifyCachedBlocklist(signer.keyring, 1, opts.DataFile, false, opts.SigFile, time.Now())
+	if err != nil {
+		t.Fatalf("VerifyCachedBlocklist: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("WriteFileAtomic: %v", err)
+	}
+	if got, want := string(report.Checksum), string(checksumBytes(newData, false)); got != want {
+		t.Errorf("VerifyCachedBlocklist: verified the old pair rather than the new one")
+	}
+
+	// A pair that does not match, and stays that way, is an error.
+	writeTestFile(t, opts.DataFile, data)
+	_, err = VerifyCachedBlocklist(signer.keyring, 1, opts.DataFile, false, opts.SigFile, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "signature verification failed") {
+		t.Errorf("VerifyCachedBlocklist: expected a signature error, got %v", err)
+	}
+}
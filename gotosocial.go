@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// GoToSocial stores its domain blocks in either SQLite or PostgreSQL, so
+// unlike the other targets it goes through database/sql.  A database URL
+// that starts with "postgres://" or "postgresql://" selects PostgreSQL;
+// anything else is taken as the path to the SQLite database file, with an
+// optional "sqlite://" prefix.  SQLite is accessed through a pure-Go
+// driver, since release binaries are built without cgo.
+//
+// GoToSocial has no notion of severity: every domain block is a full
+// suspension, and there is nothing corresponding to reject_media or
+// reject_reports.
+const (
+	SkipReasonGoToSocialSeverity = "severity not supported by gotosocial"
+)
+
+const SQLSelectAdminAccountGoToSocial = `
+SELECT a.id
+FROM accounts AS a
+INNER JOIN users AS u ON u.account_id = a.id
+WHERE u.admin = $1
+ORDER BY u.created_at
+LIMIT 1
+`
+
+const SQLSelectDomainBlocksGoToSocial = `
+SELECT
+	id, domain, COALESCE(private_comment, ''), COALESCE(public_comment, ''), COALESCE(obfuscate, $1)
+FROM domain_blocks
+`
+
+const SQLInsertDomainBlocksGoToSocial = `
+INSERT INTO domain_blocks
+	(id, created_at, updated_at, domain, created_by_account_id, private_comment, public_comment, obfuscate)
+VALUES
+	($1, $2, $3, $4, $5, $6, $7, $8)
+`
+
+const SQLUpdateDomainBlocksGoToSocial = `
+UPDATE domain_blocks
+SET
+	updated_at = $2,
+	private_comment = $3,
+	public_comment = $4,
+	obfuscate = $5
+WHERE
+	id = $1
+`
+
+const SQLDeleteDomainBlocksGoToSocial = `
+DELETE FROM domain_blocks WHERE id = $1
+`
+
+func ApplyGoToSocial(ctx context.Context, file BlockFile, opts ApplyOptions) (Plan, error) {
+	db, err := OpenGoToSocialDB(opts.DatabaseURL)
+	if err != nil {
+		return Plan{}, err
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to Begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	existingBlocks, idsByDomain, err := GetGoToSocialDomainBlocks(ctx, tx)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	now := time.Now().UTC()
+	plan := PlanGoToSocial(file, opts.Policy, opts.Overrides, existingBlocks, now)
+	if opts.DryRun {
+		return plan, nil
+	}
+
+	if !opts.Force {
+		err = opts.Limits.Check(plan.Totals(), CountManagedMastodonBlocks(existingBlocks))
+		if err != nil {
+			return plan, err
+		}
+	}
+
+	totals := plan.Totals()
+	if totals.Inserted+totals.Updated+totals.Deleted == 0 {
+		return plan, nil
+	}
+
+	accountID, err := GetGoToSocialAdminAccountID(ctx, tx)
+	if err != nil {
+		return plan, err
+	}
+
+	err = ExecuteGoToSocialPlan(ctx, tx, accountID, idsByDomain, plan)
+	if err != nil {
+		return plan, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return plan, fmt.Errorf("failed to Commit transaction: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "info: %s caches domain blocks in memory; restart it for these changes to take effect\n", opts.Software)
+	return plan, nil
+}
+
+// isPostgresURL returns false if databaseURL names a GoToSocial SQLite
+// database rather than a PostgreSQL one.
+func isPostgresURL(software string, databaseURL string) bool {
+	if software != GoToSocial {
+		return true
+	}
+	return strings.HasPrefix(databaseURL, "postgres://") || strings.HasPrefix(databaseURL, "postgresql://")
+}
+
+func OpenGoToSocialDB(databaseURL string) (*sql.DB, error) {
+	if isPostgresURL(GoToSocial, databaseURL) {
+		db, err := sql.Open("pgx", databaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+		}
+		return db, nil
+	}
+
+	filePath := strings.TrimPrefix(databaseURL, "sqlite://")
+	if _, err := os.Stat(filePath); err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+
+	// Take the write lock up front, so that we never plan against a
+	// snapshot that GoToSocial modifies before we commit.
+	dsn := "file:" + filePath + "?_txlock=immediate&_pragma=busy_timeout(10000)&_pragma=foreign_keys(1)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	return db, nil
+}
+
+// PlanGoToSocial is PlanMastodon, adjusted for what GoToSocial can express.
+func PlanGoToSocial(file BlockFile, policy Policy, overrides Overrides, existingBlocks map[string]MastodonDomainBlock, now time.Time) Plan {
+	plan := PlanMastodon(file, policy, overrides, existingBlocks, now)
+	return plan.SuspendOnly(now, SkipReasonGoToSocialSeverity, func(block *MastodonDomainBlock) {
+		block.RejectMedia = false
+		block.RejectReports = false
+	})
+}
+
+func GetGoToSocialAdminAccountID(ctx context.Context, tx *sql.Tx) (string, error) {
+	const sql = SQLSelectAdminAccountGoToSocial
+
+	var id string
+	err := tx.QueryRowContext(ctx, sql, true).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("failed to find an admin account to record as the creator of new domain blocks: QueryRow %q: %w", sql, err)
+	}
+	return id, nil
+}
+
+// GetGoToSocialDomainBlocks returns the existing domain blocks, as well as
+// their ULIDs, since GoToSocial's IDs do not fit MastodonDomainBlock.ID.
+func GetGoToSocialDomainBlocks(ctx context.Context, tx *sql.Tx) (map[string]MastodonDomainBlock, map[string]string, error) {
+	const sql = SQLSelectDomainBlocksGoToSocial
+
+	rows, err := tx.QueryContext(ctx, sql, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to Query %q: %w", sql, err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]MastodonDomainBlock, 1024)
+	ids := make(map[string]string, 1024)
+	for rows.Next() {
+		var id string
+		var row MastodonDomainBlock
+		err = rows.Scan(
+			&id,
+			&row.Domain,
+			&row.PrivateComment,
+			&row.PublicComment,
+			&row.Obfuscate,
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to process result row from Query %q: %w", sql, err)
+		}
+		row.Severity = SeveritySuspend
+		out[row.Domain] = row
+		ids[row.Domain] = id
+	}
+
+	err = rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to process results from Query %q: %w", sql, err)
+	}
+
+	return out, ids, nil
+}
+
+func ExecuteGoToSocialPlan(ctx context.Context, tx *sql.Tx, accountID string, idsByDomain map[string]string, plan Plan) error {
+	for _, item := range plan.Items {
+		var sql string
+		var args []any
+		switch item.Action {
+		case InsertAction:
+			block := *item.New
+			id, err := newULID(block.CreatedAt)
+			if err != nil {
+				return err
+			}
+			sql = SQLInsertDomainBlocksGoToSocial
+			args = []any{id, block.CreatedAt, block.UpdatedAt, block.Domain, accountID, block.PrivateComment, block.PublicComment, block.Obfuscate}
+
+		case UpdateAction:
+			block := *item.New
+			sql = SQLUpdateDomainBlocksGoToSocial
+			args = []any{idsByDomain[item.Domain], block.UpdatedAt, block.PrivateComment, block.PublicComment, block.Obfuscate}
+
+		case DeleteAction:
+			sql = SQLDeleteDomainBlocksGoToSocial
+			args = []any{idsByDomain[item.Domain]}
+
+		default:
+			continue
+		}
+
+		_, err := tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			return fmt.Errorf("failed to Exec %q: %w", sql, err)
+		}
+	}
+	return nil
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID, the 26-character sortable ID that GoToSocial uses
+// as the primary key of most tables.
+func newULID(t time.Time) (string, error) {
+	var raw [16]byte
+	ms := uint64(t.UnixMilli())
+	binary.BigEndian.PutUint16(raw[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(raw[2:6], uint32(ms))
+	_, err := rand.Read(raw[6:])
+	if err != nil {
+		return "", fmt.Errorf("failed to generate ULID: %w", err)
+	}
+
+	// 128 bits, encoded 5 bits at a time from the most significant end,
+	// with the first character carrying only the top 3 bits.
+	hi := binary.BigEndian.Uint64(raw[0:8])
+	lo := binary.BigEndian.Uint64(raw[8:16])
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordBase32[lo&0x1f]
+		lo = (lo >> 5) | (hi << 59)
+		hi >>= 5
+	}
+	return string(out[:]), nil
+}
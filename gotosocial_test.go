@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// gotosocialTestFixtures adds, to the rows in scripts/gotosocial-schema.sql,
+// a non-admin user and two blocks that RapidBlock manages.
+const gotosocialTestFixtures = `
+INSERT INTO accounts (id, username) VALUES ('01AAAAAAAAAAAAAAAAAAAAAAAA', 'user');
+INSERT INTO users (id, email, account_id, admin) VALUES ('01CCCCCCCCCCCCCCCCCCCCCCCC', 'user@example.org', '01AAAAAAAAAAAAAAAAAAAAAAAA', false);
+INSERT INTO domain_blocks (id, domain, created_by_account_id, private_comment, public_comment) VALUES
+	('01FFFFFFFFFFFFFFFFFFFFFFFF', 'stale.example', '01F8MH17FWEB39HZJ76B6VXSKF', 'RapidBlock', 'old'),
+	('01GGGGGGGGGGGGGGGGGGGGGGGG', 'update.example', '01F8MH17FWEB39HZJ76B6VXSKF', 'RapidBlock', 'old reason');
+`
+
+type gotosocialTestRow struct {
+	ID             string
+	CreatedBy      string
+	PrivateComment string
+	PublicComment  string
+}
+
+func newGoToSocialTestDB(t *testing.T) string {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "sqlite.db")
+	db, err := sql.Open("sqlite", filePath)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer db.Close()
+	for _, script := range []string{string(ReadFile(filepath.Join("scripts", "gotosocial-schema.sql"))), gotosocialTestFixtures} {
+		_, err = db.Exec(script)
+		if err != nil {
+			t.Fatalf("failed to create schema: %v", err)
+		}
+	}
+	return filePath
+}
+
+func readGoToSocialTestRows(t *testing.T, filePath string) map[string]gotosocialTestRow {
+	t.Helper()
+	db, err := sql.Open("sqlite", filePath)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT id, domain, created_by_account_id, COALESCE(private_comment, ''), COALESCE(public_comment, '') FROM domain_blocks`)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	defer rows.Close()
+	out := make(map[string]gotosocialTestRow)
+	for rows.Next() {
+		var domain string
+		var row gotosocialTestRow
+		err = rows.Scan(&row.ID, &domain, &row.CreatedBy, &row.PrivateComment, &row.PublicComment)
+		if err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		out[domain] = row
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatalf("Rows: %v", err)
+	}
+	return out
+}
+
+func TestApplyGoToSocialSQLite(t *testing.T) {
+	filePath := newGoToSocialTestDB(t)
+	file := BlockFile{
+		Blocks: map[string]Block{
+			"new.example":       {IsBlocked: true, Reason: "harassment"},
+			"update.example":    {IsBlocked: true, Reason: "new reason"},
+			"local.example":     {IsBlocked: true, Reason: "upstream reason"},
+			"stale.example":     {IsBlocked: false},
+			"unblocked.example": {IsBlocked: false},
+		},
+	}
+	opts := ApplyOptions{
+		Software:    GoToSocial,
+		DatabaseURL: "sqlite://" + filePath,
+		Policy:      DefaultPolicy(),
+		Force:       true,
+	}
+
+	plan, err := ApplyGoToSocial(context.Background(), file, opts)
+	if err != nil {
+		t.Fatalf("ApplyGoToSocial: %v", err)
+	}
+	totals := plan.Totals()
+	if totals.Inserted != 1 || totals.Updated != 1 || totals.Deleted != 1 {
+		t.Errorf("Totals: expected 1 inserted, 1 updated, 1 deleted, got %+v", totals)
+	}
+
+	rows := readGoToSocialTestRows(t, filePath)
+	if len(rows) != 3 {
+		t.Errorf("expected 3 domain blocks, got %d: %+v", len(rows), rows)
+	}
+	if _, found := rows["stale.example"]; found {
+		t.Errorf("stale.example: managed block was not deleted")
+	}
+	if row := rows["local.example"]; row.PrivateComment != "blocked by hand" || row.PublicComment != "spam" {
+		t.Errorf("local.example: admin-owned block was modified: %+v", row)
+	}
+	if row := rows["update.example"]; row.ID != "01GGGGGGGGGGGGGGGGGGGGGGGG" || row.PublicComment != "new reason" || row.PrivateComment != WellKnownPrivateComment {
+		t.Errorf("update.example: unexpected row: %+v", row)
+	}
+	row, found := rows["new.example"]
+	switch {
+	case !found:
+		t.Errorf("new.example: block was not inserted")
+	case len(row.ID) != 26:
+		t.Errorf("new.example: expected a 26-character ULID, got %q", row.ID)
+	case row.CreatedBy != "01F8MH17FWEB39HZJ76B6VXSKF":
+		t.Errorf("new.example: expected block to be created by the admin account, got %q", row.CreatedBy)
+	case row.PrivateComment != WellKnownPrivateComment || row.PublicComment != "harassment":
+		t.Errorf("new.example: unexpected row: %+v", row)
+	}
+
+	opts.DryRun = true
+	plan, err = ApplyGoToSocial(context.Background(), file, opts)
+	if err != nil {
+		t.Fatalf("ApplyGoToSocial: second run: %v", err)
+	}
+	if totals := plan.Totals(); totals.Inserted+totals.Updated+totals.Deleted != 0 {
+		t.Errorf("Totals: second run should have nothing to do, got %+v", totals)
+	}
+}
+
+func TestNewULID(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	a, err := newULID(now)
+	if err != nil {
+		t.Fatalf("newULID: %v", err)
+	}
+	b, err := newULID(now.Add(time.Millisecond))
+	if err != nil {
+		t.Fatalf("newULID: %v", err)
+	}
+	if len(a) != 26 || len(b) != 26 {
+		t.Fatalf("newULID: expected 26 characters, got %q and %q", a, b)
+	}
+	if a[:10] >= b[:10] {
+		t.Errorf("newULID: expected %q to sort before %q", a, b)
+	}
+}
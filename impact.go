@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	pgx "github.com/jackc/pgx/v5"
+)
+
+// DomainImpact describes who a new or escalated block would affect.  A
+// suspension severs every follow relationship between local accounts and
+// the domain's accounts, and LocalUsers counts the local accounts on
+// either end of one of those.
+type DomainImpact struct {
+	RemoteAccounts int64 `json:"remoteAccounts"`
+	LocalUsers     int64 `json:"localUsers"`
+	Follows        int64 `json:"follows"`
+	Followers      int64 `json:"followers"`
+}
+
+const SQLSelectDomainImpactMastodon = `
+WITH remote AS (
+	SELECT id FROM public.accounts
+	WHERE domain = $1 OR right(domain, length($1) + 1) = '.' || $1
+),
+local_follows AS (
+	SELECT f.account_id AS local_id
+	FROM public.follows AS f
+	INNER JOIN public.accounts AS a ON a.id = f.account_id
+	WHERE a.domain IS NULL AND f.target_account_id IN (SELECT id FROM remote)
+),
+local_followed AS (
+	SELECT f.target_account_id AS local_id
+	FROM public.follows AS f
+	INNER JOIN public.accounts AS a ON a.id = f.target_account_id
+	WHERE a.domain IS NULL AND f.account_id IN (SELECT id FROM remote)
+)
+SELECT
+	(SELECT count(*) FROM remote),
+	(SELECT count(*) FROM (SELECT local_id FROM local_follows UNION SELECT local_id FROM local_followed) AS u),
+	(SELECT count(*) FROM local_follows),
+	(SELECT count(*) FROM local_followed)
+`
+
+// HasImpact returns true if item blocks a domain that was not blocked
+// before, or escalates an existing block to a suspension.
+func (item PlanItem) HasImpact() bool {
+	switch item.Action {
+	case InsertAction:
+		return true
+	case UpdateAction:
+		return item.New.Severity == SeveritySuspend && item.Old.Severity != SeveritySuspend
+	default:
+		return false
+	}
+}
+
+// AddMastodonImpact fills in the Impact of each item in plan for which
+// HasImpact is true.
+func AddMastodonImpact(ctx context.Context, tx pgx.Tx, plan *Plan) error {
+	const sql = SQLSelectDomainImpactMastodon
+
+	for i := range plan.Items {
+		item := &plan.Items[i]
+		if !item.HasImpact() {
+			continue
+		}
+
+		var impact DomainImpact
+		err := tx.QueryRow(ctx, sql, item.Domain).Scan(
+			&impact.RemoteAccounts,
+			&impact.LocalUsers,
+			&impact.Follows,
+			&impact.Followers,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to QueryRow %q: %w", sql, err)
+		}
+		item.Impact = &impact
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPlanItemHasImpact(t *testing.T) {
+	suspend := MastodonDomainBlock{Severity: SeveritySuspend}
+	silence := MastodonDomainBlock{Severity: SeveritySilence}
+	noop := MastodonDomainBlock{Severity: SeverityNoOp}
+
+	type testCase struct {
+		name string
+		item PlanItem
+		want bool
+	}
+	testCases := []testCase{
+		{name: "insert-suspend", item: PlanItem{Action: InsertAction, New: &suspend}, want: true},
+		{name: "insert-silence", item: PlanItem{Action: InsertAction, New: &silence}, want: true},
+		{name: "escalate", item: PlanItem{Action: UpdateAction, Old: &silence, New: &suspend}, want: true},
+		{name: "escalate-from-noop", item: PlanItem{Action: UpdateAction, Old: &noop, New: &suspend}, want: true},
+		{name: "same-severity", item: PlanItem{Action: UpdateAction, Old: &suspend, New: &suspend}},
+		{name: "downgrade", item: PlanItem{Action: UpdateAction, Old: &suspend, New: &silence}},
+		{name: "silence-from-noop", item: PlanItem{Action: UpdateAction, Old: &noop, New: &silence}},
+		{name: "delete", item: PlanItem{Action: DeleteAction, Old: &suspend}},
+		{name: "skip", item: PlanItem{Action: SkipAction}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.item.HasImpact(); got != tc.want {
+				t.Errorf("HasImpact: expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPlanWriteTextImpact(t *testing.T) {
+	inserted := MastodonDomainBlock{Domain: "new.example", PrivateComment: WellKnownPrivateComment, PublicComment: "spam", Severity: SeveritySuspend}
+	plan := Plan{Items: []PlanItem{
+		{Domain: "new.example", Action: InsertAction, New: &inserted, Impact: &DomainImpact{RemoteAccounts: 12, LocalUsers: 3, Follows: 4, Followers: 1}},
+		{Domain: "local.example", Action: SkipAction, Reason: SkipReasonLocalDecision},
+	}}
+	want := "" +
+		"ACTION  DOMAIN         OLD SEVERITY  OLD PUBLIC COMMENT  NEW SEVERITY  NEW PUBLIC COMMENT  REASON                REMOTE ACCOUNTS  LOCAL USERS\n" +
+		"insert  new.example    -             -                   suspend       spam                -                     12               3\n" +
+		"skip    local.example  -             -                   -             -                   local admin decision  -                -\n"
+
+	var buf bytes.Buffer
+	err := plan.WriteText(&buf)
+	if err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("WriteText:\n%s\nwant:\n%s", strings.TrimSuffix(got, "\n"), strings.TrimSuffix(want, "\n"))
+	}
+
+	raw, err := json.Marshal(plan.Items)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	wantJSON := `"impact":{"remoteAccounts":12,"localUsers":3,"follows":4,"followers":1}`
+	if !bytes.Contains(raw, []byte(wantJSON)) || bytes.Count(raw, []byte(`"impact"`)) != 1 {
+		t.Errorf("json.Marshal: expected only the insert to carry %s, got %s", wantJSON, raw)
+	}
+}
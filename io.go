@@ -5,8 +5,11 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+
+	yaml "gopkg.in/yaml.v3"
 )
 
 var gBuffer bytes.Buffer
@@ -72,20 +75,88 @@ func WriteFile(filePath string, data []byte, isPrivate bool) {
 	}
 }
 
+// WriteFileAtomic replaces the contents of filePath with data, such that
+// readers see either the old contents or the new contents but never a mix.
+// Unlike WriteFile, it returns errors instead of exiting.
+func WriteFileAtomic(filePath string, data []byte, isPrivate bool) error {
+	mode := os.FileMode(0o644)
+	if isPrivate {
+		mode = os.FileMode(0o600)
+	}
+
+	dirPath := filepath.Dir(filePath)
+	dir, err := os.OpenFile(dirPath, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("%q: failed to open directory containing file: %w", filePath, err)
+	}
+	defer dir.Close()
+
+	file, err := os.CreateTemp(dirPath, "."+filepath.Base(filePath)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("%q: failed to create temporary file: %w", filePath, err)
+	}
+	tempPath := file.Name()
+
+	fail := func(format string, err error) error {
+		_ = file.Close()
+		_ = os.Remove(tempPath)
+		return fmt.Errorf(format, filePath, err)
+	}
+
+	err = file.Chmod(mode)
+	if err != nil {
+		return fail("%q: failed to set file permissions: %w", err)
+	}
+
+	_, err = file.Write(data)
+	if err != nil {
+		return fail("%q: I/O error: %w", err)
+	}
+
+	err = file.Sync()
+	if err != nil {
+		return fail("%q: I/O error: %w", err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return fmt.Errorf("%q: failed to close file: %w", filePath, err)
+	}
+
+	err = os.Rename(tempPath, filePath)
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return fmt.Errorf("%q: failed to replace file: %w", filePath, err)
+	}
+
+	err = dir.Sync()
+	if err != nil {
+		return fmt.Errorf("%q: I/O error: %w", filePath, err)
+	}
+	return nil
+}
+
 func ReadKeySigFile(filePath string, expectedSize int) []byte {
-	raw := ReadFile(filePath)
+	data, err := DecodeKeySig(ReadFile(filePath), expectedSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fatal: %q: %v\n", filePath, err)
+		os.Exit(1)
+	}
+	return data
+}
+
+func DecodeKeySig(raw []byte, expectedSize int) ([]byte, error) {
 	raw = reSpace.ReplaceAllLiteral(raw, nil)
 	data := make([]byte, base64.StdEncoding.DecodedLen(len(raw)))
 	dataSize, err := base64.StdEncoding.Decode(data, raw)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "fatal: %q: failed to decode from base-64: %v\n", filePath, err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to decode from base-64: %w", err)
 	}
 	if expectedSize >= 0 && dataSize != expectedSize {
-		fmt.Fprintf(os.Stderr, "fatal: %q: data has wrong length: expected %d bytes, got %d bytes\n", filePath, expectedSize, dataSize)
-		os.Exit(1)
+		return nil, fmt.Errorf("data has wrong length: expected %d bytes, got %d bytes", expectedSize, dataSize)
 	}
-	return data[:dataSize]
+	return data[:dataSize], nil
 }
 
 func WriteKeySigFile(filePath string, data []byte, isPrivate bool) {
@@ -106,6 +177,33 @@ func ReadJsonFile(out any, filePath string) {
 	}
 }
 
+func LoadJsonFile(out any, filePath string) error {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("%q: failed to read file: %w", filePath, err)
+	}
+	d := json.NewDecoder(bytes.NewReader(raw))
+	err = d.Decode(out)
+	if err != nil {
+		return fmt.Errorf("%q: failed to decode JSON data: %w", filePath, err)
+	}
+	return nil
+}
+
+func LoadYamlFile(out any, filePath string) error {
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("%q: failed to read file: %w", filePath, err)
+	}
+	d := yaml.NewDecoder(bytes.NewReader(raw))
+	d.KnownFields(true)
+	err = d.Decode(out)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("%q: failed to decode YAML data: %w", filePath, err)
+	}
+	return nil
+}
+
 func WriteJsonFile(filePath string, in any, isPrivate bool) {
 	gBuffer.Reset()
 	e := json.NewEncoder(&gBuffer)
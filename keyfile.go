@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// A private key file is either the bare base-64 Ed25519 seed, an encrypted
+// key, which is a JSON object holding the seed encrypted with AES-256-GCM
+// under a key derived from a passphrase with scrypt, or a minisign secret
+// key (see minisign.go).  The scrypt parameters are stored with the key,
+// and are authenticated along with it, so that they can be raised later
+// without breaking old keys.
+const (
+	EncryptedKeySpecV1 = "https://rapidblock.org/spec/v1/encrypted-key"
+
+	KeyFormatBase64   = "base64"
+	KeyFormatMinisign = "minisign"
+
+	AllKeyFormats = KeyFormatBase64 + ", " + KeyFormatMinisign
+
+	KDFScrypt       = "scrypt"
+	CipherAES256GCM = "aes-256-gcm"
+
+	scryptDefaultN = 1 << 17
+	scryptDefaultR = 8
+	scryptDefaultP = 1
+	scryptMaxN     = 1 << 22
+	scryptMaxR     = 32
+	scryptMaxP     = 16
+	scryptSaltSize = 32
+)
+
+type EncryptedKey struct {
+	Spec       string       `json:"@spec"`
+	KDF        string       `json:"kdf"`
+	KDFParams  ScryptParams `json:"kdfParams"`
+	Salt       []byte       `json:"salt"`
+	Cipher     string       `json:"cipher"`
+	Nonce      []byte       `json:"nonce"`
+	Ciphertext []byte       `json:"ciphertext"`
+}
+
+type ScryptParams struct {
+	N int `json:"n"`
+	R int `json:"r"`
+	P int `json:"p"`
+}
+
+// IsEncryptedKey returns true if raw looks like an encrypted key rather
+// than a bare base-64 seed.
+func IsEncryptedKey(raw []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{"))
+}
+
+func EncryptKey(seed []byte, passphrase []byte) (EncryptedKey, error) {
+	key := EncryptedKey{
+		Spec:      EncryptedKeySpecV1,
+		KDF:       KDFScrypt,
+		KDFParams: ScryptParams{N: scryptDefaultN, R: scryptDefaultR, P: scryptDefaultP},
+		Salt:      make([]byte, scryptSaltSize),
+		Cipher:    CipherAES256GCM,
+	}
+	_, err := rand.Read(key.Salt)
+	if err != nil {
+		return key, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	aead, err := key.aead(passphrase)
+	if err != nil {
+		return key, err
+	}
+	key.Nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(key.Nonce)
+	if err != nil {
+		return key, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	key.Ciphertext = aead.Seal(nil, key.Nonce, seed, key.additionalData())
+	return key, nil
+}
+
+// Decrypt returns the seed, or an error if the passphrase is wrong or the
+// file has been tampered with; AES-GCM cannot tell the two apart.
+func (key EncryptedKey) Decrypt(passphrase []byte) ([]byte, error) {
+	aead, err := key.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(key.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("nonce has wrong length: expected %d bytes, got %d bytes", aead.NonceSize(), len(key.Nonce))
+	}
+	seed, err := aead.Open(nil, key.Nonce, key.Ciphertext, key.additionalData())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt private key: wrong passphrase?")
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("data has wrong length: expected %d bytes, got %d bytes", ed25519.SeedSize, len(seed))
+	}
+	return seed, nil
+}
+
+func (key EncryptedKey) aead(passphrase []byte) (cipher.AEAD, error) {
+	if key.Spec != EncryptedKeySpecV1 {
+		return nil, fmt.Errorf("unsupported encrypted key %q, expected %q", key.Spec, EncryptedKeySpecV1)
+	}
+	if key.KDF != KDFScrypt {
+		return nil, fmt.Errorf("unknown key derivation function %q", key.KDF)
+	}
+	if key.Cipher != CipherAES256GCM {
+		return nil, fmt.Errorf("unknown cipher %q", key.Cipher)
+	}
+	params := key.KDFParams
+	switch {
+	case params.N < 2 || params.N > scryptMaxN || params.N&(params.N-1) != 0:
+		return nil, fmt.Errorf("scrypt parameter n=%d is not a power of 2 between 2 and %d", params.N, scryptMaxN)
+	case params.R < 1 || params.R > scryptMaxR:
+		return nil, fmt.Errorf("scrypt parameter r=%d is not between 1 and %d", params.R, scryptMaxR)
+	case params.P < 1 || params.P > scryptMaxP:
+		return nil, fmt.Errorf("scrypt parameter p=%d is not between 1 and %d", params.P, scryptMaxP)
+	}
+
+	derived, err := scrypt.Key(passphrase, key.Salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key from passphrase: %w", err)
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize AES: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize AES-GCM: %w", err)
+	}
+	return aead, nil
+}
+
+// additionalData binds the algorithm choices to the ciphertext.
+func (key EncryptedKey) additionalData() []byte {
+	return []byte(fmt.Sprintf("%s\nkdf: %s n=%d r=%d p=%d\ncipher: %s\n", key.Spec, key.KDF, key.KDFParams.N, key.KDFParams.R, key.KDFParams.P, key.Cipher))
+}
+
+func (key EncryptedKey) Encode() ([]byte, error) {
+	raw, err := json.MarshalIndent(key, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode JSON data: %w", err)
+	}
+	return append(raw, '\n'), nil
+}
+
+func DecodeEncryptedKey(raw []byte) (EncryptedKey, error) {
+	var key EncryptedKey
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.DisallowUnknownFields()
+	err := d.Decode(&key)
+	if err != nil {
+		return key, fmt.Errorf("failed to decode encrypted key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// newTestEncryptedKey encrypts seed like EncryptKey, but with cheap scrypt
+// parameters.
+func newTestEncryptedKey(t *testing.T, seed []byte, passphrase []byte) EncryptedKey {
+	t.Helper()
+	key := EncryptedKey{
+		Spec:      EncryptedKeySpecV1,
+		KDF:       KDFScrypt,
+		KDFParams: ScryptParams{N: 1 << 10, R: 8, P: 1},
+		Salt:      bytes.Repeat([]byte{0x5a}, scryptSaltSize),
+		Cipher:    CipherAES256GCM,
+	}
+	aead, err := key.aead(passphrase)
+	if err != nil {
+		t.Fatalf("aead: %v", err)
+	}
+	key.Nonce = make([]byte, aead.NonceSize())
+	key.Ciphertext = aead.Seal(nil, key.Nonce, seed, key.additionalData())
+	return key
+}
+
+func TestEncryptKeyRoundTrip(t *testing.T) {
+	_, privKey := newTestKey(t)
+	seed := privKey.Seed()
+	passphrase := []byte("correct horse battery staple")
+
+	key, err := EncryptKey(seed, passphrase)
+	if err != nil {
+		t.Fatalf("EncryptKey: %v", err)
+	}
+	if key.KDFParams != (ScryptParams{N: scryptDefaultN, R: scryptDefaultR, P: scryptDefaultP}) {
+		t.Errorf("EncryptKey: unexpected scrypt parameters %+v", key.KDFParams)
+	}
+	raw, err := key.Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if !IsEncryptedKey(raw) || IsEncryptedKey([]byte("AAAA\n")) {
+		t.Errorf("IsEncryptedKey: cannot tell an encrypted key from a bare seed")
+	}
+	if bytes.Contains(raw, seed) {
+		t.Errorf("Encode: seed appears in the clear")
+	}
+
+	decoded, err := DecodeEncryptedKey(raw)
+	if err != nil {
+		t.Fatalf("DecodeEncryptedKey: %v", err)
+	}
+	got, err := decoded.Decrypt(passphrase)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(got, seed) {
+		t.Errorf("Decrypt: expected the original seed")
+	}
+	if _, err = decoded.Decrypt([]byte("wrong")); err == nil || !strings.Contains(err.Error(), "wrong passphrase?") {
+		t.Errorf("Decrypt: expected a wrong passphrase to be rejected, got %v", err)
+	}
+}
+
+func TestEncryptedKeyDecryptErrors(t *testing.T) {
+	_, privKey := newTestKey(t)
+	passphrase := []byte("passphrase")
+	base := newTestEncryptedKey(t, privKey.Seed(), passphrase)
+	if _, err := base.Decrypt(passphrase); err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+
+	type testCase struct {
+		name       string
+		mutate     func(key *EncryptedKey)
+		passphrase []byte
+		wantErr    string
+	}
+	testCases := []testCase{
+		{name: "wrong-passphrase", passphrase: []byte("Passphrase"), wantErr: "wrong passphrase?"},
+		{name: "empty-passphrase", passphrase: []byte{}, wantErr: "wrong passphrase?"},
+		{name: "tampered-ciphertext", mutate: func(key *EncryptedKey) { key.Ciphertext[0] ^= 1 }, wantErr: "wrong passphrase?"},
+		{name: "tampered-salt", mutate: func(key *EncryptedKey) { key.Salt[0] ^= 1 }, wantErr: "wrong passphrase?"},
+		{name: "lowered-n", mutate: func(key *EncryptedKey) { key.KDFParams.N = 1 << 9 }, wantErr: "wrong passphrase?"},
+		{name: "wrong-spec", mutate: func(key *EncryptedKey) { key.Spec = "https://example.com/spec" }, wantErr: "unsupported encrypted key"},
+		{name: "unknown-kdf", mutate: func(key *EncryptedKey) { key.KDF = "argon2id" }, wantErr: `unknown key derivation function "argon2id"`},
+		{name: "unknown-cipher", mutate: func(key *EncryptedKey) { key.Cipher = "chacha20-poly1305" }, wantErr: `unknown cipher "chacha20-poly1305"`},
+		{name: "n-not-power-of-2", mutate: func(key *EncryptedKey) { key.KDFParams.N = 1000 }, wantErr: "scrypt parameter n=1000 is not a power of 2"},
+		{name: "n-too-large", mutate: func(key *EncryptedKey) { key.KDFParams.N = scryptMaxN * 2 }, wantErr: "is not a power of 2 between 2 and"},
+		{name: "r-too-large", mutate: func(key *EncryptedKey) { key.KDFParams.R = scryptMaxR + 1 }, wantErr: "scrypt parameter r=33"},
+		{name: "p-zero", mutate: func(key *EncryptedKey) { key.KDFParams.P = 0 }, wantErr: "scrypt parameter p=0"},
+		{name: "short-nonce", mutate: func(key *EncryptedKey) { key.Nonce = key.Nonce[:8] }, wantErr: "nonce has wrong length"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key := base
+			key.Salt = append([]byte(nil), base.Salt...)
+			key.Ciphertext = append([]byte(nil), base.Ciphertext...)
+			if tc.mutate != nil {
+				tc.mutate(&key)
+			}
+			if tc.passphrase == nil {
+				tc.passphrase = passphrase
+			}
+			_, err := key.Decrypt(tc.passphrase)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Decrypt: expected an error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDecodeEncryptedKeyUnknownField(t *testing.T) {
+	_, err := DecodeEncryptedKey([]byte(`{"@spec":"` + EncryptedKeySpecV1 + `","hint":"my dog's name"}`))
+	if err == nil || !strings.Contains(err.Error(), `unknown field "hint"`) {
+		t.Errorf("DecodeEncryptedKey: expected an unknown field error, got %v", err)
+	}
+}
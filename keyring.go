@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A keyring is the set of public keys trusted to sign a blocklist, each
+// with an optional validity window, so that a publisher can ship its next
+// key ahead of time and retire its current key on a schedule.  A keyring is
+// loaded from a directory, a YAML keyring file, or a single public key
+// file.  In a directory, each "*.pub" file is a base-64 or minisign public
+// key named after the file, each "*.yaml" file is a keyring file, and each
+// "*.transition.json" file is a key transition statement.
+//
+// A key transition statement is signed by a trusted key to introduce its
+// successor, and optionally to retire itself.  It only counts if the old
+// key was valid when it signed the statement, so that the successor stays
+// trusted after the old key expires, and a key introduces at most one
+// successor, so that a key that has handed over cannot add another.  A
+// statement that does not count is skipped with a warning, rather than
+// making the whole keyring unusable.
+const (
+	KeyTransitionSpecV1 = "https://rapidblock.org/spec/v1/key-transition"
+
+	keyringPubSuffix        = ".pub"
+	keyringTransitionSuffix = ".transition.json"
+)
+
+var errUnknownKey = errors.New("not signed by a key in the keyring")
+
+type Keyring struct {
+	Keys []TrustedKey
+
+	pending []SignedKeyTransition
+}
+
+type TrustedKey struct {
+	Name      string
+	PublicKey ed25519.PublicKey
+	NotBefore time.Time
+	NotAfter  time.Time
+
+	// Holder is the fingerprint of the first key in the chain of key
+	// transition statements that introduced this key, if any.  Keys with
+	// the same holder count as one signer.
+	Holder string
+
+	// Successor is the fingerprint of the key that this key introduced by
+	// key transition, if any.
+	Successor string
+
+	// MinisignKeyID is the key ID from a minisign public key file, if the
+	// key came from one.
+	MinisignKeyID []byte
+}
+
+type KeyringFile struct {
+	Keys []KeyringFileEntry `yaml:"keys"`
+}
+
+type KeyringFileEntry struct {
+	Name      string    `yaml:"name"`
+	PublicKey string    `yaml:"public_key"`
+	NotBefore time.Time `yaml:"not_before"`
+	NotAfter  time.Time `yaml:"not_after"`
+}
+
+type KeyTransition struct {
+	Spec           string     `json:"@spec"`
+	OldKey         string     `json:"oldKey"`
+	NewKey         []byte     `json:"newKey"`
+	NewKeyName     string     `json:"newKeyName,omitempty"`
+	NotBefore      time.Time  `json:"notBefore"`
+	OldKeyNotAfter *time.Time `json:"oldKeyNotAfter,omitempty"`
+}
+
+// SignedKeyTransition is a key transition statement as published.  The
+// signature covers the SHA-256 checksum of the statement in compact JSON
+// form, so that re-indenting the file does not invalidate it, and is made
+// for SignaturePurposeKeyTransition, so that a blocklist signature over the
+// same bytes does not count.
+type SignedKeyTransition struct {
+	Statement json.RawMessage `json:"statement"`
+	Signature SignatureEntry  `json:"signature"`
+
+	stmt KeyTransition
+}
+
+func (key TrustedKey) Fingerprint() string {
+	return KeyFingerprint(key.PublicKey)
+}
+
+func (key TrustedKey) HolderFingerprint() string {
+	if key.Holder != "" {
+		return key.Holder
+	}
+	return key.Fingerprint()
+}
+
+// KeyID returns the key's minisign key ID, or the default key ID if it did
+// not come from a minisign public key file.
+func (key TrustedKey) KeyID() []byte {
+	if key.MinisignKeyID != nil {
+		return key.MinisignKeyID
+	}
+	return DefaultMinisignKeyID(key.PublicKey)
+}
+
+func (key TrustedKey) ValidAt(t time.Time) bool {
+	if !key.NotBefore.IsZero() && t.Before(key.NotBefore) {
+		return false
+	}
+	if !key.NotAfter.IsZero() && !t.Before(key.NotAfter) {
+		return false
+	}
+	return true
+}
+
+func (key TrustedKey) String() string {
+	str := key.Fingerprint()
+	if key.Name != "" {
+		str = fmt.Sprintf("%q (%s)", key.Name, str)
+	}
+	return str
+}
+
+// Validity describes the key's validity window for error messages.
+func (key TrustedKey) Validity() string {
+	var parts []string
+	if !key.NotBefore.IsZero() {
+		parts = append(parts, "from "+key.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if !key.NotAfter.IsZero() {
+		parts = append(parts, "until "+key.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if len(parts) == 0 {
+		return "always valid"
+	}
+	return "valid " + strings.Join(parts, " ")
+}
+
+// LoadKeyring reads the keyring at filePath and applies its key transition
+// statements as of time now.
+func LoadKeyring(filePath string, now time.Time) (Keyring, error) {
+	var kr Keyring
+	err := kr.Load(filePath)
+	if err == nil {
+		kr.Resolve(now)
+	}
+	return kr, err
+}
+
+// Load adds the keys at filePath, and queues its key transition statements
+// for Resolve.  A file that is neither a YAML keyring file nor a key
+// transition statement is read as a single public key file.
+func (kr *Keyring) Load(filePath string) error {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("%q: failed to read keyring: %w", filePath, err)
+	}
+	if !fi.IsDir() {
+		return kr.loadFile(filePath, true)
+	}
+
+	entries, err := os.ReadDir(filePath)
+	if err != nil {
+		return fmt.Errorf("%q: failed to read keyring directory: %w", filePath, err)
+	}
+	var errs ErrorList
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		errs = appendErrors(errs, kr.loadFile(filepath.Join(filePath, entry.Name()), false))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (kr *Keyring) loadFile(filePath string, isExplicit bool) error {
+	name := filepath.Base(filePath)
+	switch {
+	case strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml"):
+		var file KeyringFile
+		err := LoadYamlFile(&file, filePath)
+		if err != nil {
+			return err
+		}
+		var errs ErrorList
+		for index, entry := range file.Keys {
+			pubKey, keyID, err := DecodePublicKey([]byte(entry.PublicKey))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%q: keys[%d]: public_key: %w", filePath, index, err))
+				continue
+			}
+			if !entry.NotAfter.IsZero() && !entry.NotAfter.After(entry.NotBefore) {
+				errs = append(errs, fmt.Errorf("%q: keys[%d]: not_after must be later than not_before", filePath, index))
+				continue
+			}
+			kr.Add(TrustedKey{
+				Name:          entry.Name,
+				PublicKey:     pubKey,
+				NotBefore:     entry.NotBefore,
+				NotAfter:      entry.NotAfter,
+				MinisignKeyID: keyID,
+			})
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+		return nil
+
+	case strings.HasSuffix(name, keyringTransitionSuffix):
+		return kr.LoadTransition(filePath)
+
+	case strings.HasSuffix(name, keyringPubSuffix) || isExplicit:
+		var pubKey ed25519.PublicKey
+		var keyID []byte
+		raw, err := os.ReadFile(filePath)
+		if err == nil {
+			pubKey, keyID, err = DecodePublicKey(raw)
+		}
+		if err != nil {
+			return fmt.Errorf("%q: %w", filePath, err)
+		}
+		kr.Add(TrustedKey{Name: strings.TrimSuffix(name, keyringPubSuffix), PublicKey: pubKey, MinisignKeyID: keyID})
+		return nil
+	}
+	return nil
+}
+
+// LoadTransition queues the key transition statement at filePath for
+// Resolve, if the file exists.
+func (kr *Keyring) LoadTransition(filePath string) error {
+	raw, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%q: failed to read file: %w", filePath, err)
+	}
+	t, err := DecodeKeyTransition(raw)
+	if err != nil {
+		return fmt.Errorf("%q: %w", filePath, err)
+	}
+	kr.pending = append(kr.pending, t)
+	return nil
+}
+
+// Resolve applies the queued key transition statements as of time now.
+// Since one statement may introduce the key that signed another, they are
+// applied in as many passes as it takes.  Statements that cannot be applied
+// are skipped with a warning.
+func (kr *Keyring) Resolve(now time.Time) {
+	pending := kr.pending
+	kr.pending = nil
+
+	for len(pending) > 0 {
+		var unknown []SignedKeyTransition
+		for _, t := range pending {
+			err := kr.ApplyTransition(t, now)
+			switch {
+			case errors.Is(err, errUnknownKey):
+				unknown = append(unknown, t)
+			case err != nil:
+				fmt.Fprintf(os.Stderr, "warning: skipping %v\n", err)
+			}
+		}
+		if len(unknown) == len(pending) {
+			for _, t := range unknown {
+				fmt.Fprintf(os.Stderr, "warning: skipping key transition from %s: %v\n", t.stmt.OldKey, errUnknownKey)
+			}
+			break
+		}
+		pending = unknown
+	}
+}
+
+// Add adds key, unless the keyring already has it.
+func (kr *Keyring) Add(key TrustedKey) {
+	if kr.Find(key.Fingerprint()) < 0 {
+		kr.Keys = append(kr.Keys, key)
+	}
+}
+
+// Find returns the index of the key with the given fingerprint, or -1.
+func (kr Keyring) Find(fingerprint string) int {
+	for index, key := range kr.Keys {
+		if key.Fingerprint() == fingerprint {
+			return index
+		}
+	}
+	return -1
+}
+
+// FindKeyID returns the index of the key with the given minisign key ID,
+// or -1.
+func (kr Keyring) FindKeyID(keyID []byte) int {
+	for index, key := range kr.Keys {
+		if bytes.Equal(key.KeyID(), keyID) {
+			return index
+		}
+	}
+	return -1
+}
+
+func (kr Keyring) Clone() Keyring {
+	return Keyring{
+		Keys:    append([]TrustedKey(nil), kr.Keys...),
+		pending: append([]SignedKeyTransition(nil), kr.pending...),
+	}
+}
+
+// HolderCount returns the number of distinct holders of the keys.
+func (kr Keyring) HolderCount() int {
+	holders := make(map[string]struct{}, len(kr.Keys))
+	for _, key := range kr.Keys {
+		holders[key.HolderFingerprint()] = struct{}{}
+	}
+	return len(holders)
+}
+
+// ValidKeys returns the keys that are valid at time now.
+func (kr Keyring) ValidKeys(now time.Time) []TrustedKey {
+	out := make([]TrustedKey, 0, len(kr.Keys))
+	for _, key := range kr.Keys {
+		if key.ValidAt(now) {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+// ApplyTransition verifies t against the keyring, then adds the new key and
+// retires the old one as t says.  The old key must have been valid when it
+// signed t, which must not be later than time now.  Applying the same
+// statement twice has no further effect.
+func (kr *Keyring) ApplyTransition(t SignedKeyTransition, now time.Time) error {
+	stmt := t.stmt
+	index := kr.Find(t.Signature.KeyFingerprint)
+	if index < 0 {
+		return errUnknownKey
+	}
+	old := kr.Keys[index]
+	if stmt.OldKey != t.Signature.KeyFingerprint {
+		return fmt.Errorf("key transition from %s: signed by %s instead", stmt.OldKey, old)
+	}
+	newFingerprint := KeyFingerprint(stmt.NewKey)
+	switch {
+	case old.Successor == newFingerprint:
+		return nil
+	case old.Successor != "":
+		return fmt.Errorf("key transition from %s: the key has already been succeeded by %s", old, old.Successor)
+	case t.Signature.SignedAt.After(now):
+		return fmt.Errorf("key transition from %s: signed at %s, which is in the future", old, t.Signature.SignedAt.Format(time.RFC3339))
+	case !old.ValidAt(t.Signature.SignedAt):
+		return fmt.Errorf("key transition from %s: signed at %s, but the key is %s", old, t.Signature.SignedAt.Format(time.RFC3339), old.Validity())
+	}
+	checksum, err := t.Checksum()
+	if err != nil {
+		return err
+	}
+	err = t.Signature.Verify(old.PublicKey, SignaturePurposeKeyTransition, checksum)
+	if err != nil {
+		return fmt.Errorf("key transition from %s: %w", old, err)
+	}
+
+	if stmt.OldKeyNotAfter != nil && (old.NotAfter.IsZero() || stmt.OldKeyNotAfter.Before(old.NotAfter)) {
+		kr.Keys[index].NotAfter = *stmt.OldKeyNotAfter
+	}
+	kr.Keys[index].Successor = newFingerprint
+
+	name := stmt.NewKeyName
+	if name == "" {
+		name = newFingerprint
+	}
+	kr.Add(TrustedKey{
+		Name:      name,
+		PublicKey: ed25519.PublicKey(stmt.NewKey),
+		NotBefore: stmt.NotBefore,
+		Holder:    old.HolderFingerprint(),
+	})
+	return nil
+}
+
+func NewSignedKeyTransition(privKey ed25519.PrivateKey, stmt KeyTransition, now time.Time) (SignedKeyTransition, error) {
+	raw, err := json.Marshal(stmt)
+	if err != nil {
+		return SignedKeyTransition{}, fmt.Errorf("failed to encode JSON data: %w", err)
+	}
+	t := SignedKeyTransition{Statement: raw, stmt: stmt}
+	checksum, err := t.Checksum()
+	if err != nil {
+		return t, err
+	}
+	t.Signature = NewSignatureEntry(privKey, SignaturePurposeKeyTransition, checksum, false, now)
+	return t, nil
+}
+
+func (t SignedKeyTransition) Checksum() ([]byte, error) {
+	var buf bytes.Buffer
+	err := json.Compact(&buf, t.Statement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key transition statement: %w", err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return sum[:], nil
+}
+
+func (t SignedKeyTransition) Encode() ([]byte, error) {
+	raw, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode JSON data: %w", err)
+	}
+	return append(raw, '\n'), nil
+}
+
+func DecodeKeyTransition(raw []byte) (SignedKeyTransition, error) {
+	var t SignedKeyTransition
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.DisallowUnknownFields()
+	err := d.Decode(&t)
+	if err != nil {
+		return t, fmt.Errorf("failed to decode key transition: %w", err)
+	}
+	if len(t.Signature.Signature) != ed25519.SignatureSize {
+		return t, fmt.Errorf("key transition signature has wrong length: expected %d bytes, got %d bytes", ed25519.SignatureSize, len(t.Signature.Signature))
+	}
+
+	d = json.NewDecoder(bytes.NewReader(t.Statement))
+	d.DisallowUnknownFields()
+	err = d.Decode(&t.stmt)
+	if err != nil {
+		return t, fmt.Errorf("failed to decode key transition statement: %w", err)
+	}
+	if t.stmt.Spec != KeyTransitionSpecV1 {
+		return t, fmt.Errorf("unsupported key transition statement %q, expected %q", t.stmt.Spec, KeyTransitionSpecV1)
+	}
+	if len(t.stmt.NewKey) != ed25519.PublicKeySize {
+		return t, fmt.Errorf("key transition newKey has wrong length: expected %d bytes, got %d bytes", ed25519.PublicKeySize, len(t.stmt.NewKey))
+	}
+	return t, nil
+}
+
+// describeKeys lists fingerprints for error messages.
+func describeKeys(keys []TrustedKey) string {
+	strs := make([]string, len(keys))
+	for i, key := range keys {
+		strs[i] = key.String()
+	}
+	return strings.Join(strs, ", ")
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, filePath string, data []byte) {
+	t.Helper()
+	err := os.WriteFile(filePath, data, 0o644)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func newTestKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pubKey, privKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return pubKey, privKey
+}
+
+func newTestTransition(t *testing.T, privKey ed25519.PrivateKey, newPubKey ed25519.PublicKey, signedAt time.Time) SignedKeyTransition {
+	t.Helper()
+	stmt := KeyTransition{
+		Spec:      KeyTransitionSpecV1,
+		OldKey:    KeyFingerprint(privKey.Public().(ed25519.PublicKey)),
+		NewKey:    newPubKey,
+		NotBefore: signedAt,
+	}
+	signed, err := NewSignedKeyTransition(privKey, stmt, signedAt)
+	if err != nil {
+		t.Fatalf("NewSignedKeyTransition: %v", err)
+	}
+	raw, err := signed.Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	signed, err = DecodeKeyTransition(raw)
+	if err != nil {
+		t.Fatalf("DecodeKeyTransition: %v", err)
+	}
+	return signed
+}
+
+func TestApplyTransition(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	oldPubKey, oldPrivKey := newTestKey(t)
+	newPubKey, _ := newTestKey(t)
+	otherPubKey, _ := newTestKey(t)
+
+	type testCase struct {
+		name      string
+		notAfter  time.Time
+		signedAt  time.Time
+		wantValid int
+		wantErr   string
+	}
+	testCases := []testCase{
+		{name: "current", signedAt: now.Add(-time.Hour), wantValid: 2},
+		{name: "signed-before-expiry", notAfter: now.AddDate(-1, 0, 0), signedAt: now.AddDate(-1, 0, -1), wantValid: 1},
+		{name: "signed-after-expiry", notAfter: now.AddDate(-1, 0, 0), signedAt: now.Add(-time.Hour), wantErr: "but the key is valid until"},
+		{name: "future", signedAt: now.Add(time.Hour), wantErr: "in the future"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var kr Keyring
+			kr.Add(TrustedKey{Name: "old", PublicKey: oldPubKey, NotAfter: tc.notAfter})
+			err := kr.ApplyTransition(newTestTransition(t, oldPrivKey, newPubKey, tc.signedAt), now)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ApplyTransition: unexpected error: %v", err)
+				}
+				if n := len(kr.ValidKeys(now)); n != tc.wantValid {
+					t.Errorf("ValidKeys: expected %d keys, got %d", tc.wantValid, n)
+				}
+				if kr.Find(KeyFingerprint(newPubKey)) < 0 {
+					t.Errorf("Find: successor was not added")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("ApplyTransition: expected error containing %q, got %v", tc.wantErr, err)
+			}
+			if kr.Find(KeyFingerprint(newPubKey)) >= 0 {
+				t.Errorf("Find: successor was added despite the error")
+			}
+		})
+	}
+
+	t.Run("one-successor", func(t *testing.T) {
+		var kr Keyring
+		kr.Add(TrustedKey{Name: "old", PublicKey: oldPubKey})
+		first := newTestTransition(t, oldPrivKey, newPubKey, now.Add(-2*time.Hour))
+		if err := kr.ApplyTransition(first, now); err != nil {
+			t.Fatalf("ApplyTransition: unexpected error: %v", err)
+		}
+		if err := kr.ApplyTransition(first, now); err != nil {
+			t.Fatalf("ApplyTransition: applying the same statement twice: unexpected error: %v", err)
+		}
+		second := newTestTransition(t, oldPrivKey, otherPubKey, now.Add(-time.Hour))
+		if err := kr.ApplyTransition(second, now); err == nil || !strings.Contains(err.Error(), "already been succeeded") {
+			t.Fatalf("ApplyTransition: expected error for a second successor, got %v", err)
+		}
+		if kr.Find(KeyFingerprint(otherPubKey)) >= 0 {
+			t.Errorf("Find: second successor was added")
+		}
+	})
+}
+
+// TestLoadKeyringAfterExpiry loads a keyring directory whose original key
+// has expired since it signed a transition to its successor.  The successor
+// must still be trusted, and a transition that the expired key signed
+// afterward must be skipped without making the keyring unusable.
+func TestLoadKeyringAfterExpiry(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	expiry := now.AddDate(0, -1, 0)
+	oldPubKey, oldPrivKey := newTestKey(t)
+	newPubKey, _ := newTestKey(t)
+	roguePubKey, _ := newTestKey(t)
+
+	dir := t.TempDir()
+	keyringYAML := fmt.Sprintf("keys:\n  - name: old\n    public_key: %q\n    not_after: %s\n", base64.StdEncoding.EncodeToString(oldPubKey), expiry.Format(time.RFC3339))
+	writeTestFile(t, filepath.Join(dir, "keyring.yaml"), []byte(keyringYAML))
+	for name, tr := range map[string]SignedKeyTransition{
+		"new.transition.json":   newTestTransition(t, oldPrivKey, newPubKey, expiry.AddDate(0, -1, 0)),
+		"rogue.transition.json": newTestTransition(t, oldPrivKey, roguePubKey, now.Add(-time.Hour)),
+	} {
+		raw, err := tr.Encode()
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		writeTestFile(t, filepath.Join(dir, name), raw)
+	}
+
+	kr, err := LoadKeyring(dir, now)
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	valid := kr.ValidKeys(now)
+	if len(valid) != 1 || valid[0].Fingerprint() != KeyFingerprint(newPubKey) {
+		t.Fatalf("ValidKeys: expected only the successor, got %s", describeKeys(valid))
+	}
+	if kr.Find(KeyFingerprint(roguePubKey)) >= 0 {
+		t.Errorf("Find: key introduced after expiry was added")
+	}
+}
+
+// TestApplyTransitionBlocklistSignature checks that a blocklist signature
+// over the exact bytes of a key transition statement, as sign would make
+// for a data file with those contents, does not make the statement valid.
+func TestApplyTransitionBlocklistSignature(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	oldPubKey, oldPrivKey := newTestKey(t)
+	roguePubKey, _ := newTestKey(t)
+
+	genuine := newTestTransition(t, oldPrivKey, roguePubKey, now.Add(-time.Hour))
+	checksum, err := genuine.Checksum()
+	if err != nil {
+		t.Fatalf("Checksum: %v", err)
+	}
+
+	reused := NewSignatureEntry(oldPrivKey, SignaturePurposeBlocklist, checksum, false, now.Add(-time.Hour))
+	relabeled := reused
+	relabeled.Purpose = SignaturePurposeKeyTransition
+
+	for name, sig := range map[string]SignatureEntry{"reused": reused, "relabeled": relabeled} {
+		t.Run(name, func(t *testing.T) {
+			forged := genuine
+			forged.Signature = sig
+			raw, err := forged.Encode()
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			forged, err = DecodeKeyTransition(raw)
+			if err != nil {
+				t.Fatalf("DecodeKeyTransition: %v", err)
+			}
+
+			var kr Keyring
+			kr.Add(TrustedKey{Name: "old", PublicKey: oldPubKey})
+			err = kr.ApplyTransition(forged, now)
+			if err == nil {
+				t.Fatalf("ApplyTransition: accepted a transition signed for %q", SignaturePurposeBlocklist)
+			}
+			if len(kr.Keys) != 1 {
+				t.Errorf("ApplyTransition: keyring grew to %d keys", len(kr.Keys))
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Limits caps how many changes a single apply may make before a human has
+// to confirm them with --force.  Counts are absolute; percentages are
+// relative to the number of RapidBlock-managed blocks that already exist.
+// A negative value means "no limit".  Percentage limits are not enforced
+// while there are no managed blocks yet, since every insert on a first run
+// would otherwise count as infinitely many percent.
+type Limits struct {
+	MaxInserts       int     `yaml:"max_inserts"`
+	MaxUpdates       int     `yaml:"max_updates"`
+	MaxDeletes       int     `yaml:"max_deletes"`
+	MaxInsertPercent float64 `yaml:"max_insert_percent"`
+	MaxUpdatePercent float64 `yaml:"max_update_percent"`
+	MaxDeletePercent float64 `yaml:"max_delete_percent"`
+}
+
+func NoLimits() Limits {
+	return Limits{
+		MaxInserts:       -1,
+		MaxUpdates:       -1,
+		MaxDeletes:       -1,
+		MaxInsertPercent: -1,
+		MaxUpdatePercent: -1,
+		MaxDeletePercent: -1,
+	}
+}
+
+type LimitError struct {
+	Violations []string
+}
+
+func (err *LimitError) Error() string {
+	return "plan exceeds safety limits: " + strings.Join(err.Violations, "; ")
+}
+
+func (limits Limits) Check(totals PlanTotals, managedCount int) error {
+	var violations []string
+	check := func(what string, count int, maxCount int, maxPercent float64) {
+		if maxCount >= 0 && count > maxCount {
+			violations = append(violations, fmt.Sprintf("%d %s(s) exceeds limit of %d", count, what, maxCount))
+		}
+		if maxPercent >= 0 && managedCount > 0 {
+			percent := 100.0 * float64(count) / float64(managedCount)
+			if percent > maxPercent {
+				violations = append(violations, fmt.Sprintf("%d %s(s) is %.1f%% of %d managed block(s), exceeds limit of %.1f%%", count, what, percent, managedCount, maxPercent))
+			}
+		}
+	}
+	check("insert", totals.Inserted, limits.MaxInserts, limits.MaxInsertPercent)
+	check("update", totals.Updated, limits.MaxUpdates, limits.MaxUpdatePercent)
+	check("delete", totals.Deleted, limits.MaxDeletes, limits.MaxDeletePercent)
+	if len(violations) > 0 {
+		return &LimitError{Violations: violations}
+	}
+	return nil
+}
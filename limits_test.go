@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLimitsCheck(t *testing.T) {
+	limits := NoLimits()
+	limits.MaxInserts = 10
+	limits.MaxDeletes = 2
+	limits.MaxDeletePercent = 10
+	limits.MaxUpdatePercent = 50
+
+	type testCase struct {
+		name         string
+		limits       Limits
+		totals       PlanTotals
+		managedCount int
+		want         []string
+	}
+	testCases := []testCase{
+		{
+			name:         "within-limits",
+			limits:       limits,
+			totals:       PlanTotals{Inserted: 10, Updated: 10, Deleted: 2},
+			managedCount: 20,
+		},
+		{
+			name:         "no-limits",
+			limits:       NoLimits(),
+			totals:       PlanTotals{Inserted: 1000, Updated: 1000, Deleted: 1000},
+			managedCount: 1,
+		},
+		{
+			name:   "first-run-skips-percentages",
+			limits: limits,
+			totals: PlanTotals{Inserted: 5, Updated: 5, Deleted: 1},
+		},
+		{
+			name:         "count",
+			limits:       limits,
+			totals:       PlanTotals{Inserted: 11, Deleted: 3},
+			managedCount: 100,
+			want: []string{
+				"11 insert(s) exceeds limit of 10",
+				"3 delete(s) exceeds limit of 2",
+			},
+		},
+		{
+			name:         "percent",
+			limits:       limits,
+			totals:       PlanTotals{Updated: 13, Deleted: 2},
+			managedCount: 19,
+			want: []string{
+				"13 update(s) is 68.4% of 19 managed block(s), exceeds limit of 50.0%",
+				"2 delete(s) is 10.5% of 19 managed block(s), exceeds limit of 10.0%",
+			},
+		},
+		{
+			name:         "zero-means-none",
+			limits:       Limits{MaxInsertPercent: -1, MaxUpdatePercent: -1, MaxDeletePercent: -1},
+			totals:       PlanTotals{Updated: 1},
+			managedCount: 20,
+			want:         []string{"1 update(s) exceeds limit of 0"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.limits.Check(tc.totals, tc.managedCount)
+			if tc.want == nil {
+				if err != nil {
+					t.Errorf("Check: unexpected error: %v", err)
+				}
+				return
+			}
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("Check: expected a *LimitError, got %v", err)
+			}
+			if !reflect.DeepEqual(limitErr.Violations, tc.want) {
+				t.Errorf("Check:\n\tgot  %q\n\twant %q", limitErr.Violations, tc.want)
+			}
+		})
+	}
+}
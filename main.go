@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	getopt "github.com/pborman/getopt/v2"
 )
@@ -21,48 +22,176 @@ const (
 	Sign        = "sign"
 	Verify      = "verify"
 	Apply       = "apply"
+	Fetch       = "fetch"
+	Daemon      = "daemon"
+	CheckConfig = "check-config"
+	ImportDB    = "import-db"
+	Uninstall   = "uninstall"
+	Impact      = "impact"
 
-	AllModes             = PrepareData + ", " + ExportCSV + "," + GenerateKey + ", " + Sign + ", " + Verify + ", " + Apply
-	AllExceptGenerateKey = PrepareData + ", " + ExportCSV + "," + Sign + ", " + Verify + ", " + Apply
-	GenerateSignVerify   = GenerateKey + ", " + Sign + ", " + Verify
-	GenerateSign         = GenerateKey + ", " + Sign
-	SignVerify           = Sign + ", " + Verify
+	// TransitionKey writes a key transition statement, signed by the
+	// current key, that introduces its successor.
+	TransitionKey = "transition-key"
+
+	// ChangePassphrase re-encrypts a private key under a new passphrase.
+	ChangePassphrase = "change-passphrase"
+
+	AllModes              = PrepareData + ", " + ExportCSV + "," + GenerateKey + ", " + Sign + ", " + Verify + ", " + Fetch + ", " + Apply + ", " + Daemon + ", " + CheckConfig + ", " + ImportDB + ", " + Uninstall + ", " + Impact + ", " + TransitionKey + ", " + ChangePassphrase
+	AllExceptGenerateKey  = PrepareData + ", " + ExportCSV + "," + Sign + ", " + Verify + ", " + Fetch + ", " + Apply + ", " + ImportDB + ", " + Impact
+	KeyModes              = GenerateKey + ", " + Sign + ", " + TransitionKey + ", " + Verify + ", " + Fetch
+	SigningModes          = GenerateKey + ", " + Sign + ", " + TransitionKey + ", " + ChangePassphrase
+	TransitionVerifyFetch = TransitionKey + ", " + Verify + ", " + Fetch
+	SignVerifyFetch       = Sign + ", " + Verify + ", " + Fetch
+	VerifyFetch           = Verify + ", " + Fetch
+	ApplyUninstall        = Apply + ", " + Uninstall
+	ApplyImpact           = Apply + ", " + Impact
+	ApplyImpactUninstall  = Apply + ", " + Impact + ", " + Uninstall
+	InstanceModes         = Apply + ", " + Impact + ", " + ImportDB + ", " + Uninstall
 
 	Mastodon3x = "mastodon-3.x"
 	Mastodon4x = "mastodon-4.x"
 
-	AllSoftware = Mastodon4x + ", " + Mastodon3x
+	// MastodonAuto inspects the database schema to tell which of the
+	// supported Mastodon versions is installed.  It is the default.
+	MastodonAuto = "mastodon-auto"
+
+	// MastodonAPI applies through the admin REST API of Mastodon 4.0 or
+	// later, rather than through the database.
+	MastodonAPI = "mastodon-api"
+
+	Pleroma    = "pleroma"
+	Akkoma     = "akkoma"
+	GoToSocial = "gotosocial"
+	Misskey    = "misskey"
+	Firefish   = "firefish"
+
+	AllSoftware = MastodonAuto + ", " + Mastodon4x + ", " + Mastodon3x + ", " + MastodonAPI + ", " + Pleroma + ", " + Akkoma + ", " + GoToSocial + ", " + Misskey + ", " + Firefish
+
+	FormatText = "text"
+	FormatJSON = "json"
+
+	AllFormats = FormatText + ", " + FormatJSON
+
+	// ExitLimitExceeded is the exit status used when apply refuses to
+	// commit a plan that exceeds the configured safety limits.
+	ExitLimitExceeded = 3
+
+	// ExitLocked is the exit status used when apply or uninstall gives up
+	// because another run is applying changes to the same database.
+	ExitLocked = 4
 )
 
 var (
-	flagVersion         bool
-	flagText            bool
-	flagMode            string
-	flagSoftware        string
-	flagAccountDataFile string
-	flagSourceID        string
-	flagCsvFile         string
-	flagDataFile        string
-	flagSigFile         string
-	flagPublicKeyFile   string
-	flagPrivateKeyFile  string
-	flagDatabaseURL     string
+	flagVersion           bool
+	flagText              bool
+	flagAppend            bool
+	flagDryRun            bool
+	flagForce             bool
+	flagLocalOnly         bool
+	flagKeepBlocks        bool
+	flagMode              string
+	flagSoftware          string
+	flagAccountDataFile   string
+	flagSourceID          string
+	flagCsvFile           string
+	flagDataFile          string
+	flagSigFile           string
+	flagPublicKeyFile     string
+	flagPrivateKeyFile    string
+	flagNewPublicKeyFile  string
+	flagKeyring           string
+	flagKeyTransitionURL  string
+	flagKeyTransitionFile string
+	flagOverlap           time.Duration
+	flagDatabaseURL       string
+	flagAPIURL            string
+	flagAPITokenFile      string
+	flagActingAccount     string
+	flagSidekiqRedisURL   string
+	flagSidekiqNamespace  string
+	flagSidekiqSpoolDir   string
+	flagPolicyFile        string
+	flagOverrideFile      string
+	flagBlocklistURL      string
+	flagConfigFile        string
+	flagSignatureURL      string
+	flagKeyName           string
+	flagSourceName        string
+	flagInstanceName      string
+	flagFormat            string = FormatText
+	flagShowImpact        bool
+	flagEncrypt           bool
+	flagPassphraseFD      int    = -1
+	flagNewPassphraseFD   int    = -1
+	flagSignatureFormat   string = SigFormatEnvelope
+	flagKeyFormat         string = KeyFormatBase64
+	flagTrustedComment    string
+	flagLockTimeout       time.Duration
+	flagImpactThreshold   int     = 1
+	flagThreshold         int     = 1
+	flagMaxInserts        int     = -1
+	flagMaxUpdates        int     = -1
+	flagMaxDeletes        int     = -1
+	flagMaxInsertPercent  float64 = -1
+	flagMaxUpdatePercent  float64 = -1
+	flagMaxDeletePercent  float64 = -1
 )
 
 func init() {
 	getopt.SetParameters("")
 	getopt.FlagLong(&flagVersion, "version", 'V', "show version information and exit")
-	getopt.FlagLong(&flagText, "text", 't', "["+SignVerify+"] perform newline canonicalization, under the assumption that --data-file is text")
+	getopt.FlagLong(&flagText, "text", 't', "["+SignVerifyFetch+"] perform newline canonicalization, under the assumption that --data-file is text")
+	getopt.FlagLong(&flagDryRun, "dry-run", 'n', "["+ApplyUninstall+"] compute and print the planned changes, but do not commit them")
+	getopt.FlagLong(&flagForce, "force", 0, "["+ApplyUninstall+"] commit the plan even if it exceeds the --max-* safety limits")
+	getopt.FlagLong(&flagLocalOnly, "local-only", 0, "["+ImportDB+"] only export blocks that were not created by RapidBlock")
+	getopt.FlagLong(&flagKeepBlocks, "keep-blocks", 0, "["+Uninstall+"] convert RapidBlock-managed blocks into local blocks instead of deleting them")
 	getopt.FlagLong(&flagMode, "mode", 'm', "select mode of operation: "+AllModes)
-	getopt.FlagLong(&flagSoftware, "software", 'x', "["+Apply+"] select which server software is in use: "+AllSoftware)
+	getopt.FlagLong(&flagSoftware, "software", 'x', "["+InstanceModes+"] select which server software is in use (default "+MastodonAuto+"): "+AllSoftware)
 	getopt.FlagLong(&flagAccountDataFile, "account-data-file", 'A', "["+PrepareData+"] path to the groups.io cookies and database column mappings")
 	getopt.FlagLong(&flagSourceID, "source-id", 'S', "["+PrepareData+"] ID of the Google Sheet spreadsheet to pull data from")
 	getopt.FlagLong(&flagCsvFile, "csv-file", 'c', "["+ExportCSV+"] path to the CSV file to create")
-	getopt.FlagLong(&flagDataFile, "data-file", 'd', "["+AllExceptGenerateKey+"] path to the JSON file to create, export from, sign, verify, or apply")
-	getopt.FlagLong(&flagSigFile, "signature-file", 's', "["+SignVerify+"] path to the base-64 Ed25519 signature file to create or verify")
-	getopt.FlagLong(&flagPublicKeyFile, "public-key-file", 'p', "["+GenerateSignVerify+"] path to the base-64 Ed25519 public key file to verify with")
-	getopt.FlagLong(&flagPrivateKeyFile, "private-key-file", 'k', "["+GenerateSign+"] path to the base-64 Ed25519 private key file to sign with")
-	getopt.FlagLong(&flagDatabaseURL, "database-url", 'D', "["+Apply+"] PostgreSQL database URL to connect to")
+	getopt.FlagLong(&flagDataFile, "data-file", 'd', "["+AllExceptGenerateKey+"] path to the JSON file to create, export from, sign, verify, fetch into, or apply")
+	getopt.FlagLong(&flagSignatureFormat, "signature-format", 0, "["+Sign+"] select signature file format: "+AllSigFormats)
+	getopt.FlagLong(&flagAppend, "append", 0, "["+Sign+"] add the signature to an existing "+SigFormatEnvelope+" signature file rather than replacing it, dropping any signatures of other data")
+	getopt.FlagLong(&flagTrustedComment, "trusted-comment", 0, "["+Sign+"] trusted comment to sign along with a "+SigFormatMinisign+" signature (default: timestamp and file name)")
+	getopt.FlagLong(&flagSigFile, "signature-file", 's', "["+SignVerifyFetch+"] path to the Ed25519 signature file to create, verify, or fetch into")
+	getopt.FlagLong(&flagPublicKeyFile, "public-key-file", 'p', "["+KeyModes+"] path to the base-64 or minisign Ed25519 public key file to verify with")
+	getopt.FlagLong(&flagPrivateKeyFile, "private-key-file", 'k', "["+SigningModes+"] path to the base-64, encrypted, or minisign Ed25519 private key file to sign with")
+	getopt.FlagLong(&flagNewPublicKeyFile, "new-public-key-file", 0, "["+TransitionKey+"] path to the base-64 Ed25519 public key file of the key that succeeds --public-key-file")
+	getopt.FlagLong(&flagOverlap, "overlap", 0, "["+TransitionKey+"] how long subscribers should keep trusting the current key after the transition (default: until it expires in their keyring)")
+	getopt.FlagLong(&flagKeyring, "keyring", 0, "["+VerifyFetch+"] path to a directory or YAML file of trusted public keys with validity windows, which is used along with --public-key-file")
+	getopt.FlagLong(&flagThreshold, "threshold", 0, "["+VerifyFetch+"] require valid signatures by at least this many distinct keys in the keyring")
+	getopt.FlagLong(&flagKeyTransitionFile, "key-transition-file", 0, "["+TransitionVerifyFetch+"] path to the key transition statement to create, to trust, or to fetch into")
+	getopt.FlagLong(&flagEncrypt, "encrypt", 0, "["+GenerateKey+"] encrypt the private key with a passphrase")
+	getopt.FlagLong(&flagKeyFormat, "key-format", 0, "["+GenerateKey+"] select key file format: "+AllKeyFormats)
+	getopt.FlagLong(&flagPassphraseFD, "passphrase-fd", 0, "["+SigningModes+"] read the private key's passphrase from this file descriptor instead of $"+PassphraseEnv+" or the terminal")
+	getopt.FlagLong(&flagNewPassphraseFD, "new-passphrase-fd", 0, "["+ChangePassphrase+"] read the new passphrase from this file descriptor instead of $"+NewPassphraseEnv+" or the terminal")
+	getopt.FlagLong(&flagConfigFile, "config-file", 'C', "path to the YAML configuration file declaring keys, sources, and instances; other flags override its values")
+	getopt.FlagLong(&flagKeyName, "key", 0, "["+SigningModes+"] name of the key in --config-file to use")
+	getopt.FlagLong(&flagSourceName, "source", 0, "["+VerifyFetch+"] name of the source in --config-file to use (default: all sources for "+Fetch+")")
+	getopt.FlagLong(&flagInstanceName, "instance", 'I', "["+InstanceModes+"] name of the instance in --config-file to use (default: all instances)")
+	getopt.FlagLong(&flagBlocklistURL, "blocklist-url", 'u', "["+Fetch+"] URL of the published blocklist to download")
+	getopt.FlagLong(&flagSignatureURL, "signature-url", 'U', "["+Fetch+"] URL of the published blocklist's signature to download")
+	getopt.FlagLong(&flagKeyTransitionURL, "key-transition-url", 0, "["+Fetch+"] URL of the publisher's key transition statement to download, if any")
+	getopt.FlagLong(&flagDatabaseURL, "database-url", 'D', "["+InstanceModes+"] PostgreSQL database URL to connect to")
+	getopt.FlagLong(&flagAPIURL, "api-url", 0, "["+Apply+"] base URL of the Mastodon instance, for --software="+MastodonAPI)
+	getopt.FlagLong(&flagAPITokenFile, "api-token-file", 0, "["+Apply+"] path to the file holding an admin OAuth bearer token, for --software="+MastodonAPI)
+	getopt.FlagLong(&flagActingAccount, "acting-account", 0, "["+ApplyUninstall+"] local Mastodon account, by username or as id:123, that the admin action log credits with each change (default: the internal server account, with a warning)")
+	getopt.FlagLong(&flagSidekiqRedisURL, "sidekiq-redis-url", 0, "["+Apply+"] Redis URL of Mastodon's Sidekiq, to enforce new and changed blocks, and lift removed ones, as Mastodon's admin UI does")
+	getopt.FlagLong(&flagSidekiqNamespace, "sidekiq-namespace", 0, "["+Apply+"] Redis key namespace of Mastodon's Sidekiq, if REDIS_NAMESPACE is set")
+	getopt.FlagLong(&flagSidekiqSpoolDir, "sidekiq-spool-dir", 0, "["+Apply+"] path to the directory that holds Sidekiq jobs until they have been pushed, so that a later run can push them if Redis cannot be reached (default for a configured instance: "+DefaultCacheRoot+"/sidekiq/NAME)")
+	getopt.FlagLong(&flagPolicyFile, "policy-file", 'P', "["+ApplyImpact+"] path to the YAML file mapping block tags to severity and other options")
+	getopt.FlagLong(&flagOverrideFile, "override-file", 'O', "["+ApplyImpact+"] path to the YAML file listing local overrides that take precedence over the blocklist")
+	getopt.FlagLong(&flagLockTimeout, "lock-timeout", 0, "["+ApplyUninstall+"] how long to wait for another run against the same Mastodon database to finish, before exiting with status 4 (default: do not wait)")
+	getopt.FlagLong(&flagShowImpact, "show-impact", 0, "["+Apply+"] add to the plan how many accounts and local users each new or escalated block affects, for Mastodon")
+	getopt.FlagLong(&flagImpactThreshold, "impact-threshold", 0, "["+Impact+"] only list domains that would cut off at least this many local users")
+	getopt.FlagLong(&flagMaxInserts, "max-inserts", 0, "["+ApplyUninstall+"] refuse to add more than this many blocks without --force (negative for no limit)")
+	getopt.FlagLong(&flagMaxUpdates, "max-updates", 0, "["+ApplyUninstall+"] refuse to modify more than this many blocks without --force (negative for no limit)")
+	getopt.FlagLong(&flagMaxDeletes, "max-deletes", 0, "["+ApplyUninstall+"] refuse to delete more than this many blocks without --force (negative for no limit)")
+	getopt.FlagLong(&flagMaxInsertPercent, "max-insert-percent", 0, "["+ApplyUninstall+"] like --max-inserts, as a percentage of existing RapidBlock-managed blocks")
+	getopt.FlagLong(&flagMaxUpdatePercent, "max-update-percent", 0, "["+ApplyUninstall+"] like --max-updates, as a percentage of existing RapidBlock-managed blocks")
+	getopt.FlagLong(&flagMaxDeletePercent, "max-delete-percent", 0, "["+ApplyUninstall+"] like --max-deletes, as a percentage of existing RapidBlock-managed blocks")
+	getopt.FlagLong(&flagFormat, "format", 'f', "["+ApplyImpactUninstall+"] select output format: "+AllFormats)
 }
 
 func main() {
@@ -76,6 +205,11 @@ func main() {
 		return
 	}
 
+	switch flagMode {
+	case GenerateKey, Sign, TransitionKey, ChangePassphrase, Verify, Fetch, Apply, ImportDB, Uninstall, Impact:
+		loadConfigFromFlags()
+	}
+
 	switch flagMode {
 	case PrepareData:
 		cmdPrepareData()
@@ -87,8 +221,24 @@ func main() {
 		cmdSign()
 	case Verify:
 		cmdVerify()
+	case Fetch:
+		cmdFetch()
 	case Apply:
 		cmdApply()
+	case Daemon:
+		cmdDaemon()
+	case CheckConfig:
+		cmdCheckConfig()
+	case ImportDB:
+		cmdImportDB()
+	case Uninstall:
+		cmdUninstall()
+	case Impact:
+		cmdImpact()
+	case TransitionKey:
+		cmdTransitionKey()
+	case ChangePassphrase:
+		cmdChangePassphrase()
 	default:
 		fmt.Fprintf(os.Stderr, "fatal: unknown value %q for -m / --mode flag, expected one of: %s\n", flagMode, AllModes)
 		os.Exit(1)
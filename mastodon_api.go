@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Mastodon admin API lets RapidBlock manage domain blocks without
+// direct database access.  It needs an OAuth token for an account with the
+// "admin:read:domain_blocks" and "admin:write:domain_blocks" scopes.
+// Unlike the SQL backends, the changes are not applied in a single
+// transaction: if a request fails partway through, the blocks changed so
+// far stay changed, and the next run picks up where this one left off.
+const (
+	mastodonAPIDomainBlocksPath = "/api/v1/admin/domain_blocks"
+	mastodonAPIPageSize         = 200
+	mastodonAPIMaxAttempts      = 5
+	mastodonAPIMaxResponseSize  = 16 << 20 // 16 MiB
+)
+
+type MastodonAPIClient struct {
+	BaseURL *url.URL
+	Token   string
+	Client  *http.Client
+
+	rateLimitReset time.Time
+}
+
+type mastodonAPIDomainBlock struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	mastodonAPIDomainBlockParams
+}
+
+type mastodonAPIDomainBlockParams struct {
+	Domain         string   `json:"domain"`
+	Severity       Severity `json:"severity"`
+	RejectMedia    bool     `json:"reject_media"`
+	RejectReports  bool     `json:"reject_reports"`
+	PrivateComment *string  `json:"private_comment"`
+	PublicComment  *string  `json:"public_comment"`
+	Obfuscate      bool     `json:"obfuscate"`
+}
+
+func NewMastodonAPIClient(baseURL string, token string, client *http.Client) (*MastodonAPIClient, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse API URL %q: %w", baseURL, err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("API URL %q: expected an http or https URL", baseURL)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	return &MastodonAPIClient{BaseURL: u, Token: token, Client: client}, nil
+}
+
+func ApplyMastodonAPI(ctx context.Context, file BlockFile, opts ApplyOptions) (Plan, error) {
+	api, err := NewMastodonAPIClient(opts.APIURL, opts.APIToken, opts.HTTPClient)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	existingBlocks, err := api.ListDomainBlocks(ctx)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	plan := PlanMastodon(file, opts.Policy, opts.Overrides, existingBlocks, time.Now().UTC())
+	if opts.DryRun {
+		return plan, nil
+	}
+
+	if !opts.Force {
+		err = opts.Limits.Check(plan.Totals(), CountManagedMastodonBlocks(existingBlocks))
+		if err != nil {
+			return plan, err
+		}
+	}
+
+	for _, item := range plan.Items {
+		switch item.Action {
+		case InsertAction:
+			err = api.CreateDomainBlock(ctx, *item.New)
+		case UpdateAction:
+			err = api.UpdateDomainBlock(ctx, *item.New)
+		case DeleteAction:
+			err = api.DeleteDomainBlock(ctx, *item.Old)
+		}
+		if err != nil {
+			return plan, err
+		}
+	}
+	return plan, nil
+}
+
+// ListDomainBlocks fetches every domain block, following the Link headers
+// from page to page.
+func (api *MastodonAPIClient) ListDomainBlocks(ctx context.Context) (map[string]MastodonDomainBlock, error) {
+	next := api.endpoint(mastodonAPIDomainBlocksPath)
+	next.RawQuery = url.Values{"limit": {strconv.Itoa(mastodonAPIPageSize)}}.Encode()
+
+	out := make(map[string]MastodonDomainBlock, 1024)
+	for next != nil {
+		current := next
+		next = nil
+
+		var page []mastodonAPIDomainBlock
+		resp, err := api.do(ctx, http.MethodGet, current, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page {
+			block, err := item.toMastodonDomainBlock()
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", http.MethodGet, current, err)
+			}
+			out[block.Domain] = block
+		}
+
+		if link := parseLinkHeader(resp.Header.Values("link"))["next"]; link != "" && len(page) > 0 {
+			next, err = current.Parse(link)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: failed to parse Link header %q: %w", http.MethodGet, current, link, err)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (api *MastodonAPIClient) CreateDomainBlock(ctx context.Context, block MastodonDomainBlock) error {
+	u := api.endpoint(mastodonAPIDomainBlocksPath)
+	_, err := api.do(ctx, http.MethodPost, u, mastodonAPIDomainBlockFrom(block), nil)
+	return err
+}
+
+func (api *MastodonAPIClient) UpdateDomainBlock(ctx context.Context, block MastodonDomainBlock) error {
+	u := api.endpoint(mastodonAPIDomainBlocksPath + "/" + strconv.FormatUint(block.ID, 10))
+	_, err := api.do(ctx, http.MethodPut, u, mastodonAPIDomainBlockFrom(block), nil)
+	return err
+}
+
+func (api *MastodonAPIClient) DeleteDomainBlock(ctx context.Context, block MastodonDomainBlock) error {
+	u := api.endpoint(mastodonAPIDomainBlocksPath + "/" + strconv.FormatUint(block.ID, 10))
+	_, err := api.do(ctx, http.MethodDelete, u, nil, nil)
+	return err
+}
+
+func (api *MastodonAPIClient) endpoint(path string) *url.URL {
+	u := *api.BaseURL
+	u.Path += path
+	u.RawQuery = ""
+	return &u
+}
+
+// do sends one API request, waiting first if the previous response said
+// that the rate limit was exhausted, and retrying if this one is rejected
+// for exceeding it.
+func (api *MastodonAPIClient) do(ctx context.Context, method string, u *url.URL, in any, out any) (*http.Response, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: failed to encode JSON request: %w", method, u, err)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := sleepUntil(ctx, api.rateLimitReset)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: failed to create request: %w", method, u, err)
+		}
+		req.Header.Set("authorization", "Bearer "+api.Token)
+		req.Header.Set("accept", "application/json")
+		req.Header.Set("user-agent", fmt.Sprintf(UserAgentFormat, Version))
+		if in != nil {
+			req.Header.Set("content-type", "application/json")
+		}
+
+		resp, err := api.Client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: request failed: %w", method, u, err)
+		}
+
+		rawBody, err := io.ReadAll(io.LimitReader(resp.Body, mastodonAPIMaxResponseSize+1))
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: I/O error in response body: %w", method, u, err)
+		}
+		if len(rawBody) > mastodonAPIMaxResponseSize {
+			return nil, fmt.Errorf("%s %s: response too large: more than %d bytes", method, u, mastodonAPIMaxResponseSize)
+		}
+
+		api.rateLimitReset = time.Time{}
+		reset := rateLimitResetTime(resp.Header)
+		if resp.Header.Get("x-ratelimit-remaining") == "0" {
+			api.rateLimitReset = reset
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests && attempt < mastodonAPIMaxAttempts:
+			if reset.IsZero() {
+				reset = time.Now().Add(time.Duration(attempt) * time.Minute)
+			}
+			api.rateLimitReset = reset
+			fmt.Fprintf(os.Stderr, "info: %s %s: rate limited, waiting until %s\n", method, u, reset.Format(time.RFC3339))
+			continue
+
+		case resp.StatusCode < 200 || resp.StatusCode >= 300:
+			return nil, fmt.Errorf("%s %s: unexpected status %03d: %s", method, u, resp.StatusCode, mastodonAPIErrorMessage(rawBody))
+		}
+
+		if out != nil {
+			err = json.Unmarshal(rawBody, out)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: failed to decode JSON response: %w", method, u, err)
+			}
+		}
+		return resp, nil
+	}
+}
+
+func (item mastodonAPIDomainBlock) toMastodonDomainBlock() (MastodonDomainBlock, error) {
+	id, err := strconv.ParseUint(item.ID, 10, 64)
+	if err != nil {
+		return MastodonDomainBlock{}, fmt.Errorf("domain block %q: invalid ID %q: %w", item.Domain, item.ID, err)
+	}
+
+	var block MastodonDomainBlock
+	block.ID = id
+	block.Domain = item.Domain
+	if item.PrivateComment != nil {
+		block.PrivateComment = *item.PrivateComment
+	}
+	if item.PublicComment != nil {
+		block.PublicComment = *item.PublicComment
+	}
+	block.CreatedAt = item.CreatedAt
+	// The API does not say when a block was last changed, so UpdatedAt is
+	// left zero rather than guessed.
+	block.Severity = item.Severity
+	block.RejectMedia = item.RejectMedia
+	block.RejectReports = item.RejectReports
+	block.Obfuscate = item.Obfuscate
+	return block, nil
+}
+
+func mastodonAPIDomainBlockFrom(block MastodonDomainBlock) mastodonAPIDomainBlockParams {
+	privateComment := block.PrivateComment
+	publicComment := block.PublicComment
+	return mastodonAPIDomainBlockParams{
+		Domain:         block.Domain,
+		Severity:       block.Severity,
+		RejectMedia:    block.RejectMedia,
+		RejectReports:  block.RejectReports,
+		PrivateComment: &privateComment,
+		PublicComment:  &publicComment,
+		Obfuscate:      block.Obfuscate,
+	}
+}
+
+func mastodonAPIErrorMessage(rawBody []byte) string {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(rawBody, &body) == nil && body.Error != "" {
+		return body.Error
+	}
+	return reSpace.ReplaceAllString(strings.TrimSpace(string(rawBody)), " ")
+}
+
+// rateLimitResetTime returns the time given by the X-RateLimit-Reset header,
+// or the zero time if there is none.
+func rateLimitResetTime(h http.Header) time.Time {
+	str := h.Get("x-ratelimit-reset")
+	if str == "" {
+		return time.Time{}
+	}
+	if t, err := time.Parse(time.RFC3339Nano, str); err == nil {
+		return t
+	}
+	if secs, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return time.Unix(secs, 0)
+	}
+	return time.Time{}
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// parseLinkHeader extracts the URL for each rel from RFC 8288 Link headers,
+// such as `<https://example.com/?max_id=1>; rel="next"`.
+func parseLinkHeader(values []string) map[string]string {
+	out := make(map[string]string, 2)
+	for _, value := range values {
+		for _, link := range strings.Split(value, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			target = target[1 : len(target)-1]
+			for _, param := range parts[1:] {
+				key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+				if !found || !strings.EqualFold(key, "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(value, `"`)) {
+					out[strings.ToLower(rel)] = target
+				}
+			}
+		}
+	}
+	return out
+}
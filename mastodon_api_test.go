@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMastodonAPI serves the admin domain block endpoints from an in-memory
+// list, two blocks per page.  If rateLimited is set, the first request is
+// rejected with 429 and an X-RateLimit-Reset that far in the future.
+type fakeMastodonAPI struct {
+	t           *testing.T
+	rateLimited time.Duration
+
+	mu       sync.Mutex
+	blocks   []mastodonAPIDomainBlock
+	nextID   int
+	requests []string
+	limited  time.Time
+	retried  time.Time
+}
+
+func newFakeMastodonAPI(t *testing.T, blocks []mastodonAPIDomainBlock) (*fakeMastodonAPI, *httptest.Server) {
+	t.Helper()
+	api := &fakeMastodonAPI{t: t, blocks: blocks, nextID: 100}
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	return api, srv
+}
+
+func (api *fakeMastodonAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if r.Header.Get("authorization") != "Bearer sekrit" {
+		http.Error(w, `{"error":"The access token is invalid"}`, http.StatusUnauthorized)
+		return
+	}
+	if api.rateLimited > 0 && api.limited.IsZero() {
+		api.limited = time.Now()
+		w.Header().Set("x-ratelimit-remaining", "0")
+		w.Header().Set("x-ratelimit-reset", api.limited.Add(api.rateLimited).UTC().Format(time.RFC3339Nano))
+		http.Error(w, `{"error":"Too many requests"}`, http.StatusTooManyRequests)
+		return
+	}
+	if !api.limited.IsZero() && api.retried.IsZero() {
+		api.retried = time.Now()
+	}
+
+	api.requests = append(api.requests, r.Method+" "+r.URL.Path)
+	id := strings.TrimPrefix(r.URL.Path, mastodonAPIDomainBlocksPath+"/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == mastodonAPIDomainBlocksPath:
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		start, end := 2*page, 2*page+2
+		if end >= len(api.blocks) {
+			end = len(api.blocks)
+		} else {
+			w.Header().Set("link", fmt.Sprintf(`<%s?page=%d>; rel="next", <%s?page=0>; rel="prev"`, mastodonAPIDomainBlocksPath, page+1, mastodonAPIDomainBlocksPath))
+		}
+		if start > end {
+			start = end
+		}
+		_ = json.NewEncoder(w).Encode(api.blocks[start:end])
+
+	case r.Method == http.MethodPost && r.URL.Path == mastodonAPIDomainBlocksPath:
+		var item mastodonAPIDomainBlock
+		api.decode(r, &item.mastodonAPIDomainBlockParams)
+		item.ID = strconv.Itoa(api.nextID)
+		item.CreatedAt = time.Now().UTC()
+		api.nextID++
+		api.blocks = append(api.blocks, item)
+		_ = json.NewEncoder(w).Encode(item)
+
+	case r.Method == http.MethodPut:
+		index := api.find(id)
+		if index < 0 {
+			http.Error(w, `{"error":"Record not found"}`, http.StatusNotFound)
+			return
+		}
+		api.decode(r, &api.blocks[index].mastodonAPIDomainBlockParams)
+		_ = json.NewEncoder(w).Encode(api.blocks[index])
+
+	case r.Method == http.MethodDelete:
+		index := api.find(id)
+		if index < 0 {
+			http.Error(w, `{"error":"Record not found"}`, http.StatusNotFound)
+			return
+		}
+		api.blocks = append(api.blocks[:index], api.blocks[index+1:]...)
+		_, _ = w.Write([]byte("{}"))
+
+	default:
+		http.Error(w, `{"error":"Not found"}`, http.StatusNotFound)
+	}
+}
+
+func (api *fakeMastodonAPI) decode(r *http.Request, out *mastodonAPIDomainBlockParams) {
+	err := json.NewDecoder(r.Body).Decode(out)
+	if err != nil {
+		api.t.Errorf("%s %s: failed to decode request: %v", r.Method, r.URL.Path, err)
+	}
+}
+
+func (api *fakeMastodonAPI) find(id string) int {
+	for index, item := range api.blocks {
+		if item.ID == id {
+			return index
+		}
+	}
+	return -1
+}
+
+func newTestAPIDomainBlock(id int, domain string, privateComment string, publicComment string) mastodonAPIDomainBlock {
+	var item mastodonAPIDomainBlock
+	item.ID = strconv.Itoa(id)
+	item.CreatedAt = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	item.Domain = domain
+	item.Severity = SeveritySuspend
+	item.PrivateComment = &privateComment
+	item.PublicComment = &publicComment
+	return item
+}
+
+func TestApplyMastodonAPI(t *testing.T) {
+	api, srv := newFakeMastodonAPI(t, []mastodonAPIDomainBlock{
+		newTestAPIDomainBlock(1, "local.example", "blocked by hand", "spam"),
+		newTestAPIDomainBlock(2, "stale.example", WellKnownPrivateComment, "old"),
+		newTestAPIDomainBlock(3, "update.example", WellKnownPrivateComment, "old reason"),
+		newTestAPIDomainBlock(4, "unblocked-by-hand.example", "blocked by hand", "spam"),
+		newTestAPIDomainBlock(5, "untouched.example", WellKnownPrivateComment, "same reason"),
+	})
+	api.rateLimited = 200 * time.Millisecond
+	file := BlockFile{
+		Blocks: map[string]Block{
+			"new.example":               {IsBlocked: true, Reason: "harassment"},
+			"update.example":            {IsBlocked: true, Reason: "new reason"},
+			"untouched.example":         {IsBlocked: true, Reason: "same reason"},
+			"local.example":             {IsBlocked: true, Reason: "upstream reason"},
+			"stale.example":             {IsBlocked: false},
+			"unblocked-by-hand.example": {IsBlocked: false},
+		},
+	}
+	opts := ApplyOptions{
+		Software:   MastodonAPI,
+		APIURL:     srv.URL + "/",
+		APIToken:   "sekrit",
+		HTTPClient: srv.Client(),
+		Policy:     DefaultPolicy(),
+		Force:      true,
+	}
+
+	plan, err := ApplyMastodonAPI(context.Background(), file, opts)
+	if err != nil {
+		t.Fatalf("ApplyMastodonAPI: %v", err)
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if waited := api.retried.Sub(api.limited); waited < 150*time.Millisecond {
+		t.Errorf("expected the client to wait for X-RateLimit-Reset after 429, but it retried after %v", waited)
+	}
+	if totals := plan.Totals(); totals.Inserted != 1 || totals.Updated != 1 || totals.Deleted != 1 {
+		t.Errorf("Totals: expected 1 inserted, 1 updated, 1 deleted, got %+v", totals)
+	}
+
+	requests := api.requests
+	gets := 0
+	var changes []string
+	for _, request := range requests {
+		if strings.HasPrefix(request, http.MethodGet+" ") {
+			gets++
+			continue
+		}
+		changes = append(changes, request)
+	}
+	sort.Strings(changes)
+	expected := []string{
+		http.MethodDelete + " " + mastodonAPIDomainBlocksPath + "/2",
+		http.MethodPost + " " + mastodonAPIDomainBlocksPath,
+		http.MethodPut + " " + mastodonAPIDomainBlocksPath + "/3",
+	}
+	if gets != 3 {
+		t.Errorf("expected 3 pages to be fetched via Link headers, got %d: %q", gets, requests)
+	}
+	if strings.Join(changes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("changes: expected %q, got %q", expected, changes)
+	}
+
+	blocks := make(map[string]mastodonAPIDomainBlock, len(api.blocks))
+	for _, item := range api.blocks {
+		blocks[item.Domain] = item
+	}
+	if item := blocks["local.example"]; *item.PrivateComment != "blocked by hand" || *item.PublicComment != "spam" {
+		t.Errorf("local.example: admin-owned block was modified: %+v", item)
+	}
+	if _, found := blocks["unblocked-by-hand.example"]; !found {
+		t.Errorf("unblocked-by-hand.example: admin-owned block was deleted")
+	}
+	if item, found := blocks["new.example"]; !found || *item.PrivateComment != WellKnownPrivateComment || *item.PublicComment != "harassment" {
+		t.Errorf("new.example: unexpected block: %+v", item)
+	}
+	if item := blocks["update.example"]; *item.PublicComment != "new reason" {
+		t.Errorf("update.example: unexpected block: %+v", item)
+	}
+}
+
+func TestApplyMastodonAPILimits(t *testing.T) {
+	type testCase struct {
+		name    string
+		limits  Limits
+		force   bool
+		wantErr string
+	}
+	testCases := []testCase{
+		{name: "within", limits: Limits{MaxInserts: 1, MaxUpdates: -1, MaxDeletes: 2, MaxInsertPercent: -1, MaxUpdatePercent: -1, MaxDeletePercent: -1}},
+		{name: "count", limits: Limits{MaxInserts: -1, MaxUpdates: -1, MaxDeletes: 1, MaxInsertPercent: -1, MaxUpdatePercent: -1, MaxDeletePercent: -1}, wantErr: "2 delete(s) exceeds limit of 1"},
+		{name: "percent", limits: Limits{MaxInserts: -1, MaxUpdates: -1, MaxDeletes: -1, MaxInsertPercent: -1, MaxUpdatePercent: -1, MaxDeletePercent: 50}, wantErr: "2 delete(s) is 66.7% of 3 managed block(s)"},
+		{name: "force", limits: Limits{MaxInserts: 0, MaxUpdates: 0, MaxDeletes: 0, MaxInsertPercent: 0, MaxUpdatePercent: 0, MaxDeletePercent: 0}, force: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			api, srv := newFakeMastodonAPI(t, []mastodonAPIDomainBlock{
+				newTestAPIDomainBlock(1, "local.example", "blocked by hand", "spam"),
+				newTestAPIDomainBlock(2, "kept.example", WellKnownPrivateComment, "spam"),
+				newTestAPIDomainBlock(3, "stale1.example", WellKnownPrivateComment, "old"),
+				newTestAPIDomainBlock(4, "stale2.example", WellKnownPrivateComment, "old"),
+			})
+			file := BlockFile{Blocks: map[string]Block{
+				"kept.example":   {IsBlocked: true, Reason: "spam"},
+				"new.example":    {IsBlocked: true, Reason: "harassment"},
+				"stale1.example": {IsBlocked: false},
+				"stale2.example": {IsBlocked: false},
+			}}
+			opts := ApplyOptions{
+				Software:   MastodonAPI,
+				APIURL:     srv.URL,
+				APIToken:   "sekrit",
+				HTTPClient: srv.Client(),
+				Policy:     DefaultPolicy(),
+				Limits:     tc.limits,
+				Force:      tc.force,
+			}
+
+			_, err := ApplyMastodonAPI(context.Background(), file, opts)
+			api.mu.Lock()
+			defer api.mu.Unlock()
+			var changes int
+			for _, request := range api.requests {
+				if !strings.HasPrefix(request, http.MethodGet+" ") {
+					changes++
+				}
+			}
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ApplyMastodonAPI: %v", err)
+				}
+				if changes != 3 {
+					t.Errorf("expected 3 changes, got %q", api.requests)
+				}
+				return
+			}
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("ApplyMastodonAPI: expected a LimitError containing %q, got %v", tc.wantErr, err)
+			}
+			if changes != 0 {
+				t.Errorf("expected no changes past the limits, got %q", api.requests)
+			}
+		})
+	}
+}
+
+func TestMastodonAPIResponseTooLarge(t *testing.T) {
+	for _, size := range []int{mastodonAPIMaxResponseSize, mastodonAPIMaxResponseSize + 1} {
+		t.Run(strconv.Itoa(size), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body := bytes.Repeat([]byte(" "), size)
+				copy(body, "[]")
+				_, _ = w.Write(body)
+			}))
+			defer srv.Close()
+
+			api, err := NewMastodonAPIClient(srv.URL, "sekrit", srv.Client())
+			if err != nil {
+				t.Fatalf("NewMastodonAPIClient: %v", err)
+			}
+			_, err = api.ListDomainBlocks(context.Background())
+			switch {
+			case size <= mastodonAPIMaxResponseSize && err != nil:
+				t.Fatalf("ListDomainBlocks: unexpected error: %v", err)
+			case size > mastodonAPIMaxResponseSize && (err == nil || !strings.Contains(err.Error(), "response too large")):
+				t.Fatalf("ListDomainBlocks: expected a response too large error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseLinkHeader(t *testing.T) {
+	links := parseLinkHeader([]string{`<https://example.com/?max_id=1>; rel="next", <https://example.com/?min_id=9>; rel="prev"`})
+	if links["next"] != "https://example.com/?max_id=1" || links["prev"] != "https://example.com/?min_id=9" {
+		t.Errorf("parseLinkHeader: unexpected result %v", links)
+	}
+}
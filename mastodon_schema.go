@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	pgx "github.com/jackc/pgx/v5"
+)
+
+// MastodonNewestKnownSchemaVersion is the newest schema_migrations version
+// that this version of RapidBlock has been checked against.  A database
+// that has run any later migration may have changed in ways we don't know
+// about, so mastodon-auto refuses to work with it; naming the generation
+// explicitly with -x, or with software in the configuration file, skips the
+// check.
+const MastodonNewestKnownSchemaVersion = 20230907150100
+
+// mastodonDomainBlockColumns are the columns of domain_blocks that we read
+// and write.  obfuscate, the newest of them, arrived in Mastodon 3.3.
+var mastodonDomainBlockColumns = [...]string{
+	"id",
+	"domain",
+	"private_comment",
+	"public_comment",
+	"created_at",
+	"updated_at",
+	"severity",
+	"reject_media",
+	"reject_reports",
+	"obfuscate",
+}
+
+const SQLSelectTableColumnsPostgres = `
+SELECT column_name FROM information_schema.columns
+WHERE table_schema = 'public' AND table_name = $1
+`
+
+const SQLSelectSchemaVersionMastodon = `
+SELECT COALESCE(MAX(version::bigint), 0) FROM public.schema_migrations
+`
+
+// CheckMastodonSchema returns the generation of Mastodon to work with.
+// When software is mastodon-auto, it is detected from the database, which
+// must hold a Mastodon schema that we know how to work with; an explicit
+// -x value is trusted as-is.
+func CheckMastodonSchema(ctx context.Context, tx pgx.Tx, software string) (string, error) {
+	if software != MastodonAuto {
+		return software, nil
+	}
+	return DetectMastodonSchema(ctx, tx)
+}
+
+// DetectMastodonSchema determines which generation of Mastodon, Mastodon3x
+// or Mastodon4x, created the schema, by looking at schema_migrations and at
+// the columns of domain_blocks and admin_action_logs.
+func DetectMastodonSchema(ctx context.Context, tx pgx.Tx) (string, error) {
+	migrationColumns, err := GetPostgresTableColumns(ctx, tx, "schema_migrations")
+	if err != nil {
+		return "", err
+	}
+	blockColumns, err := GetPostgresTableColumns(ctx, tx, "domain_blocks")
+	if err != nil {
+		return "", err
+	}
+	logColumns, err := GetPostgresTableColumns(ctx, tx, "admin_action_logs")
+	if err != nil {
+		return "", err
+	}
+	if len(migrationColumns) == 0 || len(blockColumns) == 0 || len(logColumns) == 0 {
+		return "", fmt.Errorf("database does not look like a Mastodon database: expected tables schema_migrations, domain_blocks, and admin_action_logs")
+	}
+
+	const sql = SQLSelectSchemaVersionMastodon
+	var version int64
+	err = tx.QueryRow(ctx, sql).Scan(&version)
+	if err != nil {
+		return "", fmt.Errorf("failed to QueryRow %q: %w", sql, err)
+	}
+
+	return ClassifyMastodonSchema(version, blockColumns, logColumns)
+}
+
+// ClassifyMastodonSchema decides the generation of Mastodon for
+// DetectMastodonSchema, from the schema version and the columns of
+// domain_blocks and admin_action_logs.
+func ClassifyMastodonSchema(version int64, blockColumns map[string]bool, logColumns map[string]bool) (string, error) {
+	if version > MastodonNewestKnownSchemaVersion {
+		return "", fmt.Errorf("Mastodon schema version %d is newer than the newest supported version, %d; upgrade RapidBlock, or set --software=%s to proceed anyway", version, MastodonNewestKnownSchemaVersion, Mastodon4x)
+	}
+
+	for _, column := range mastodonDomainBlockColumns {
+		if !blockColumns[column] {
+			return "", fmt.Errorf("Mastodon schema version %d is older than any supported version: domain_blocks has no %s column; upgrade to Mastodon 3.3 or later", version, column)
+		}
+	}
+
+	if logColumns["human_identifier"] {
+		return Mastodon4x, nil
+	}
+	if logColumns["recorded_changes"] {
+		return Mastodon3x, nil
+	}
+	return "", fmt.Errorf("Mastodon schema version %d is not recognized: admin_action_logs has neither recorded_changes nor human_identifier", version)
+}
+
+// GetPostgresTableColumns returns the set of columns of a table in the
+// public schema.  The set is empty if there is no such table.
+func GetPostgresTableColumns(ctx context.Context, tx pgx.Tx, table string) (map[string]bool, error) {
+	const sql = SQLSelectTableColumnsPostgres
+
+	rows, err := tx.Query(ctx, sql, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Query %q: %w", sql, err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]bool, 16)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process result row from Query %q: %w", sql, err)
+		}
+		out[name] = true
+	}
+
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to process results from Query %q: %w", sql, err)
+	}
+
+	return out, nil
+}
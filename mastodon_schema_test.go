@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClassifyMastodonSchema(t *testing.T) {
+	blockColumns := make(map[string]bool, len(mastodonDomainBlockColumns))
+	for _, column := range mastodonDomainBlockColumns {
+		blockColumns[column] = true
+	}
+	withoutObfuscate := make(map[string]bool, len(blockColumns))
+	for column := range blockColumns {
+		withoutObfuscate[column] = column != "obfuscate"
+	}
+	columns3x := map[string]bool{"recorded_changes": true}
+	columns4x := map[string]bool{"human_identifier": true, "route_param": true, "permalink": true}
+
+	type testCase struct {
+		name         string
+		version      int64
+		blockColumns map[string]bool
+		logColumns   map[string]bool
+		want         string
+		wantErr      string
+	}
+	testCases := []testCase{
+		{name: "mastodon-3x", version: 20210502233513, blockColumns: blockColumns, logColumns: columns3x, want: Mastodon3x},
+		{name: "mastodon-4x", version: 20221101190723, blockColumns: blockColumns, logColumns: columns4x, want: Mastodon4x},
+		{name: "newest-known", version: MastodonNewestKnownSchemaVersion, blockColumns: blockColumns, logColumns: columns4x, want: Mastodon4x},
+		{name: "newer", version: MastodonNewestKnownSchemaVersion + 1, blockColumns: blockColumns, logColumns: columns4x, wantErr: "newest supported version, 20230907150100"},
+		{name: "older", version: 20200101000000, blockColumns: withoutObfuscate, logColumns: columns3x, wantErr: "no obfuscate column"},
+		{name: "unrecognized", version: 20221101190723, blockColumns: blockColumns, logColumns: map[string]bool{}, wantErr: "not recognized"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ClassifyMastodonSchema(tc.version, tc.blockColumns, tc.logColumns)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %q, %v", tc.wantErr, got, err)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("expected %q, got %q, %v", tc.want, got, err)
+			}
+		})
+	}
+}
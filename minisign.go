@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Public keys, secret keys, and signatures in the formats used by minisign
+// (https://jedisct1.github.io/minisign/).  Each file is an "untrusted
+// comment:" line followed by a base-64 line; a signature file adds a
+// "trusted comment:" line, which is covered by a second, global signature,
+// and the base-64 global signature itself.
+//
+// minisign identifies keys by a random 8-byte key ID rather than by a hash
+// of the public key.  Keys that did not come from minisign files are given
+// the first 8 bytes of the SHA-256 hash of the public key as their key ID.
+const (
+	MinisignUntrustedPrefix = "untrusted comment: "
+	MinisignTrustedPrefix   = "trusted comment: "
+
+	minisignAlgEd25519     = "Ed"
+	minisignAlgPrehashed   = "ED"
+	minisignKDFScrypt      = "Sc"
+	minisignKDFNone        = "\x00\x00"
+	minisignChecksumBLAKE2 = "B2"
+
+	minisignKeyIDSize     = 8
+	minisignSaltSize      = 32
+	minisignPublicKeySize = 2 + minisignKeyIDSize + ed25519.PublicKeySize
+	minisignKeynumSize    = minisignKeyIDSize + ed25519.PrivateKeySize + 32
+	minisignSecretKeySize = 2 + 2 + 2 + minisignSaltSize + 8 + 8 + minisignKeynumSize
+	minisignSignatureSize = 2 + minisignKeyIDSize + ed25519.SignatureSize
+
+	// These scrypt limits select N=2^17, r=8, p=1, the same cost as
+	// EncryptKey.
+	minisignOpsLimit = 1 << 22
+	minisignMemLimit = 1 << 28
+)
+
+type MinisignPublicKey struct {
+	KeyID     []byte
+	PublicKey ed25519.PublicKey
+}
+
+type MinisignSecretKey struct {
+	KeyID      []byte
+	PrivateKey ed25519.PrivateKey
+}
+
+type MinisignSignature struct {
+	Algorithm       string
+	KeyID           []byte
+	Signature       []byte
+	TrustedComment  string
+	GlobalSignature []byte
+}
+
+// IsMinisignFile returns true if raw looks like a file in one of the
+// minisign formats.
+func IsMinisignFile(raw []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(raw), []byte(MinisignUntrustedPrefix))
+}
+
+// DefaultMinisignKeyID returns the key ID for a key that has none.
+func DefaultMinisignKeyID(pubKey ed25519.PublicKey) []byte {
+	sum := sha256.Sum256(pubKey)
+	return sum[:minisignKeyIDSize]
+}
+
+// MinisignKeyIDString formats a key ID the way minisign prints it, as a
+// little-endian 64-bit number in hex.
+func MinisignKeyIDString(keyID []byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(keyID))
+}
+
+func NewMinisignKeyID() ([]byte, error) {
+	keyID := make([]byte, minisignKeyIDSize)
+	_, err := rand.Read(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key ID: %w", err)
+	}
+	return keyID, nil
+}
+
+// minisignLines splits a minisign file into its lines, checking that the
+// first is an untrusted comment.
+func minisignLines(raw []byte, want int) ([]string, error) {
+	str := strings.ReplaceAll(string(raw), "\r\n", "\n")
+	lines := strings.Split(strings.TrimRight(str, "\n"), "\n")
+	if len(lines) != want {
+		return nil, fmt.Errorf("minisign file has %d line(s), expected %d", len(lines), want)
+	}
+	if !strings.HasPrefix(lines[0], MinisignUntrustedPrefix) {
+		return nil, fmt.Errorf("minisign file does not start with %q", MinisignUntrustedPrefix)
+	}
+	return lines, nil
+}
+
+func decodeMinisignBase64(line string, expectedSize int) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode from base-64: %w", err)
+	}
+	if len(data) != expectedSize {
+		return nil, fmt.Errorf("data has wrong length: expected %d bytes, got %d bytes", expectedSize, len(data))
+	}
+	return data, nil
+}
+
+// DecodePublicKey reads a public key in the base-64 format, the minisign
+// format, or as the base-64 line of a minisign public key.  The key ID is
+// nil for the base-64 format.
+func DecodePublicKey(raw []byte) (ed25519.PublicKey, []byte, error) {
+	if IsMinisignFile(raw) {
+		lines, err := minisignLines(raw, 2)
+		if err != nil {
+			return nil, nil, err
+		}
+		raw = []byte(lines[1])
+	}
+
+	data, err := DecodeKeySig(raw, -1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) == minisignPublicKeySize && string(data[0:2]) == minisignAlgEd25519 {
+		keyID := data[2 : 2+minisignKeyIDSize]
+		return ed25519.PublicKey(data[2+minisignKeyIDSize:]), keyID, nil
+	}
+	if len(data) != ed25519.PublicKeySize {
+		return nil, nil, fmt.Errorf("data has wrong length: expected %d bytes, got %d bytes", ed25519.PublicKeySize, len(data))
+	}
+	return ed25519.PublicKey(data), nil, nil
+}
+
+func (key MinisignPublicKey) Encode() []byte {
+	data := make([]byte, 0, minisignPublicKeySize)
+	data = append(data, minisignAlgEd25519...)
+	data = append(data, key.KeyID...)
+	data = append(data, key.PublicKey...)
+	return []byte(fmt.Sprintf("%sminisign public key %s\n%s\n", MinisignUntrustedPrefix, MinisignKeyIDString(key.KeyID), base64.StdEncoding.EncodeToString(data)))
+}
+
+// DecodeMinisignSecretKey reads a minisign secret key.  getPassphrase is
+// only called if the key is encrypted.
+func DecodeMinisignSecretKey(raw []byte, getPassphrase func() ([]byte, error)) (MinisignSecretKey, error) {
+	var key MinisignSecretKey
+	lines, err := minisignLines(raw, 2)
+	if err != nil {
+		return key, err
+	}
+	data, err := decodeMinisignBase64(lines[1], minisignSecretKeySize)
+	if err != nil {
+		return key, err
+	}
+
+	sigAlg, kdfAlg, chkAlg := string(data[0:2]), string(data[2:4]), string(data[4:6])
+	salt := data[6 : 6+minisignSaltSize]
+	opsLimit := binary.LittleEndian.Uint64(data[38:46])
+	memLimit := binary.LittleEndian.Uint64(data[46:54])
+	keynum := append([]byte(nil), data[54:]...)
+	switch {
+	case sigAlg != minisignAlgEd25519:
+		return key, fmt.Errorf("unsupported minisign signature algorithm %q", sigAlg)
+	case chkAlg != minisignChecksumBLAKE2:
+		return key, fmt.Errorf("unsupported minisign checksum algorithm %q", chkAlg)
+	case kdfAlg != minisignKDFScrypt && kdfAlg != minisignKDFNone:
+		return key, fmt.Errorf("unsupported minisign key derivation function %q", kdfAlg)
+	}
+
+	if kdfAlg == minisignKDFScrypt {
+		passphrase, err := getPassphrase()
+		if err != nil {
+			return key, err
+		}
+		stream, err := minisignScrypt(passphrase, salt, opsLimit, memLimit)
+		if err != nil {
+			return key, err
+		}
+		xorBytes(keynum, stream)
+	}
+
+	keyID := keynum[:minisignKeyIDSize]
+	sk := keynum[minisignKeyIDSize : minisignKeyIDSize+ed25519.PrivateKeySize]
+	chk := keynum[minisignKeyIDSize+ed25519.PrivateKeySize:]
+	expected := minisignSecretKeyChecksum(keyID, sk)
+	if kdfAlg == minisignKDFNone && bytes.Equal(chk, make([]byte, len(chk))) {
+		// aead.dev/minisign leaves the checksum of an unencrypted key
+		// zeroed; the public half is still checked below.
+		expected = chk
+	}
+	if subtle.ConstantTimeCompare(chk, expected) != 1 {
+		if kdfAlg == minisignKDFScrypt {
+			return key, fmt.Errorf("failed to decrypt private key: wrong passphrase?")
+		}
+		return key, fmt.Errorf("minisign secret key checksum mismatch")
+	}
+
+	key.KeyID = keyID
+	key.PrivateKey = ed25519.NewKeyFromSeed(sk[:ed25519.SeedSize])
+	if !bytes.Equal(key.PrivateKey, sk) {
+		return key, fmt.Errorf("minisign secret key is inconsistent with its public half")
+	}
+	return key, nil
+}
+
+// Encode writes the secret key, encrypted if passphrase is not nil.
+func (key MinisignSecretKey) Encode(passphrase []byte) ([]byte, error) {
+	keynum := make([]byte, 0, minisignKeynumSize)
+	keynum = append(keynum, key.KeyID...)
+	keynum = append(keynum, key.PrivateKey...)
+	keynum = append(keynum, minisignSecretKeyChecksum(key.KeyID, key.PrivateKey)...)
+
+	salt := make([]byte, minisignSaltSize)
+	kdfAlg := minisignKDFNone
+	comment := "minisign secret key"
+	var opsLimit, memLimit uint64
+	if passphrase != nil {
+		_, err := rand.Read(salt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate salt: %w", err)
+		}
+		kdfAlg = minisignKDFScrypt
+		comment = "minisign encrypted secret key"
+		opsLimit, memLimit = minisignOpsLimit, minisignMemLimit
+		stream, err := minisignScrypt(passphrase, salt, opsLimit, memLimit)
+		if err != nil {
+			return nil, err
+		}
+		xorBytes(keynum, stream)
+	}
+
+	data := make([]byte, 0, minisignSecretKeySize)
+	data = append(data, minisignAlgEd25519...)
+	data = append(data, kdfAlg...)
+	data = append(data, minisignChecksumBLAKE2...)
+	data = append(data, salt...)
+	data = binary.LittleEndian.AppendUint64(data, opsLimit)
+	data = binary.LittleEndian.AppendUint64(data, memLimit)
+	data = append(data, keynum...)
+	return []byte(fmt.Sprintf("%s%s\n%s\n", MinisignUntrustedPrefix, comment, base64.StdEncoding.EncodeToString(data))), nil
+}
+
+func xorBytes(dst []byte, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+func minisignSecretKeyChecksum(keyID []byte, sk []byte) []byte {
+	h, _ := blake2b.New256(nil)
+	h.Write([]byte(minisignAlgEd25519))
+	h.Write(keyID)
+	h.Write(sk)
+	return h.Sum(nil)
+}
+
+// minisignScrypt derives the key stream that encrypts a minisign secret
+// key, choosing the scrypt parameters from opsLimit and memLimit as
+// libsodium's crypto_pwhash_scryptsalsa208sha256 does.
+func minisignScrypt(passphrase []byte, salt []byte, opsLimit uint64, memLimit uint64) ([]byte, error) {
+	const r = 8
+	if opsLimit < 32768 {
+		opsLimit = 32768
+	}
+	var nLog2 uint
+	var p uint64
+	if opsLimit < memLimit/32 {
+		p = 1
+		maxN := opsLimit / (r * 4)
+		for nLog2 = 1; nLog2 < 63; nLog2++ {
+			if uint64(1)<<nLog2 > maxN/2 {
+				break
+			}
+		}
+	} else {
+		maxN := memLimit / (r * 128)
+		for nLog2 = 1; nLog2 < 63; nLog2++ {
+			if uint64(1)<<nLog2 > maxN/2 {
+				break
+			}
+		}
+		maxRP := (opsLimit / 4) / (uint64(1) << nLog2)
+		if maxRP > 0x3fffffff {
+			maxRP = 0x3fffffff
+		}
+		p = maxRP / r
+	}
+	if 1<<nLog2 > scryptMaxN || p < 1 || p > scryptMaxP {
+		return nil, fmt.Errorf("unsupported minisign scrypt limits: opslimit=%d memlimit=%d", opsLimit, memLimit)
+	}
+
+	stream, err := scrypt.Key(passphrase, salt, 1<<nLog2, r, int(p), minisignKeynumSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key from passphrase: %w", err)
+	}
+	return stream, nil
+}
+
+// DefaultMinisignTrustedComment is the trusted comment that minisign
+// writes by default for a prehashed signature.
+func DefaultMinisignTrustedComment(dataFileName string, now time.Time) string {
+	return "timestamp:" + strconv.FormatInt(now.Unix(), 10) + "\tfile:" + filepath.Base(dataFileName) + "\thashed"
+}
+
+// NewMinisignSignature signs the BLAKE2b-512 hash of the data, as minisign
+// does by default.
+func NewMinisignSignature(privKey ed25519.PrivateKey, keyID []byte, data []byte, trustedComment string) MinisignSignature {
+	prehash := blake2b.Sum512(data)
+	sig := MinisignSignature{
+		Algorithm:      minisignAlgPrehashed,
+		KeyID:          keyID,
+		Signature:      ed25519.Sign(privKey, prehash[:]),
+		TrustedComment: trustedComment,
+	}
+	sig.GlobalSignature = ed25519.Sign(privKey, sig.globalMessage())
+	return sig
+}
+
+func (sig MinisignSignature) globalMessage() []byte {
+	return append(append([]byte(nil), sig.Signature...), sig.TrustedComment...)
+}
+
+// Verify checks both the signature of the data and the global signature
+// of the trusted comment.  Signatures made by older versions of minisign
+// cover the data itself rather than its hash.
+func (sig MinisignSignature) Verify(pubKey ed25519.PublicKey, data []byte) error {
+	message := data
+	if sig.Algorithm == minisignAlgPrehashed {
+		prehash := blake2b.Sum512(data)
+		message = prehash[:]
+	}
+	if !ed25519.Verify(pubKey, message, sig.Signature) {
+		return fmt.Errorf("signature verification failed!\n\tminisign key ID: %s\n\tEd25519 public key: %s", MinisignKeyIDString(sig.KeyID), KeyFingerprint(pubKey))
+	}
+	if !ed25519.Verify(pubKey, sig.globalMessage(), sig.GlobalSignature) {
+		return fmt.Errorf("trusted comment verification failed!\n\tminisign key ID: %s\n\tEd25519 public key: %s", MinisignKeyIDString(sig.KeyID), KeyFingerprint(pubKey))
+	}
+	return nil
+}
+
+func (sig MinisignSignature) Encode() []byte {
+	data := make([]byte, 0, minisignSignatureSize)
+	data = append(data, sig.Algorithm...)
+	data = append(data, sig.KeyID...)
+	data = append(data, sig.Signature...)
+	var buf bytes.Buffer
+	buf.WriteString(MinisignUntrustedPrefix + "signature from rapidblock secret key\n")
+	buf.WriteString(base64.StdEncoding.EncodeToString(data) + "\n")
+	buf.WriteString(MinisignTrustedPrefix + sig.TrustedComment + "\n")
+	buf.WriteString(base64.StdEncoding.EncodeToString(sig.GlobalSignature) + "\n")
+	return buf.Bytes()
+}
+
+func DecodeMinisignSignature(raw []byte) (MinisignSignature, error) {
+	var sig MinisignSignature
+	lines, err := minisignLines(raw, 4)
+	if err != nil {
+		return sig, err
+	}
+	data, err := decodeMinisignBase64(lines[1], minisignSignatureSize)
+	if err != nil {
+		return sig, err
+	}
+	sig.Algorithm = string(data[0:2])
+	if sig.Algorithm != minisignAlgEd25519 && sig.Algorithm != minisignAlgPrehashed {
+		return sig, fmt.Errorf("unsupported minisign signature algorithm %q", sig.Algorithm)
+	}
+	sig.KeyID = data[2 : 2+minisignKeyIDSize]
+	sig.Signature = data[2+minisignKeyIDSize:]
+
+	if !strings.HasPrefix(lines[2], MinisignTrustedPrefix) {
+		return sig, fmt.Errorf("minisign signature is missing its %q line", MinisignTrustedPrefix)
+	}
+	sig.TrustedComment = strings.TrimPrefix(lines[2], MinisignTrustedPrefix)
+	sig.GlobalSignature, err = decodeMinisignBase64(lines[3], ed25519.SignatureSize)
+	if err != nil {
+		return sig, err
+	}
+	return sig, nil
+}
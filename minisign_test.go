@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The "Hello World!" key and signature were made by minisign itself.  The
+// "Hello Gopher!" key and signatures were made by aead.dev/minisign, which
+// can only make prehashed signatures with a trusted comment of its own, so
+// the prehashed one has the comment that minisign would write.
+const (
+	minisignTestPublicKey = "untrusted comment: minisign public key C373193807678450\n" +
+		"RWRQhGcHOBlzw4CoKyugkk4ioDfoxlXxC9LBx+VNhJ3w9w+cAxgvPsuo\n"
+	minisignTestData      = "Hello World!\n"
+	minisignTestSignature = "untrusted comment: signature from minisign secret key\n" +
+		"RWRQhGcHOBlzwxrJCyuC+rJfHSfyRKRxkuwa3JJ0bWEs7RHjL1OUmqnTr+V1B9JzFuJIH/ybR2Eus9oEZKt9RbitpF/L4D3+5wg=\n" +
+		"trusted comment: timestamp:1614549543\tfile:message.txt\n" +
+		"P/722+ynQ+tIy0qadFHwLx5MsyNz/jDKJkDWQj4dDD2OKnVte8m/M14mwPE/1NMwzShPMSBhMXqZGdbe+UZjDg==\n"
+
+	minisignTestGopherPublicKey = "RWQGPaMY2ls0CkF/83ls7D+IU25w3jeYczwo3s451zDlnrJJwOdt2ro8"
+	minisignTestGopherSecretKey = "untrusted comment: minisign encrypted secret key\n" +
+		"RWRTY0IyorAWr/1gdweGki6ua7GpmoPqS+7rMBSmBy6hedA53dAAABAAAAAAAAAAAAIAAAAAwfmyB6qIIW2eGNiQaFzgs1oi52iN8cRHBPRupc9TVdfAeJvlPdvzu3TfA2DHTW2PZi98uihcr5sEB5fefFml2d0xBk72ZOGNJpOTsn95eHgEH/qUfzQZ018JfiVwWf8pNpdgNFX8ROs=\n"
+	minisignTestGopherPassphrase = "correct horse battery staple"
+	minisignTestGopherData       = "Hello Gopher!"
+	minisignTestGopherSignature  = "untrusted comment: signature from private key: A345BDA18A33D06\n" +
+		"RWQGPaMY2ls0CmMflCAP5J/MpaXmt+3+UoT1vRSPRjXO6w0KNtpkcQe3TxQ35kAwhjFVB6CEYYrHZmMvWjXRutefRHicRUiAJwQ=\n" +
+		"trusted comment: timestamp:1600100266\n" +
+		"2x/lxCqL+PHoT4I9Wc8PHmoNBtohgmFdWwPBON55Y2P0ttpBHgr4OFldr/Hq7nDcBGt5SBs2XjtMnxjVs6byBg==\n"
+	minisignTestGopherPrehashedSignature = "untrusted comment: signature from minisign secret key\n" +
+		"RUQGPaMY2ls0Cmbd7P99LiP8SDUHx7G/jIl4uKZPSVqS6PN7BZzOGWKvGdJVd0g1bO6bPkjJE0PPS+oa4BGCZxM8JvLpvV6V9ww=\n" +
+		"trusted comment: timestamp:1600100266\tfile:hello.txt\thashed\n" +
+		"CvHTtmidWjpe8Xobgb74QIOLPSVYhcRF+MkyhkG1pRV3S+kYQ0Ecy/Dl3u6KLZIRXL52lh8GEFCbJp0PJnB1Dw==\n"
+
+	minisignTestUnencryptedSecretKey = "untrusted comment: minisign encrypted secret key\n" +
+		"RWQAAEIyAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAbuUYgQpHKDcmmMQj9cgqohWX321PrXUDFfCVWOXDZp8kLw2/qju66KnI28LcOaA7ZywNP5vDVtlHeyzit3lxeqirS5+2UImrAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n"
+)
+
+func TestMinisignVerifyVectors(t *testing.T) {
+	type testCase struct {
+		name               string
+		pubKey             string
+		data               string
+		sig                string
+		wantKeyID          string
+		wantAlgorithm      string
+		wantTrustedComment string
+	}
+	testCases := []testCase{
+		{
+			name:               "minisign",
+			pubKey:             minisignTestPublicKey,
+			data:               minisignTestData,
+			sig:                minisignTestSignature,
+			wantKeyID:          "C373193807678450",
+			wantAlgorithm:      minisignAlgEd25519,
+			wantTrustedComment: "timestamp:1614549543\tfile:message.txt",
+		},
+		{
+			name:               "crlf",
+			pubKey:             strings.ReplaceAll(minisignTestPublicKey, "\n", "\r\n"),
+			data:               minisignTestData,
+			sig:                strings.ReplaceAll(minisignTestSignature, "\n", "\r\n"),
+			wantKeyID:          "C373193807678450",
+			wantAlgorithm:      minisignAlgEd25519,
+			wantTrustedComment: "timestamp:1614549543\tfile:message.txt",
+		},
+		{
+			name:               "legacy",
+			pubKey:             minisignTestGopherPublicKey,
+			data:               minisignTestGopherData,
+			sig:                minisignTestGopherSignature,
+			wantKeyID:          "0A345BDA18A33D06",
+			wantAlgorithm:      minisignAlgEd25519,
+			wantTrustedComment: "timestamp:1600100266",
+		},
+		{
+			name:               "prehashed",
+			pubKey:             minisignTestGopherPublicKey,
+			data:               minisignTestGopherData,
+			sig:                minisignTestGopherPrehashedSignature,
+			wantKeyID:          "0A345BDA18A33D06",
+			wantAlgorithm:      minisignAlgPrehashed,
+			wantTrustedComment: "timestamp:1600100266\tfile:hello.txt\thashed",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pubKey, keyID, err := DecodePublicKey([]byte(tc.pubKey))
+			if err != nil {
+				t.Fatalf("DecodePublicKey: %v", err)
+			}
+			if got := MinisignKeyIDString(keyID); got != tc.wantKeyID {
+				t.Errorf("DecodePublicKey: expected key ID %s, got %s", tc.wantKeyID, got)
+			}
+			if !IsMinisignFile([]byte(tc.sig)) {
+				t.Errorf("IsMinisignFile: expected true")
+			}
+
+			sig, err := DecodeMinisignSignature([]byte(tc.sig))
+			if err != nil {
+				t.Fatalf("DecodeMinisignSignature: %v", err)
+			}
+			if sig.Algorithm != tc.wantAlgorithm || !bytes.Equal(sig.KeyID, keyID) || sig.TrustedComment != tc.wantTrustedComment {
+				t.Errorf("DecodeMinisignSignature: unexpected %q %X %q", sig.Algorithm, sig.KeyID, sig.TrustedComment)
+			}
+			if err := sig.Verify(pubKey, []byte(tc.data)); err != nil {
+				t.Errorf("Verify: %v", err)
+			}
+			if err := sig.Verify(pubKey, []byte(tc.data+"!")); err == nil || !strings.Contains(err.Error(), "signature verification failed!") {
+				t.Errorf("Verify: expected other data to be rejected, got %v", err)
+			}
+			sig.TrustedComment += "\tfile:evil.txt"
+			if err := sig.Verify(pubKey, []byte(tc.data)); err == nil || !strings.Contains(err.Error(), "trusted comment verification failed!") {
+				t.Errorf("Verify: expected a tampered trusted comment to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMinisignSecretKeyVectors(t *testing.T) {
+	gopherPubKey, gopherKeyID, err := DecodePublicKey([]byte(minisignTestGopherPublicKey))
+	if err != nil {
+		t.Fatalf("DecodePublicKey: %v", err)
+	}
+	noPassphrase := func() ([]byte, error) {
+		t.Errorf("getPassphrase: called for an unencrypted key")
+		return nil, nil
+	}
+	passphrase := func() ([]byte, error) {
+		return []byte(minisignTestGopherPassphrase), nil
+	}
+
+	key, err := DecodeMinisignSecretKey([]byte(minisignTestGopherSecretKey), passphrase)
+	if err != nil {
+		t.Fatalf("DecodeMinisignSecretKey: %v", err)
+	}
+	if !bytes.Equal(key.KeyID, gopherKeyID) || !gopherPubKey.Equal(key.PrivateKey.Public()) {
+		t.Errorf("DecodeMinisignSecretKey: key does not match its public key")
+	}
+	_, err = DecodeMinisignSecretKey([]byte(minisignTestGopherSecretKey), func() ([]byte, error) { return []byte("wrong"), nil })
+	if err == nil || !strings.Contains(err.Error(), "wrong passphrase?") {
+		t.Errorf("DecodeMinisignSecretKey: expected a wrong passphrase to be rejected, got %v", err)
+	}
+
+	// Ed25519 is deterministic, so the same key, data, and trusted comment
+	// give the same signature, except for the untrusted comment.
+	comment := DefaultMinisignTrustedComment("/srv/hello.txt", time.Unix(1600100266, 0))
+	raw := NewMinisignSignature(key.PrivateKey, key.KeyID, []byte(minisignTestGopherData), comment).Encode()
+	want := strings.SplitN(minisignTestGopherPrehashedSignature, "\n", 2)[1]
+	if got := strings.SplitN(string(raw), "\n", 2)[1]; got != want {
+		t.Errorf("NewMinisignSignature:\n%s\nwant:\n%s", got, want)
+	}
+
+	key, err = DecodeMinisignSecretKey([]byte(minisignTestUnencryptedSecretKey), noPassphrase)
+	if err != nil {
+		t.Fatalf("DecodeMinisignSecretKey: unencrypted: %v", err)
+	}
+	if got := MinisignKeyIDString(key.KeyID); got != "3728470A8118E56E" {
+		t.Errorf("DecodeMinisignSecretKey: expected key ID 3728470A8118E56E, got %s", got)
+	}
+
+	// Our own unencrypted keys carry a checksum, and decode to the same key.
+	raw, err = key.Encode(nil)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, err := DecodeMinisignSecretKey(raw, noPassphrase)
+	if err != nil {
+		t.Fatalf("DecodeMinisignSecretKey: re-encoded: %v", err)
+	}
+	if !bytes.Equal(decoded.KeyID, key.KeyID) || !decoded.PrivateKey.Equal(key.PrivateKey) {
+		t.Errorf("Encode: unencrypted key does not round-trip")
+	}
+
+	corrupt, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.SplitN(string(raw), "\n", 2)[1]))
+	if err != nil {
+		t.Fatalf("DecodeString: %v", err)
+	}
+	corrupt[len(corrupt)-1] ^= 1
+	_, err = DecodeMinisignSecretKey([]byte(MinisignUntrustedPrefix+"corrupt\n"+base64.StdEncoding.EncodeToString(corrupt)+"\n"), noPassphrase)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("DecodeMinisignSecretKey: expected a corrupt checksum to be rejected, got %v", err)
+	}
+}
+
+func TestMinisignRoundTrip(t *testing.T) {
+	pubKey, privKey := newTestKey(t)
+	keyID, err := NewMinisignKeyID()
+	if err != nil {
+		t.Fatalf("NewMinisignKeyID: %v", err)
+	}
+	data := []byte("blocklist\n")
+	comment := DefaultMinisignTrustedComment("/var/cache/rapidblock/blocklist.json", time.Unix(1614549543, 0))
+	if want := "timestamp:1614549543\tfile:blocklist.json\thashed"; comment != want {
+		t.Errorf("DefaultMinisignTrustedComment: expected %q, got %q", want, comment)
+	}
+
+	raw := NewMinisignSignature(privKey, keyID, data, comment).Encode()
+	sig, err := DecodeMinisignSignature(raw)
+	if err != nil {
+		t.Fatalf("DecodeMinisignSignature: %v", err)
+	}
+	if sig.Algorithm != minisignAlgPrehashed || sig.TrustedComment != comment {
+		t.Errorf("DecodeMinisignSignature: unexpected %q %q", sig.Algorithm, sig.TrustedComment)
+	}
+	if err := sig.Verify(pubKey, data); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	pubRaw := MinisignPublicKey{KeyID: keyID, PublicKey: pubKey}.Encode()
+	decoded, decodedKeyID, err := DecodePublicKey(pubRaw)
+	if err != nil {
+		t.Fatalf("DecodePublicKey: %v", err)
+	}
+	if !decoded.Equal(pubKey) || !bytes.Equal(decodedKeyID, keyID) {
+		t.Errorf("DecodePublicKey: public key does not round-trip")
+	}
+}
+
+func TestDecodeMinisignSignatureErrors(t *testing.T) {
+	lines := strings.SplitAfter(minisignTestSignature, "\n")
+	badAlgorithm, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	copy(badAlgorithm, "XX")
+
+	type testCase struct {
+		name    string
+		raw     string
+		wantErr string
+	}
+	testCases := []testCase{
+		{name: "short", raw: lines[0] + lines[1], wantErr: "minisign file has 2 line(s), expected 4"},
+		{name: "no-untrusted-comment", raw: "comment\n" + lines[1] + lines[2] + lines[3], wantErr: "minisign file does not start with"},
+		{name: "no-trusted-comment", raw: lines[0] + lines[1] + "comment\n" + lines[3], wantErr: "missing its \"trusted comment: \" line"},
+		{name: "bad-algorithm", raw: lines[0] + base64.StdEncoding.EncodeToString(badAlgorithm) + "\n" + lines[2] + lines[3], wantErr: `unsupported minisign signature algorithm "XX"`},
+		{name: "short-global-signature", raw: lines[0] + lines[1] + lines[2] + "AAAA\n", wantErr: "data has wrong length"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecodeMinisignSignature([]byte(tc.raw))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("DecodeMinisignSignature: expected an error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
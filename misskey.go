@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+)
+
+// Misskey and its forks keep blocked instances as the "blockedHosts" text
+// array on their single meta row.  The array has nowhere to record who
+// added an entry, so the hosts that RapidBlock manages, and the reasons
+// for blocking them, are tracked in a sidecar table in the same database.
+// Hosts in the array that the sidecar table does not list belong to the
+// local admins.
+//
+// Misskey has no notion of severity: every blocked host is a full
+// suspension, and there is nothing corresponding to reject_media,
+// reject_reports, or obfuscate.
+const (
+	SkipReasonMisskeySeverity = "severity not supported by misskey"
+)
+
+const SQLSelectMetaMisskey = `
+SELECT id, "blockedHosts" FROM public.meta LIMIT 1 FOR UPDATE
+`
+
+const SQLUpdateMetaMisskey = `
+UPDATE public.meta SET "blockedHosts" = $2 WHERE id = $1
+`
+
+const SQLHasManagedHostsTableMisskey = `
+SELECT to_regclass('public.rapidblock_managed_hosts') IS NOT NULL
+`
+
+const SQLCreateManagedHostsTableMisskey = `
+CREATE TABLE IF NOT EXISTS public.rapidblock_managed_hosts (
+	host varchar(512) NOT NULL PRIMARY KEY,
+	reason text NOT NULL DEFAULT '',
+	created_at timestamp with time zone NOT NULL,
+	updated_at timestamp with time zone NOT NULL
+)
+`
+
+const SQLSelectManagedHostsMisskey = `
+SELECT host, reason, created_at, updated_at FROM public.rapidblock_managed_hosts
+`
+
+const SQLUpsertManagedHostMisskey = `
+INSERT INTO public.rapidblock_managed_hosts
+	(host, reason, created_at, updated_at)
+VALUES
+	($1, $2, $3, $4)
+ON CONFLICT (host) DO UPDATE SET reason = EXCLUDED.reason, updated_at = EXCLUDED.updated_at
+`
+
+const SQLDeleteManagedHostMisskey = `
+DELETE FROM public.rapidblock_managed_hosts WHERE host = $1
+`
+
+// MisskeyMeta is the part of the meta row, and of the sidecar table, that
+// describes blocked hosts.
+type MisskeyMeta struct {
+	ID           string
+	BlockedHosts []string
+	Managed      map[string]MastodonDomainBlock
+}
+
+func ApplyMisskey(ctx context.Context, file BlockFile, opts ApplyOptions) (Plan, error) {
+	conn, err := pgx.Connect(ctx, opts.DatabaseURL)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to Begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	meta, err := GetMisskeyMeta(ctx, tx)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	existingBlocks := meta.DomainBlocks()
+
+	now := time.Now().UTC()
+	plan := PlanMisskey(file, opts.Policy, opts.Overrides, existingBlocks, now)
+	if opts.DryRun {
+		return plan, nil
+	}
+
+	if !opts.Force {
+		err = opts.Limits.Check(plan.Totals(), CountManagedMastodonBlocks(existingBlocks))
+		if err != nil {
+			return plan, err
+		}
+	}
+
+	totals := plan.Totals()
+	if totals.Inserted+totals.Updated+totals.Deleted == 0 {
+		return plan, nil
+	}
+
+	err = ExecuteMisskeyPlan(ctx, tx, meta, plan)
+	if err != nil {
+		return plan, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return plan, fmt.Errorf("failed to Commit transaction: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "info: %s caches instance settings in memory; restart it for these changes to take effect\n", opts.Software)
+	return plan, nil
+}
+
+// PlanMisskey is PlanMastodon, adjusted for what Misskey can express.
+func PlanMisskey(file BlockFile, policy Policy, overrides Overrides, existingBlocks map[string]MastodonDomainBlock, now time.Time) Plan {
+	plan := PlanMastodon(file, policy, overrides, existingBlocks, now)
+	return plan.SuspendOnly(now, SkipReasonMisskeySeverity, func(block *MastodonDomainBlock) {
+		block.RejectMedia = false
+		block.RejectReports = false
+		block.Obfuscate = false
+	})
+}
+
+func GetMisskeyMeta(ctx context.Context, tx pgx.Tx) (MisskeyMeta, error) {
+	var meta MisskeyMeta
+
+	sql := SQLSelectMetaMisskey
+	err := tx.QueryRow(ctx, sql).Scan(&meta.ID, &meta.BlockedHosts)
+	if err != nil {
+		return meta, fmt.Errorf("failed to QueryRow %q: %w", sql, err)
+	}
+
+	// In dry-run mode nothing has created the sidecar table yet, and
+	// nothing should.
+	var hasTable bool
+	sql = SQLHasManagedHostsTableMisskey
+	err = tx.QueryRow(ctx, sql).Scan(&hasTable)
+	if err != nil {
+		return meta, fmt.Errorf("failed to QueryRow %q: %w", sql, err)
+	}
+
+	meta.Managed = make(map[string]MastodonDomainBlock, 1024)
+	if !hasTable {
+		return meta, nil
+	}
+
+	sql = SQLSelectManagedHostsMisskey
+	rows, err := tx.Query(ctx, sql)
+	if err != nil {
+		return meta, fmt.Errorf("failed to Query %q: %w", sql, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row MastodonDomainBlock
+		err = rows.Scan(&row.Domain, &row.PublicComment, &row.CreatedAt, &row.UpdatedAt)
+		if err != nil {
+			return meta, fmt.Errorf("failed to process result row from Query %q: %w", sql, err)
+		}
+		row.PrivateComment = WellKnownPrivateComment
+		row.Severity = SeveritySuspend
+		meta.Managed[row.Domain] = row
+	}
+
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return meta, fmt.Errorf("failed to process results from Query %q: %w", sql, err)
+	}
+
+	return meta, nil
+}
+
+// DomainBlocks describes blockedHosts as the equivalent Mastodon domain
+// blocks, so that they can be planned the same way.  Sidecar rows for hosts
+// that an admin has since removed from blockedHosts are ignored.
+func (meta MisskeyMeta) DomainBlocks() map[string]MastodonDomainBlock {
+	out := make(map[string]MastodonDomainBlock, len(meta.BlockedHosts))
+	for _, host := range meta.BlockedHosts {
+		block, isManaged := meta.Managed[host]
+		if !isManaged {
+			block = MastodonDomainBlock{Domain: host, Severity: SeveritySuspend}
+		}
+		out[host] = block
+	}
+	return out
+}
+
+// BlockedHostsAfter returns the new value of blockedHosts once plan has been
+// executed.  Hosts keep their order, and inserted hosts go at the end.
+func (meta MisskeyMeta) BlockedHostsAfter(plan Plan) []string {
+	removed := make(map[string]struct{}, len(plan.Items))
+	added := make([]string, 0, len(plan.Items))
+	for _, item := range plan.Items {
+		switch item.Action {
+		case InsertAction:
+			added = append(added, item.Domain)
+		case DeleteAction:
+			removed[item.Domain] = struct{}{}
+		}
+	}
+
+	blockedHosts := make([]string, 0, len(meta.BlockedHosts)+len(added))
+	present := make(map[string]struct{}, cap(blockedHosts))
+	for _, host := range meta.BlockedHosts {
+		if _, isRemoved := removed[host]; !isRemoved {
+			blockedHosts = append(blockedHosts, host)
+			present[host] = struct{}{}
+		}
+	}
+	for _, host := range added {
+		if _, isPresent := present[host]; !isPresent {
+			blockedHosts = append(blockedHosts, host)
+			present[host] = struct{}{}
+		}
+	}
+	return blockedHosts
+}
+
+func ExecuteMisskeyPlan(ctx context.Context, tx pgx.Tx, meta MisskeyMeta, plan Plan) error {
+	_, err := tx.Exec(ctx, SQLCreateManagedHostsTableMisskey)
+	if err != nil {
+		return fmt.Errorf("failed to Exec %q: %w", SQLCreateManagedHostsTableMisskey, err)
+	}
+
+	blockedHosts := meta.BlockedHostsAfter(plan)
+	present := make(map[string]struct{}, len(blockedHosts))
+	for _, host := range blockedHosts {
+		present[host] = struct{}{}
+	}
+
+	sql := SQLUpdateMetaMisskey
+	_, err = tx.Exec(ctx, sql, meta.ID, blockedHosts)
+	if err != nil {
+		return fmt.Errorf("failed to Exec %q: %w", sql, err)
+	}
+
+	for _, item := range plan.Items {
+		switch item.Action {
+		case InsertAction, UpdateAction:
+			block := *item.New
+			sql = SQLUpsertManagedHostMisskey
+			_, err = tx.Exec(ctx, sql, block.Domain, block.PublicComment, block.CreatedAt, block.UpdatedAt)
+		case DeleteAction:
+			sql = SQLDeleteManagedHostMisskey
+			_, err = tx.Exec(ctx, sql, item.Domain)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to Exec %q: %w", sql, err)
+		}
+	}
+
+	// Forget hosts that an admin has removed from blockedHosts by hand.
+	for host := range meta.Managed {
+		if _, isPresent := present[host]; !isPresent {
+			sql = SQLDeleteManagedHostMisskey
+			_, err = tx.Exec(ctx, sql, host)
+			if err != nil {
+				return fmt.Errorf("failed to Exec %q: %w", sql, err)
+			}
+		}
+	}
+	return nil
+}
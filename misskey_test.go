@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMisskeyMetaDomainBlocks(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	meta := MisskeyMeta{
+		BlockedHosts: []string{"managed.example", "local.example"},
+		Managed: map[string]MastodonDomainBlock{
+			"managed.example": newTestManagedBlock("managed.example", "spam", created),
+			"removed.example": newTestManagedBlock("removed.example", "spam", created),
+		},
+	}
+	want := map[string]MastodonDomainBlock{
+		"managed.example": meta.Managed["managed.example"],
+		"local.example":   {Domain: "local.example", Severity: SeveritySuspend},
+	}
+	if got := meta.DomainBlocks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("DomainBlocks:\n\tgot  %+v\n\twant %+v", got, want)
+	}
+}
+
+func TestPlanMisskey(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	policy := Policy{
+		Default: PolicyAction{Severity: SeveritySuspend},
+		Rules: []PolicyRule{
+			{Tags: []string{"spam"}, PolicyAction: PolicyAction{Severity: SeveritySilence}},
+			{Tags: []string{"porn"}, PolicyAction: PolicyAction{Severity: SeveritySuspend, RejectMedia: true, Obfuscate: true}},
+		},
+	}
+	existingBlocks := map[string]MastodonDomainBlock{
+		"downgraded.example": newTestManagedBlock("downgraded.example", "spam", created),
+		"same.example":       newTestManagedBlock("same.example", "porn", created),
+		"local.example":      {Domain: "local.example", Severity: SeveritySuspend},
+	}
+	file := BlockFile{Blocks: map[string]Block{
+		"new.example":        {IsBlocked: true, Reason: "harassment"},
+		"silenced.example":   {IsBlocked: true, Reason: "spam", Tags: []string{"spam"}},
+		"downgraded.example": {IsBlocked: true, Reason: "spam", Tags: []string{"spam"}},
+		"same.example":       {IsBlocked: true, Reason: "porn", Tags: []string{"porn"}},
+		"local.example":      {IsBlocked: true, Reason: "spam"},
+	}}
+
+	type wantItem struct {
+		domain string
+		action PlanAction
+		reason string
+	}
+	want := []wantItem{
+		{domain: "downgraded.example", action: DeleteAction, reason: SkipReasonMisskeySeverity},
+		{domain: "local.example", action: SkipAction, reason: SkipReasonLocalDecision},
+		{domain: "new.example", action: InsertAction},
+		{domain: "silenced.example", action: SkipAction, reason: SkipReasonMisskeySeverity},
+	}
+
+	plan := PlanMisskey(file, policy, Overrides{}, existingBlocks, now)
+	var got []wantItem
+	for _, item := range plan.Items {
+		got = append(got, wantItem{domain: item.Domain, action: item.Action, reason: item.Reason})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("PlanMisskey:\n\tgot  %+v\n\twant %+v", got, want)
+	}
+	if deleted := plan.ItemsWithAction(DeleteAction)[0]; !deleted.Old.UpdatedAt.Equal(now) {
+		t.Errorf("delete: expected updated_at to be now, got %+v", *deleted.Old)
+	}
+}
+
+func TestMisskeyMetaBlockedHostsAfter(t *testing.T) {
+	meta := MisskeyMeta{BlockedHosts: []string{"local.example", "stale.example", "kept.example"}}
+	plan := Plan{Items: []PlanItem{
+		{Domain: "stale.example", Action: DeleteAction},
+		{Domain: "new.example", Action: InsertAction},
+		{Domain: "kept.example", Action: UpdateAction},
+		{Domain: "skipped.example", Action: SkipAction},
+		{Domain: "local.example", Action: InsertAction},
+	}}
+	want := []string{"local.example", "kept.example", "new.example"}
+	if got := meta.BlockedHostsAfter(plan); !reflect.DeepEqual(got, want) {
+		t.Errorf("BlockedHostsAfter: expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Overrides is a list of local decisions that take precedence over the
+// upstream blocklist.  Each entry's Domain is either an exact domain name,
+// or a wildcard of the form "*.example.com" which matches every subdomain
+// of example.com (but not example.com itself).  An exact match beats any
+// wildcard, and a longer wildcard beats a shorter one.
+type Overrides struct {
+	Entries []Override `yaml:"overrides"`
+}
+
+type Override struct {
+	Domain       string         `yaml:"domain"`
+	Action       OverrideAction `yaml:"action"`
+	Reason       string         `yaml:"reason"`
+	PolicyAction `yaml:",inline"`
+}
+
+func LoadOverridesFile(filePath string) (Overrides, error) {
+	var overrides Overrides
+	err := LoadYamlFile(&overrides, filePath)
+	if err != nil {
+		return overrides, err
+	}
+
+	var errs ErrorList
+	for _, err := range overrides.Validate() {
+		errs = append(errs, fmt.Errorf("%q: %w", filePath, err))
+	}
+	if len(errs) > 0 {
+		return overrides, errs
+	}
+	return overrides, nil
+}
+
+func (overrides Overrides) Validate() []error {
+	var errs []error
+	seen := make(map[string]int, len(overrides.Entries))
+	for index, o := range overrides.Entries {
+		domain := normalizeDomain(strings.TrimPrefix(o.Domain, "*."))
+		switch {
+		case domain == "":
+			errs = append(errs, fmt.Errorf("overrides[%d]: missing domain", index))
+		case strings.Contains(domain, "*"):
+			errs = append(errs, fmt.Errorf("overrides[%d]: domain %q: wildcards are only allowed as a leading \"*.\"", index, o.Domain))
+		}
+		if o.Action == NoOverride {
+			errs = append(errs, fmt.Errorf("overrides[%d]: domain %q: missing action", index, o.Domain))
+		}
+		key := normalizeDomain(o.Domain)
+		if prev, found := seen[key]; found {
+			errs = append(errs, fmt.Errorf("overrides[%d]: domain %q: duplicates overrides[%d]", index, o.Domain, prev))
+		} else {
+			seen[key] = index
+		}
+	}
+	return errs
+}
+
+// Match returns the override that applies to domain, if any.
+func (overrides Overrides) Match(domain string) (Override, bool) {
+	domain = normalizeDomain(domain)
+
+	var best Override
+	bestLen := -1
+	for _, o := range overrides.Entries {
+		pattern := normalizeDomain(o.Domain)
+		if pattern == domain {
+			return o, true
+		}
+		suffix := strings.TrimPrefix(pattern, "*")
+		if suffix != pattern && strings.HasSuffix(domain, suffix) && len(suffix) > bestLen {
+			best = o
+			bestLen = len(suffix)
+		}
+	}
+	return best, bestLen >= 0
+}
+
+// ExactDomains returns the domains named without a wildcard by overrides
+// with the given action.
+func (overrides Overrides) ExactDomains(action OverrideAction) []string {
+	var out []string
+	for _, o := range overrides.Entries {
+		if o.Action == action && !strings.HasPrefix(o.Domain, "*.") {
+			out = append(out, normalizeDomain(o.Domain))
+		}
+	}
+	return out
+}
+
+func (o Override) Description() string {
+	return fmt.Sprintf("override %s %s", o.Action, o.Domain)
+}
+
+func (o *Override) UnmarshalYAML(node *yaml.Node) error {
+	type rawOverride Override
+	raw := rawOverride{PolicyAction: PolicyAction{Severity: SeveritySuspend}}
+	err := node.Decode(&raw)
+	if err != nil {
+		return err
+	}
+	*o = Override(raw)
+	return nil
+}
+
+var _ yaml.Unmarshaler = (*Override)(nil)
+
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimRight(domain, "."))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadOverridesFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "overrides.yaml")
+	writeTestFile(t, filePath, []byte(`
+overrides:
+  - domain: "friend.example"
+    action: never-block
+  - domain: "*.spam.example"
+    action: always-block
+    severity: silence
+    reason: "local decision"
+  - domain: "disputed.example"
+    action: ignore
+`))
+	overrides, err := LoadOverridesFile(filePath)
+	if err != nil {
+		t.Fatalf("LoadOverridesFile: %v", err)
+	}
+	want := Overrides{Entries: []Override{
+		{Domain: "friend.example", Action: NeverBlockOverride, PolicyAction: PolicyAction{Severity: SeveritySuspend}},
+		{Domain: "*.spam.example", Action: AlwaysBlockOverride, Reason: "local decision", PolicyAction: PolicyAction{Severity: SeveritySilence}},
+		{Domain: "disputed.example", Action: IgnoreUpstreamOverride, PolicyAction: PolicyAction{Severity: SeveritySuspend}},
+	}}
+	if !reflect.DeepEqual(overrides, want) {
+		t.Errorf("LoadOverridesFile:\n\tgot  %+v\n\twant %+v", overrides, want)
+	}
+}
+
+func TestOverridesValidate(t *testing.T) {
+	type testCase struct {
+		name    string
+		entries []Override
+		wantErr string
+	}
+	testCases := []testCase{
+		{name: "ok", entries: []Override{{Domain: "a.example", Action: NeverBlockOverride}, {Domain: "*.a.example", Action: NeverBlockOverride}}},
+		{name: "missing-domain", entries: []Override{{Action: NeverBlockOverride}}, wantErr: "missing domain"},
+		{name: "inner-wildcard", entries: []Override{{Domain: "a.*.example", Action: NeverBlockOverride}}, wantErr: "wildcards are only allowed"},
+		{name: "missing-action", entries: []Override{{Domain: "a.example"}}, wantErr: "missing action"},
+		{name: "duplicate", entries: []Override{{Domain: "a.example", Action: NeverBlockOverride}, {Domain: "A.example.", Action: AlwaysBlockOverride}}, wantErr: "duplicates overrides[0]"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := Overrides{Entries: tc.entries}.Validate()
+			switch {
+			case tc.wantErr == "" && len(errs) != 0:
+				t.Errorf("Validate: unexpected errors %v", errs)
+			case tc.wantErr != "" && (len(errs) != 1 || !strings.Contains(errs[0].Error(), tc.wantErr)):
+				t.Errorf("Validate: expected one error containing %q, got %v", tc.wantErr, errs)
+			}
+		})
+	}
+}
+
+func TestOverridesMatch(t *testing.T) {
+	overrides := Overrides{Entries: []Override{
+		{Domain: "*.example", Action: NeverBlockOverride},
+		{Domain: "*.spam.example", Action: AlwaysBlockOverride},
+		{Domain: "ham.spam.example", Action: IgnoreUpstreamOverride},
+	}}
+
+	type testCase struct {
+		domain string
+		want   string
+	}
+	testCases := []testCase{
+		{domain: "ham.spam.example", want: "ham.spam.example"},
+		{domain: "HAM.spam.example.", want: "ham.spam.example"},
+		{domain: "eggs.spam.example", want: "*.spam.example"},
+		{domain: "spam.example", want: "*.example"},
+		{domain: "example", want: ""},
+		{domain: "other.test", want: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.domain, func(t *testing.T) {
+			o, found := overrides.Match(tc.domain)
+			if got := o.Domain; found != (tc.want != "") || got != tc.want {
+				t.Errorf("Match(%q): expected %q, got %q (found=%v)", tc.domain, tc.want, got, found)
+			}
+		})
+	}
+}
+
+func TestPlanMastodonOverrides(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	overrides := Overrides{Entries: []Override{
+		{Domain: "friend.example", Action: NeverBlockOverride},
+		{Domain: "*.friends.example", Action: NeverBlockOverride},
+		{Domain: "disputed.example", Action: IgnoreUpstreamOverride},
+		{Domain: "local.example", Action: AlwaysBlockOverride, Reason: "local decision", PolicyAction: PolicyAction{Severity: SeveritySilence}},
+	}}
+	existingBlocks := map[string]MastodonDomainBlock{
+		"a.friends.example": newTestManagedBlock("a.friends.example", "spam", created),
+		"disputed.example":  newTestManagedBlock("disputed.example", "spam", created),
+	}
+	file := BlockFile{Blocks: map[string]Block{
+		"friend.example":    {IsBlocked: true, Reason: "spam"},
+		"a.friends.example": {IsBlocked: true, Reason: "spam"},
+		"disputed.example":  {IsBlocked: false},
+	}}
+
+	type wantItem struct {
+		domain string
+		action PlanAction
+		reason string
+	}
+	want := []wantItem{
+		{domain: "disputed.example", action: SkipAction, reason: "override ignore-upstream disputed.example"},
+		{domain: "friend.example", action: SkipAction, reason: "override never-block friend.example"},
+		{domain: "a.friends.example", action: DeleteAction, reason: "override never-block *.friends.example"},
+		{domain: "local.example", action: InsertAction, reason: "override always-block local.example"},
+	}
+
+	plan := PlanMastodon(file, DefaultPolicy(), overrides, existingBlocks, now)
+	var got []wantItem
+	for _, item := range plan.Items {
+		got = append(got, wantItem{domain: item.Domain, action: item.Action, reason: item.Reason})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("PlanMastodon:\n\tgot  %+v\n\twant %+v", got, want)
+	}
+	inserted := plan.ItemsWithAction(InsertAction)[0].New
+	if inserted.Severity != SeveritySilence || inserted.PublicComment != "local decision" {
+		t.Errorf("always-block: expected a silence with the override's reason, got %+v", *inserted)
+	}
+}
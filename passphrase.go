@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Passphrases for encrypted private keys come from, in order of
+// preference: the file descriptor named by a flag, an environment
+// variable, or a prompt on the controlling terminal.  The first two are for
+// automation.
+const (
+	PassphraseEnv    = "RAPIDBLOCK_PASSPHRASE"
+	NewPassphraseEnv = "RAPIDBLOCK_NEW_PASSPHRASE"
+
+	maxPassphraseSize = 4096
+)
+
+// readPassphrase reads a passphrase from fd if it is not negative, else
+// from the environment variable envName if it is set, else from the
+// terminal.  fdFlag names the flag that sets fd, for error messages.  If isNew, the terminal prompt asks for it twice.
+func readPassphrase(prompt string, fd int, fdFlag string, envName string, isNew bool) ([]byte, error) {
+	var passphrase []byte
+	switch {
+	case fd >= 0:
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("/dev/fd/%d", fd))
+		raw, err := io.ReadAll(io.LimitReader(file, maxPassphraseSize+1))
+		_ = file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase from file descriptor %d: %w", fd, err)
+		}
+		if len(raw) > maxPassphraseSize {
+			return nil, fmt.Errorf("passphrase from file descriptor %d exceeds %d bytes", fd, maxPassphraseSize)
+		}
+		passphrase = trimNewline(raw)
+
+	case os.Getenv(envName) != "":
+		passphrase = []byte(os.Getenv(envName))
+
+	default:
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			return nil, fmt.Errorf("no terminal to prompt for a passphrase; set $%s or use %s: %w", envName, fdFlag, err)
+		}
+		defer tty.Close()
+
+		passphrase, err = promptPassphrase(tty, prompt)
+		if err != nil {
+			return nil, err
+		}
+		if isNew {
+			again, err := promptPassphrase(tty, "Repeat passphrase: ")
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(passphrase, again) {
+				return nil, fmt.Errorf("passphrases do not match")
+			}
+		}
+	}
+
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	return passphrase, nil
+}
+
+func promptPassphrase(tty *os.File, prompt string) ([]byte, error) {
+	_, err := io.WriteString(tty, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prompt for passphrase: %w", err)
+	}
+	line, err := readLineNoEcho(tty)
+	_, _ = io.WriteString(tty, "\n")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase from terminal: %w", err)
+	}
+	return line, nil
+}
+
+// readLine reads up to a newline, one byte at a time so as not to consume
+// anything past it.
+func readLine(r io.Reader) ([]byte, error) {
+	var out []byte
+	var b [1]byte
+	for len(out) <= maxPassphraseSize {
+		n, err := r.Read(b[:])
+		if n > 0 {
+			if b[0] == '\n' {
+				return trimNewline(out), nil
+			}
+			out = append(out, b[0])
+		}
+		if errors.Is(err, io.EOF) {
+			return trimNewline(out), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("passphrase exceeds %d bytes", maxPassphraseSize)
+}
+
+func trimNewline(raw []byte) []byte {
+	raw = bytes.TrimSuffix(raw, []byte("\n"))
+	raw = bytes.TrimSuffix(raw, []byte("\r"))
+	return raw
+}
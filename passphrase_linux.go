@@ -0,0 +1,31 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// readLineNoEcho reads a line from the terminal with echo turned off.
+func readLineNoEcho(tty *os.File) ([]byte, error) {
+	fd := tty.Fd()
+	var saved syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(&saved)))
+	if errno != 0 {
+		return nil, errno
+	}
+
+	noEcho := saved
+	noEcho.Lflag &^= syscall.ECHO
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCSETS, uintptr(unsafe.Pointer(&noEcho)))
+	if errno != 0 {
+		return nil, errno
+	}
+	defer func() {
+		_, _, _ = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCSETS, uintptr(unsafe.Pointer(&saved)))
+	}()
+
+	return readLine(tty)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// readLineNoEcho is only implemented for Linux, where the daemon runs.
+// Elsewhere, use the environment variable or a file descriptor.
+func readLineNoEcho(tty *os.File) ([]byte, error) {
+	return nil, fmt.Errorf("cannot turn off terminal echo on this platform")
+}
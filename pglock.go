@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// RapidBlockAdvisoryLockKey is the PostgreSQL advisory lock that apply and
+// uninstall hold for the duration of their transaction, so that two runs
+// against the same database never plan against the same snapshot.  It is
+// "RapidBlk" in ASCII.
+const RapidBlockAdvisoryLockKey int64 = 0x5261706964426c6b
+
+const (
+	advisoryLockPollInterval = time.Second
+	maxTxAttempts            = 3
+)
+
+const SQLTryAdvisoryLockPostgres = `
+SELECT pg_try_advisory_xact_lock($1)
+`
+
+// LockedError is returned when another run holds the advisory lock.
+type LockedError struct {
+	Waited time.Duration
+}
+
+func (err *LockedError) Error() string {
+	if err.Waited <= 0 {
+		return "another run of RapidBlock is applying changes to this database"
+	}
+	return fmt.Sprintf("another run of RapidBlock is applying changes to this database; gave up after waiting %v", err.Waited)
+}
+
+// LockAdvisory takes RapidBlockAdvisoryLockKey for the rest of tx.  If
+// another session holds it, LockAdvisory polls until timeout has passed,
+// then returns a *LockedError.  A timeout of zero or less means not to
+// wait at all.
+func LockAdvisory(ctx context.Context, tx pgx.Tx, timeout time.Duration) error {
+	const sql = SQLTryAdvisoryLockPostgres
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for {
+		var locked bool
+		err := tx.QueryRow(ctx, sql, RapidBlockAdvisoryLockKey).Scan(&locked)
+		if err != nil {
+			return fmt.Errorf("failed to QueryRow %q: %w", sql, err)
+		}
+		if locked {
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return &LockedError{Waited: time.Since(start).Round(time.Second)}
+		}
+		if remaining > advisoryLockPollInterval {
+			remaining = advisoryLockPollInterval
+		}
+		err = sleepUntil(ctx, time.Now().Add(remaining))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// RetryTx calls fn, which runs one whole transaction, until it succeeds,
+// fails for a reason other than a deadlock or a serialization failure, or
+// has been tried maxTxAttempts times.
+//
+// The transactions are begun at the server's default isolation level.  At
+// READ COMMITTED, a deadlock with some other writer, such as Mastodon
+// itself, is the only failure worth retrying; an admin who sets
+// default_transaction_isolation to REPEATABLE READ or SERIALIZABLE will
+// also see serialization failures, which succeed on a second try.
+func RetryTx(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= maxTxAttempts || !isRetryableTxError(err) {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "info: retrying transaction (attempt %d of %d): %v\n", attempt+1, maxTxAttempts, err)
+		err = sleepUntil(ctx, time.Now().Add(time.Duration(attempt)*100*time.Millisecond))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func isRetryableTxError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch pgErr.Code {
+	case "40001": // serialization_failure
+		return true
+	case "40P01": // deadlock_detected
+		return true
+	}
+	return false
+}
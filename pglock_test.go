@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsRetryableTxError(t *testing.T) {
+	type testCase struct {
+		name string
+		err  error
+		want bool
+	}
+	testCases := []testCase{
+		{name: "deadlock", err: &pgconn.PgError{Code: "40P01"}, want: true},
+		{name: "wrapped-deadlock", err: fmt.Errorf("failed to Exec: %w", &pgconn.PgError{Code: "40P01"}), want: true},
+		{name: "serialization-failure", err: &pgconn.PgError{Code: "40001"}, want: true},
+		{name: "unique-violation", err: &pgconn.PgError{Code: "23505"}},
+		{name: "not-postgres", err: errors.New("connection reset")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isRetryableTxError(tc.err); got != tc.want {
+				t.Errorf("isRetryableTxError(%v): expected %v, got %v", tc.err, tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+type Plan struct {
+	Items []PlanItem `json:"items"`
+}
+
+type PlanItem struct {
+	Domain string               `json:"domain"`
+	Action PlanAction           `json:"action"`
+	Old    *MastodonDomainBlock `json:"old,omitempty"`
+	New    *MastodonDomainBlock `json:"new,omitempty"`
+	Reason string               `json:"reason,omitempty"`
+	Impact *DomainImpact        `json:"impact,omitempty"`
+}
+
+type PlanTotals struct {
+	Inserted int `json:"inserted"`
+	Updated  int `json:"updated"`
+	Deleted  int `json:"deleted"`
+	Skipped  int `json:"skipped"`
+}
+
+func (plan *Plan) Add(item PlanItem) {
+	plan.Items = append(plan.Items, item)
+}
+
+func (plan *Plan) Sort() {
+	sort.SliceStable(plan.Items, func(i, j int) bool {
+		return domainNameLess(plan.Items[i].Domain, plan.Items[j].Domain)
+	})
+}
+
+func (plan Plan) Totals() PlanTotals {
+	var totals PlanTotals
+	for _, item := range plan.Items {
+		switch item.Action {
+		case InsertAction:
+			totals.Inserted++
+		case UpdateAction:
+			totals.Updated++
+		case DeleteAction:
+			totals.Deleted++
+		case SkipAction:
+			totals.Skipped++
+		}
+	}
+	return totals
+}
+
+func (plan Plan) ItemsWithAction(action PlanAction) []PlanItem {
+	out := make([]PlanItem, 0, len(plan.Items))
+	for _, item := range plan.Items {
+		if item.Action == action {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// SuspendOnly adjusts plan for software that can only suspend a domain.
+// Blocks that would be less severe than a suspension cannot be stored, so
+// they are skipped with the given reason, and existing blocks that would be
+// downgraded to one are deleted.  The unsupported attributes of each new
+// block are cleared by clear, and updates that then change nothing are
+// dropped.
+func (plan Plan) SuspendOnly(now time.Time, reason string, clear func(*MastodonDomainBlock)) Plan {
+	items := make([]PlanItem, 0, len(plan.Items))
+	for _, item := range plan.Items {
+		if item.New != nil {
+			updated := *item.New
+			clear(&updated)
+			item.New = &updated
+		}
+
+		switch {
+		case item.Action == InsertAction && item.New.Severity != SeveritySuspend:
+			item = PlanItem{Domain: item.Domain, Action: SkipAction, Reason: reason}
+
+		case item.Action == UpdateAction && item.New.Severity != SeveritySuspend:
+			deleted := *item.Old
+			deleted.UpdatedAt = now
+			item = PlanItem{Domain: item.Domain, Action: DeleteAction, Old: &deleted, Reason: reason}
+
+		case item.Action == UpdateAction:
+			updated := *item.New
+			updated.UpdatedAt = item.Old.UpdatedAt
+			if updated == *item.Old {
+				continue
+			}
+		}
+		items = append(items, item)
+	}
+	return Plan{Items: items}
+}
+
+func (plan Plan) WriteText(w io.Writer) error {
+	if len(plan.Items) <= 0 {
+		_, err := fmt.Fprintln(w, "no changes")
+		return err
+	}
+
+	var showImpact bool
+	for _, item := range plan.Items {
+		showImpact = showImpact || item.Impact != nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "ACTION\tDOMAIN\tOLD SEVERITY\tOLD PUBLIC COMMENT\tNEW SEVERITY\tNEW PUBLIC COMMENT\tREASON")
+	if showImpact {
+		fmt.Fprint(tw, "\tREMOTE ACCOUNTS\tLOCAL USERS")
+	}
+	fmt.Fprintln(tw)
+	for _, item := range plan.Items {
+		oldSeverity, oldComment := "-", "-"
+		if item.Old != nil {
+			oldSeverity = item.Old.Severity.String()
+			oldComment = planTextCell(item.Old.PublicComment)
+		}
+		newSeverity, newComment := "-", "-"
+		if item.New != nil {
+			newSeverity = item.New.Severity.String()
+			newComment = planTextCell(item.New.PublicComment)
+		}
+		reason := "-"
+		if item.Reason != "" {
+			reason = planTextCell(item.Reason)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s", item.Action, item.Domain, oldSeverity, oldComment, newSeverity, newComment, reason)
+		switch {
+		case !showImpact:
+		case item.Impact != nil:
+			fmt.Fprintf(tw, "\t%d\t%d", item.Impact.RemoteAccounts, item.Impact.LocalUsers)
+		default:
+			fmt.Fprint(tw, "\t-\t-")
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func planTextCell(str string) string {
+	str = reSpace.ReplaceAllLiteralString(str, " ")
+	if str == "" {
+		return `""`
+	}
+	return str
+}
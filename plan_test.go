@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestManagedBlock(domain string, reason string, createdAt time.Time) MastodonDomainBlock {
+	return MastodonDomainBlock{
+		Domain:         domain,
+		PrivateComment: WellKnownPrivateComment,
+		PublicComment:  reason,
+		CreatedAt:      createdAt,
+		UpdatedAt:      createdAt,
+		Severity:       SeveritySuspend,
+	}
+}
+
+func TestPlanMastodon(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	existingBlocks := map[string]MastodonDomainBlock{
+		"same.example":   newTestManagedBlock("same.example", "spam", created),
+		"reason.example": newTestManagedBlock("reason.example", "old reason", created),
+		"stale.example":  newTestManagedBlock("stale.example", "spam", created),
+		"kept.example":   newTestManagedBlock("kept.example", "spam", created),
+		"local.example":  {Domain: "local.example", PrivateComment: "blocked by hand", Severity: SeveritySilence},
+		"lifted.example": {Domain: "lifted.example", PrivateComment: "blocked by hand", Severity: SeveritySilence},
+	}
+	file := BlockFile{
+		Blocks: map[string]Block{
+			"new.example":    {IsBlocked: true, Reason: "harassment"},
+			"same.example":   {IsBlocked: true, Reason: "spam"},
+			"reason.example": {IsBlocked: true, Reason: "new reason"},
+			"stale.example":  {IsBlocked: false},
+			"never.example":  {IsBlocked: false},
+			"local.example":  {IsBlocked: true, Reason: "spam"},
+			"lifted.example": {IsBlocked: false},
+		},
+	}
+
+	type wantItem struct {
+		domain string
+		action PlanAction
+		reason string
+	}
+	want := []wantItem{
+		{domain: "local.example", action: SkipAction, reason: SkipReasonLocalDecision},
+		{domain: "new.example", action: InsertAction},
+		{domain: "reason.example", action: UpdateAction},
+		{domain: "stale.example", action: DeleteAction},
+	}
+
+	plan := PlanMastodon(file, DefaultPolicy(), Overrides{}, existingBlocks, now)
+	var got []wantItem
+	for _, item := range plan.Items {
+		got = append(got, wantItem{domain: item.Domain, action: item.Action, reason: item.Reason})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("PlanMastodon:\n\tgot  %+v\n\twant %+v", got, want)
+	}
+	if totals := plan.Totals(); totals != (PlanTotals{Inserted: 1, Updated: 1, Deleted: 1, Skipped: 1}) {
+		t.Errorf("Totals: unexpected %+v", totals)
+	}
+
+	inserted := plan.ItemsWithAction(InsertAction)[0].New
+	wantInserted := MastodonDomainBlock{
+		Domain:         "new.example",
+		PrivateComment: WellKnownPrivateComment,
+		PublicComment:  "harassment",
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Severity:       SeveritySuspend,
+	}
+	if *inserted != wantInserted {
+		t.Errorf("insert:\n\tgot  %+v\n\twant %+v", *inserted, wantInserted)
+	}
+
+	updated := plan.ItemsWithAction(UpdateAction)[0]
+	if updated.Old.PublicComment != "old reason" || updated.New.PublicComment != "new reason" {
+		t.Errorf("update: expected the public comment to change from %q to %q, got %+v", "old reason", "new reason", updated)
+	}
+	if !updated.New.CreatedAt.Equal(created) || !updated.New.UpdatedAt.Equal(now) {
+		t.Errorf("update: expected created_at to be kept and updated_at to be now, got %+v", *updated.New)
+	}
+
+	deleted := plan.ItemsWithAction(DeleteAction)[0]
+	if deleted.New != nil || deleted.Old.Domain != "stale.example" {
+		t.Errorf("delete: unexpected item %+v", deleted)
+	}
+}
+
+func TestPlanSort(t *testing.T) {
+	plan := Plan{Items: []PlanItem{
+		{Domain: "b.example"},
+		{Domain: "a.other.example"},
+		{Domain: "a.example"},
+		{Domain: "example"},
+	}}
+	plan.Sort()
+	var got []string
+	for _, item := range plan.Items {
+		got = append(got, item.Domain)
+	}
+	want := []string{"example", "a.example", "b.example", "a.other.example"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Sort: expected %q, got %q", want, got)
+	}
+}
+
+func TestPlanWriteText(t *testing.T) {
+	old := MastodonDomainBlock{Domain: "update.example", PrivateComment: WellKnownPrivateComment, PublicComment: "old\nreason", Severity: SeveritySilence}
+	updated := old
+	updated.PublicComment = "new reason"
+	updated.Severity = SeveritySuspend
+	inserted := MastodonDomainBlock{Domain: "new.example", PrivateComment: WellKnownPrivateComment, Severity: SeveritySuspend}
+
+	type testCase struct {
+		name string
+		plan Plan
+		want string
+	}
+	testCases := []testCase{
+		{
+			name: "empty",
+			want: "no changes\n",
+		},
+		{
+			name: "changes",
+			plan: Plan{Items: []PlanItem{
+				{Domain: "new.example", Action: InsertAction, New: &inserted},
+				{Domain: "update.example", Action: UpdateAction, Old: &old, New: &updated},
+				{Domain: "local.example", Action: SkipAction, Reason: SkipReasonLocalDecision},
+			}},
+			want: "" +
+				"ACTION  DOMAIN          OLD SEVERITY  OLD PUBLIC COMMENT  NEW SEVERITY  NEW PUBLIC COMMENT  REASON\n" +
+				"insert  new.example     -             -                   suspend       \"\"                  -\n" +
+				"update  update.example  silence       old reason          suspend       new reason          -\n" +
+				"skip    local.example   -             -                   -             -                   local admin decision\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := tc.plan.WriteText(&buf)
+			if err != nil {
+				t.Fatalf("WriteText: %v", err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Errorf("WriteText:\n%s\nwant:\n%s", strings.TrimSuffix(got, "\n"), strings.TrimSuffix(tc.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+)
+
+// Pleroma and Akkoma keep their MRF SimplePolicy settings as a single row
+// of the config table, whose value is an Erlang keyword list encoded with
+// :erlang.term_to_binary/1.  Each list of interest holds entries of the
+// form {"domain", "reason"}.  Entries that RapidBlock manages are marked
+// by a reason that begins with PleromaManagedMarker; every other entry
+// belongs to the local admins and is left untouched.
+const (
+	PleromaConfigGroup   = ":pleroma"
+	PleromaConfigKey     = ":mrf_simple"
+	PleromaManagedMarker = "RapidBlock"
+
+	PleromaReject                   = ErlAtom("reject")
+	PleromaFederatedTimelineRemoval = ErlAtom("federated_timeline_removal")
+	PleromaMediaRemoval             = ErlAtom("media_removal")
+	PleromaReportRemoval            = ErlAtom("report_removal")
+)
+
+// SkipReasonPleromaNoOp explains a block that has no effect under MRF
+// SimplePolicy, such as a severity of noop without reject_media, and so
+// cannot be stored.
+const SkipReasonPleromaNoOp = "block without effect not supported by pleroma"
+
+var pleromaManagedLists = [...]ErlAtom{
+	PleromaReject,
+	PleromaFederatedTimelineRemoval,
+	PleromaMediaRemoval,
+	PleromaReportRemoval,
+}
+
+const SQLSelectConfigPleroma = `
+SELECT id, value FROM public.config WHERE "group" = $1 AND key = $2 FOR UPDATE
+`
+
+const SQLInsertConfigPleroma = `
+INSERT INTO public.config
+	("group", key, value, inserted_at, updated_at)
+VALUES
+	($1, $2, $3, $4, $5)
+`
+
+const SQLUpdateConfigPleroma = `
+UPDATE public.config SET value = $2, updated_at = $3 WHERE id = $1
+`
+
+// PleromaMRFSimple is the decoded value of the :mrf_simple config row.
+type PleromaMRFSimple struct {
+	ID       uint64
+	Exists   bool
+	Keywords ErlList
+}
+
+func ApplyPleroma(ctx context.Context, file BlockFile, opts ApplyOptions) (Plan, error) {
+	conn, err := pgx.Connect(ctx, opts.DatabaseURL)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to Begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	cfg, err := GetPleromaMRFSimple(ctx, tx)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	existingBlocks, err := cfg.DomainBlocks()
+	if err != nil {
+		return Plan{}, err
+	}
+
+	now := time.Now().UTC()
+	plan := PlanPleroma(file, opts.Policy, opts.Overrides, existingBlocks, now)
+	if opts.DryRun {
+		return plan, nil
+	}
+
+	if !opts.Force {
+		err = opts.Limits.Check(plan.Totals(), CountManagedMastodonBlocks(existingBlocks))
+		if err != nil {
+			return plan, err
+		}
+	}
+
+	totals := plan.Totals()
+	if totals.Inserted+totals.Updated+totals.Deleted == 0 {
+		return plan, nil
+	}
+
+	err = cfg.ApplyPlan(plan)
+	if err != nil {
+		return plan, err
+	}
+
+	err = cfg.Save(ctx, tx, now)
+	if err != nil {
+		return plan, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return plan, fmt.Errorf("failed to Commit transaction: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "info: %s reads MRF settings from the database at startup; restart it for these changes to take effect\n", opts.Software)
+	return plan, nil
+}
+
+// PlanPleroma is PlanMastodon, adjusted for what MRF SimplePolicy can
+// express: there is no equivalent of obfuscation, and a block that neither
+// rejects, silences, nor removes anything cannot be stored at all, so it is
+// skipped.
+func PlanPleroma(file BlockFile, policy Policy, overrides Overrides, existingBlocks map[string]MastodonDomainBlock, now time.Time) Plan {
+	plan := PlanMastodon(file, policy, overrides, existingBlocks, now)
+
+	items := plan.Items[:0]
+	for _, item := range plan.Items {
+		if item.New != nil {
+			updated := *item.New
+			updated.Obfuscate = false
+			item.New = &updated
+		}
+
+		switch item.Action {
+		case InsertAction:
+			if !pleromaHasEffect(*item.New) {
+				item = PlanItem{Domain: item.Domain, Action: SkipAction, Reason: SkipReasonPleromaNoOp}
+			}
+
+		case UpdateAction:
+			updated := *item.New
+			updated.UpdatedAt = item.Old.UpdatedAt
+			if updated == *item.Old {
+				continue
+			}
+		}
+		items = append(items, item)
+	}
+	plan.Items = items
+	return plan
+}
+
+func GetPleromaMRFSimple(ctx context.Context, tx pgx.Tx) (PleromaMRFSimple, error) {
+	const sql = SQLSelectConfigPleroma
+
+	var cfg PleromaMRFSimple
+	var raw []byte
+	err := tx.QueryRow(ctx, sql, PleromaConfigGroup, PleromaConfigKey).Scan(&cfg.ID, &raw)
+	switch {
+	case err == pgx.ErrNoRows:
+		return cfg, nil
+	case err != nil:
+		return cfg, fmt.Errorf("failed to QueryRow %q: %w", sql, err)
+	}
+
+	cfg.Exists = true
+	term, err := DecodeErlTerm(raw)
+	if err != nil {
+		return cfg, fmt.Errorf("config %s %s: %w", PleromaConfigGroup, PleromaConfigKey, err)
+	}
+
+	switch t := term.(type) {
+	case ErlList:
+		cfg.Keywords = t
+	default:
+		return cfg, fmt.Errorf("config %s %s: expected a keyword list, got %T", PleromaConfigGroup, PleromaConfigKey, term)
+	}
+	return cfg, nil
+}
+
+// DomainBlocks describes the MRF SimplePolicy lists as the equivalent
+// Mastodon domain blocks, so that they can be planned the same way.  A
+// domain is considered managed by RapidBlock only if every entry for it is
+// marked as such.
+func (cfg PleromaMRFSimple) DomainBlocks() (map[string]MastodonDomainBlock, error) {
+	type domainInfo struct {
+		lists     map[ErlAtom]bool
+		reason    string
+		isManaged bool
+	}
+
+	byDomain := make(map[string]*domainInfo, 1024)
+	for _, name := range pleromaManagedLists {
+		entries, err := cfg.list(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			domain, reason, err := pleromaParseEntry(entry)
+			if err != nil {
+				return nil, fmt.Errorf("config %s %s: %s: %w", PleromaConfigGroup, PleromaConfigKey, name, err)
+			}
+			reason, isManaged := pleromaUnmarkReason(reason)
+
+			info := byDomain[domain]
+			if info == nil {
+				info = &domainInfo{lists: make(map[ErlAtom]bool, len(pleromaManagedLists)), reason: reason, isManaged: true}
+				byDomain[domain] = info
+			}
+			info.lists[name] = true
+			info.isManaged = info.isManaged && isManaged
+		}
+	}
+
+	out := make(map[string]MastodonDomainBlock, len(byDomain))
+	for domain, info := range byDomain {
+		block := MastodonDomainBlock{
+			Domain:        domain,
+			PublicComment: info.reason,
+			Severity:      SeverityNoOp,
+			RejectMedia:   info.lists[PleromaMediaRemoval],
+			RejectReports: info.lists[PleromaReportRemoval],
+		}
+		switch {
+		case info.lists[PleromaReject]:
+			block.Severity = SeveritySuspend
+		case info.lists[PleromaFederatedTimelineRemoval]:
+			block.Severity = SeveritySilence
+		}
+		if info.isManaged {
+			block.PrivateComment = WellKnownPrivateComment
+		}
+		out[domain] = block
+	}
+	return out, nil
+}
+
+// ApplyPlan rewrites the managed entries for each domain in plan.  Entries
+// for other domains, entries owned by the local admins, and every other
+// MRF SimplePolicy setting are preserved as-is.
+func (cfg *PleromaMRFSimple) ApplyPlan(plan Plan) error {
+	touched := make(map[string]struct{}, len(plan.Items))
+	for _, item := range plan.Items {
+		switch item.Action {
+		case InsertAction, UpdateAction, DeleteAction:
+			touched[item.Domain] = struct{}{}
+		}
+	}
+
+	for _, name := range pleromaManagedLists {
+		entries, err := cfg.list(name)
+		if err != nil {
+			return err
+		}
+
+		kept := make([]ErlTerm, 0, len(entries))
+		for _, entry := range entries {
+			domain, reason, err := pleromaParseEntry(entry)
+			if err != nil {
+				return fmt.Errorf("config %s %s: %s: %w", PleromaConfigGroup, PleromaConfigKey, name, err)
+			}
+			_, isTouched := touched[domain]
+			_, isManaged := pleromaUnmarkReason(reason)
+			if isTouched && isManaged {
+				continue
+			}
+			kept = append(kept, entry)
+		}
+
+		for _, item := range plan.Items {
+			if item.Action != InsertAction && item.Action != UpdateAction {
+				continue
+			}
+			if pleromaInList(*item.New, name) {
+				kept = append(kept, pleromaMakeEntry(*item.New))
+			}
+		}
+
+		cfg.setList(name, kept)
+	}
+	return nil
+}
+
+func (cfg PleromaMRFSimple) Save(ctx context.Context, tx pgx.Tx, now time.Time) error {
+	raw, err := EncodeErlTerm(cfg.Keywords)
+	if err != nil {
+		return fmt.Errorf("config %s %s: %w", PleromaConfigGroup, PleromaConfigKey, err)
+	}
+
+	if cfg.Exists {
+		const sql = SQLUpdateConfigPleroma
+		_, err = tx.Exec(ctx, sql, cfg.ID, raw, now)
+		if err != nil {
+			return fmt.Errorf("failed to Exec %q: %w", sql, err)
+		}
+		return nil
+	}
+
+	const sql = SQLInsertConfigPleroma
+	_, err = tx.Exec(ctx, sql, PleromaConfigGroup, PleromaConfigKey, raw, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to Exec %q: %w", sql, err)
+	}
+	return nil
+}
+
+func (cfg PleromaMRFSimple) list(name ErlAtom) ([]ErlTerm, error) {
+	for _, elem := range cfg.Keywords.Elements {
+		key, value, isKeyword := pleromaKeyword(elem)
+		if !isKeyword || key != name {
+			continue
+		}
+		switch v := value.(type) {
+		case ErlList:
+			return v.Elements, nil
+		default:
+			return nil, fmt.Errorf("config %s %s: %s: expected a list, got %T", PleromaConfigGroup, PleromaConfigKey, name, value)
+		}
+	}
+	return nil, nil
+}
+
+func (cfg *PleromaMRFSimple) setList(name ErlAtom, entries []ErlTerm) {
+	value := ErlList{Elements: entries}
+	for i, elem := range cfg.Keywords.Elements {
+		if key, _, isKeyword := pleromaKeyword(elem); isKeyword && key == name {
+			cfg.Keywords.Elements[i] = ErlTuple{name, value}
+			return
+		}
+	}
+	if len(entries) > 0 {
+		cfg.Keywords.Elements = append(cfg.Keywords.Elements, ErlTuple{name, value})
+	}
+}
+
+func pleromaKeyword(term ErlTerm) (ErlAtom, ErlTerm, bool) {
+	tuple, ok := term.(ErlTuple)
+	if !ok || len(tuple) != 2 {
+		return "", nil, false
+	}
+	key, ok := tuple[0].(ErlAtom)
+	return key, tuple[1], ok
+}
+
+// pleromaParseEntry accepts both the {"domain", "reason"} entries of
+// Pleroma 2.3 and later, and the bare "domain" entries of older versions.
+func pleromaParseEntry(term ErlTerm) (domain string, reason string, err error) {
+	if tuple, ok := term.(ErlTuple); ok && len(tuple) == 2 {
+		domain, err = pleromaString(tuple[0])
+		if err == nil {
+			reason, err = pleromaString(tuple[1])
+		}
+		return domain, reason, err
+	}
+	domain, err = pleromaString(term)
+	return domain, "", err
+}
+
+func pleromaString(term ErlTerm) (string, error) {
+	switch t := term.(type) {
+	case ErlBinary:
+		return string(t), nil
+	case ErlString:
+		return string(t), nil
+	default:
+		return "", fmt.Errorf("expected a string, got %T", term)
+	}
+}
+
+func pleromaMakeEntry(block MastodonDomainBlock) ErlTerm {
+	reason := block.PublicComment
+	if block.PrivateComment == WellKnownPrivateComment {
+		reason = pleromaMarkReason(reason)
+	}
+	return ErlTuple{ErlBinary(block.Domain), ErlBinary(reason)}
+}
+
+func pleromaMarkReason(reason string) string {
+	if reason == "" {
+		return PleromaManagedMarker
+	}
+	return PleromaManagedMarker + ": " + reason
+}
+
+func pleromaUnmarkReason(reason string) (string, bool) {
+	if reason == PleromaManagedMarker {
+		return "", true
+	}
+	if rest := strings.TrimPrefix(reason, PleromaManagedMarker+": "); rest != reason {
+		return rest, true
+	}
+	return reason, false
+}
+
+func pleromaInList(block MastodonDomainBlock, name ErlAtom) bool {
+	switch name {
+	case PleromaReject:
+		return block.Severity == SeveritySuspend
+	case PleromaFederatedTimelineRemoval:
+		return block.Severity == SeveritySilence
+	case PleromaMediaRemoval:
+		return block.RejectMedia
+	case PleromaReportRemoval:
+		return block.RejectReports
+	default:
+		return false
+	}
+}
+
+func pleromaHasEffect(block MastodonDomainBlock) bool {
+	for _, name := range pleromaManagedLists {
+		if pleromaInList(block, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// pleromaTestConfigOTP26 is :erlang.term_to_binary/1 on OTP 26 of:
+//
+//	[reject: [{"local.example", "blocked by hand"},
+//	          {"stale.example", "RapidBlock: old"},
+//	          {"update.example", "RapidBlock: old reason"}],
+//	 media_removal: ["legacy.example"],
+//	 federated_timeline_removal: [],
+//	 accept: [],
+//	 reject_deletes: [{"gone.example", ""}]]
+const pleromaTestConfigOTP26 = "" +
+	"836c00000005" +
+	"6802770672656a6563746c0000000368026d0000000d6c6f63616c2e6578616d706c656d0000000f626c6f636b65642062792068616e6468026d0000000d7374616c652e6578616d706c656d0000000f5261706964426c6f636b3a206f6c6468026d0000000e7570646174652e6578616d706c656d000000165261706964426c6f636b3a206f6c6420726561736f6e6a" +
+	"6802770d6d656469615f72656d6f76616c6c000000016d0000000e6c65676163792e6578616d706c656a" +
+	"6802771a6665646572617465645f74696d656c696e655f72656d6f76616c6a" +
+	"680277066163636570746a" +
+	"6802770e72656a6563745f64656c657465736c0000000168026d0000000c676f6e652e6578616d706c656d000000006a" +
+	"6a"
+
+// pleromaTestConfigOTP25 is the same term, as written by OTP 25 and
+// earlier, which encode atoms as ATOM_EXT.
+const pleromaTestConfigOTP25 = "" +
+	"836c00000005" +
+	"680264000672656a6563746c0000000368026d0000000d6c6f63616c2e6578616d706c656d0000000f626c6f636b65642062792068616e6468026d0000000d7374616c652e6578616d706c656d0000000f5261706964426c6f636b3a206f6c6468026d0000000e7570646174652e6578616d706c656d000000165261706964426c6f636b3a206f6c6420726561736f6e6a" +
+	"680264000d6d656469615f72656d6f76616c6c000000016d0000000e6c65676163792e6578616d706c656a" +
+	"680264001a6665646572617465645f74696d656c696e655f72656d6f76616c6a" +
+	"68026400066163636570746a" +
+	"680264000e72656a6563745f64656c657465736c0000000168026d0000000c676f6e652e6578616d706c656d000000006a" +
+	"6a"
+
+// pleromaTestConfigApplied is pleromaTestConfigOTP26 after applying the
+// plan in TestPleromaMRFSimpleApplyPlan:
+//
+//	[reject: [{"local.example", "blocked by hand"},
+//	          {"new.example", "RapidBlock: harassment"},
+//	          {"update.example", "RapidBlock: new reason"}],
+//	 ...]
+const pleromaTestConfigApplied = "" +
+	"836c00000005" +
+	"6802770672656a6563746c0000000368026d0000000d6c6f63616c2e6578616d706c656d0000000f626c6f636b65642062792068616e6468026d0000000b6e65772e6578616d706c656d000000165261706964426c6f636b3a206861726173736d656e7468026d0000000e7570646174652e6578616d706c656d000000165261706964426c6f636b3a206e657720726561736f6e6a" +
+	"6802770d6d656469615f72656d6f76616c6c000000016d0000000e6c65676163792e6578616d706c656a" +
+	"6802771a6665646572617465645f74696d656c696e655f72656d6f76616c6a" +
+	"680277066163636570746a" +
+	"6802770e72656a6563745f64656c657465736c0000000168026d0000000c676f6e652e6578616d706c656d000000006a" +
+	"6a"
+
+func newTestPleromaMRFSimple(t *testing.T, fixture string) PleromaMRFSimple {
+	t.Helper()
+	term, err := DecodeErlTerm(decodeTestHex(t, fixture))
+	if err != nil {
+		t.Fatalf("DecodeErlTerm: %v", err)
+	}
+	keywords, ok := term.(ErlList)
+	if !ok {
+		t.Fatalf("DecodeErlTerm: expected a keyword list, got %T", term)
+	}
+	return PleromaMRFSimple{ID: 1, Exists: true, Keywords: keywords}
+}
+
+func TestPleromaMRFSimpleDomainBlocks(t *testing.T) {
+	want := map[string]MastodonDomainBlock{
+		"local.example":  {Domain: "local.example", PublicComment: "blocked by hand", Severity: SeveritySuspend},
+		"stale.example":  {Domain: "stale.example", PrivateComment: WellKnownPrivateComment, PublicComment: "old", Severity: SeveritySuspend},
+		"update.example": {Domain: "update.example", PrivateComment: WellKnownPrivateComment, PublicComment: "old reason", Severity: SeveritySuspend},
+		"legacy.example": {Domain: "legacy.example", Severity: SeverityNoOp, RejectMedia: true},
+	}
+	for _, fixture := range []struct {
+		name string
+		raw  string
+	}{
+		{"otp26", pleromaTestConfigOTP26},
+		{"otp25", pleromaTestConfigOTP25},
+	} {
+		t.Run(fixture.name, func(t *testing.T) {
+			cfg := newTestPleromaMRFSimple(t, fixture.raw)
+			got, err := cfg.DomainBlocks()
+			if err != nil {
+				t.Fatalf("DomainBlocks: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("DomainBlocks:\n\tgot  %+v\n\twant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestPleromaMRFSimpleApplyPlan(t *testing.T) {
+	cfg := newTestPleromaMRFSimple(t, pleromaTestConfigOTP26)
+	existingBlocks, err := cfg.DomainBlocks()
+	if err != nil {
+		t.Fatalf("DomainBlocks: %v", err)
+	}
+
+	file := BlockFile{
+		Blocks: map[string]Block{
+			"new.example":    {IsBlocked: true, Reason: "harassment"},
+			"update.example": {IsBlocked: true, Reason: "new reason"},
+			"local.example":  {IsBlocked: true, Reason: "upstream reason"},
+			"legacy.example": {IsBlocked: false},
+			"stale.example":  {IsBlocked: false},
+		},
+	}
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	plan := PlanPleroma(file, DefaultPolicy(), Overrides{}, existingBlocks, now)
+	if totals := plan.Totals(); totals.Inserted != 1 || totals.Updated != 1 || totals.Deleted != 1 {
+		t.Errorf("Totals: expected 1 inserted, 1 updated, 1 deleted, got %+v", totals)
+	}
+
+	err = cfg.ApplyPlan(plan)
+	if err != nil {
+		t.Fatalf("ApplyPlan: %v", err)
+	}
+	raw, err := EncodeErlTerm(cfg.Keywords)
+	if err != nil {
+		t.Fatalf("EncodeErlTerm: %v", err)
+	}
+	if got := hex.EncodeToString(raw); got != pleromaTestConfigApplied {
+		t.Errorf("ApplyPlan:\n\tgot  %s\n\twant %s", got, pleromaTestConfigApplied)
+	}
+
+	existingBlocks, err = cfg.DomainBlocks()
+	if err != nil {
+		t.Fatalf("DomainBlocks: %v", err)
+	}
+	plan = PlanPleroma(file, DefaultPolicy(), Overrides{}, existingBlocks, now)
+	if totals := plan.Totals(); totals.Inserted+totals.Updated+totals.Deleted != 0 {
+		t.Errorf("Totals: second plan should have nothing to do, got %+v", totals)
+	}
+}
+
+func TestPleromaMRFSimpleApplyPlanNewConfig(t *testing.T) {
+	var cfg PleromaMRFSimple
+	file := BlockFile{Blocks: map[string]Block{"new.example": {IsBlocked: true}}}
+	plan := PlanPleroma(file, DefaultPolicy(), Overrides{}, nil, time.Now())
+	err := cfg.ApplyPlan(plan)
+	if err != nil {
+		t.Fatalf("ApplyPlan: %v", err)
+	}
+	want := ErlList{Elements: []ErlTerm{
+		ErlTuple{PleromaReject, ErlList{Elements: []ErlTerm{ErlTuple{ErlBinary("new.example"), ErlBinary(PleromaManagedMarker)}}}},
+	}}
+	if !reflect.DeepEqual(cfg.Keywords, want) {
+		t.Errorf("ApplyPlan:\n\tgot  %#v\n\twant %#v", cfg.Keywords, want)
+	}
+}
+
+func TestPlanPleromaNoOp(t *testing.T) {
+	file := BlockFile{Blocks: map[string]Block{
+		"noop.example":  {IsBlocked: true},
+		"media.example": {IsBlocked: true, Tags: []string{"media"}},
+	}}
+	policy := Policy{
+		Default: PolicyAction{Severity: SeverityNoOp},
+		Rules:   []PolicyRule{{Tags: []string{"media"}, PolicyAction: PolicyAction{Severity: SeverityNoOp, RejectMedia: true}}},
+	}
+	plan := PlanPleroma(file, policy, Overrides{}, nil, time.Now())
+	if totals := plan.Totals(); totals.Inserted != 1 || totals.Skipped != 1 {
+		t.Errorf("Totals: expected 1 inserted, 1 skipped, got %+v", totals)
+	}
+	for _, item := range plan.Items {
+		if item.Domain == "noop.example" && (item.Action != SkipAction || item.Reason != SkipReasonPleromaNoOp) {
+			t.Errorf("noop.example: expected to be skipped with reason %q, got %+v", SkipReasonPleromaNoOp, item)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Policy maps the tags carried on each Block to the kind of domain block
+// that should be created for it.  Rules are consulted in order, and the
+// first rule that shares a tag with the block wins; if no rule matches,
+// Default applies.
+type Policy struct {
+	Default PolicyAction `yaml:"default"`
+	Rules   []PolicyRule `yaml:"rules"`
+}
+
+type PolicyRule struct {
+	Tags         []string `yaml:"tags"`
+	PolicyAction `yaml:",inline"`
+}
+
+type PolicyAction struct {
+	Severity      Severity `yaml:"severity"`
+	RejectMedia   bool     `yaml:"reject_media"`
+	RejectReports bool     `yaml:"reject_reports"`
+	Obfuscate     bool     `yaml:"obfuscate"`
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		Default: PolicyAction{Severity: SeveritySuspend},
+	}
+}
+
+func LoadPolicyFile(filePath string) (Policy, error) {
+	policy := DefaultPolicy()
+	err := LoadYamlFile(&policy, filePath)
+	return policy, err
+}
+
+func (policy Policy) ActionFor(block Block) PolicyAction {
+	for _, rule := range policy.Rules {
+		if rule.Matches(block.Tags) {
+			return rule.PolicyAction
+		}
+	}
+	return policy.Default
+}
+
+func (rule PolicyRule) Matches(tags []string) bool {
+	for _, want := range rule.Tags {
+		for _, have := range tags {
+			if strings.EqualFold(want, have) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (rule *PolicyRule) UnmarshalYAML(node *yaml.Node) error {
+	type rawPolicyRule PolicyRule
+	raw := rawPolicyRule{PolicyAction: PolicyAction{Severity: SeveritySuspend}}
+	err := node.Decode(&raw)
+	if err != nil {
+		return err
+	}
+	*rule = PolicyRule(raw)
+	return nil
+}
+
+func (action PolicyAction) ApplyTo(block *MastodonDomainBlock) {
+	block.Severity = action.Severity
+	block.RejectMedia = action.RejectMedia
+	block.RejectReports = action.RejectReports
+	block.Obfuscate = action.Obfuscate
+}
+
+var _ yaml.Unmarshaler = (*PolicyRule)(nil)
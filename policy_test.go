@@ -0,0 +1,113 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const policyTestYAML = `
+default:
+  severity: silence
+rules:
+  - tags: ["spam"]
+    severity: silence
+    reject_media: true
+  - tags: ["harassment", "spam"]
+    obfuscate: true
+  - tags: ["informational"]
+    severity: noop
+    reject_reports: true
+`
+
+func TestLoadPolicyFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "policy.yaml")
+	writeTestFile(t, filePath, []byte(policyTestYAML))
+	policy, err := LoadPolicyFile(filePath)
+	if err != nil {
+		t.Fatalf("LoadPolicyFile: %v", err)
+	}
+	want := Policy{
+		Default: PolicyAction{Severity: SeveritySilence},
+		Rules: []PolicyRule{
+			{Tags: []string{"spam"}, PolicyAction: PolicyAction{Severity: SeveritySilence, RejectMedia: true}},
+			{Tags: []string{"harassment", "spam"}, PolicyAction: PolicyAction{Severity: SeveritySuspend, Obfuscate: true}},
+			{Tags: []string{"informational"}, PolicyAction: PolicyAction{Severity: SeverityNoOp, RejectReports: true}},
+		},
+	}
+	if !reflect.DeepEqual(policy, want) {
+		t.Errorf("LoadPolicyFile:\n\tgot  %+v\n\twant %+v", policy, want)
+	}
+
+	writeTestFile(t, filePath, []byte("default:\n  severity: obliterate\n"))
+	if _, err = LoadPolicyFile(filePath); err == nil {
+		t.Errorf("LoadPolicyFile: expected an error for an unknown severity")
+	}
+}
+
+func TestPolicyActionFor(t *testing.T) {
+	policy := Policy{
+		Default: PolicyAction{Severity: SeveritySuspend},
+		Rules: []PolicyRule{
+			{Tags: []string{"spam"}, PolicyAction: PolicyAction{Severity: SeveritySilence, RejectMedia: true}},
+			{Tags: []string{"harassment", "spam"}, PolicyAction: PolicyAction{Severity: SeveritySuspend, Obfuscate: true}},
+		},
+	}
+
+	type testCase struct {
+		name string
+		tags []string
+		want PolicyAction
+	}
+	testCases := []testCase{
+		{name: "untagged", want: policy.Default},
+		{name: "unmatched", tags: []string{"other"}, want: policy.Default},
+		{name: "first-rule", tags: []string{"spam"}, want: policy.Rules[0].PolicyAction},
+		{name: "second-rule", tags: []string{"harassment"}, want: policy.Rules[1].PolicyAction},
+		{name: "first-rule-wins", tags: []string{"harassment", "spam"}, want: policy.Rules[0].PolicyAction},
+		{name: "case-insensitive", tags: []string{"Harassment"}, want: policy.Rules[1].PolicyAction},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := policy.ActionFor(Block{IsBlocked: true, Tags: tc.tags}); got != tc.want {
+				t.Errorf("ActionFor(%q): expected %+v, got %+v", tc.tags, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPlanMastodonPolicy(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	policy := Policy{
+		Default: PolicyAction{Severity: SeveritySuspend},
+		Rules:   []PolicyRule{{Tags: []string{"spam"}, PolicyAction: PolicyAction{Severity: SeveritySilence, RejectMedia: true}}},
+	}
+	existingBlocks := map[string]MastodonDomainBlock{
+		"retagged.example": newTestManagedBlock("retagged.example", "spam", created),
+	}
+	file := BlockFile{Blocks: map[string]Block{
+		"new.example":      {IsBlocked: true, Reason: "spam", Tags: []string{"spam"}},
+		"retagged.example": {IsBlocked: true, Reason: "spam", Tags: []string{"spam"}},
+	}}
+
+	plan := PlanMastodon(file, policy, Overrides{}, existingBlocks, now)
+	if totals := plan.Totals(); totals != (PlanTotals{Inserted: 1, Updated: 1}) {
+		t.Fatalf("Totals: expected 1 inserted and 1 updated, got %+v", totals)
+	}
+	for _, item := range plan.Items {
+		if item.New.Severity != SeveritySilence || !item.New.RejectMedia {
+			t.Errorf("%s: expected the spam rule to apply on %s, got %+v", item.Domain, item.Action, *item.New)
+		}
+	}
+
+	// Once applied, the same policy has nothing more to do.
+	for _, item := range plan.Items {
+		existingBlocks[item.Domain] = *item.New
+	}
+	plan = PlanMastodon(file, policy, Overrides{}, existingBlocks, now)
+	if len(plan.Items) != 0 {
+		t.Errorf("PlanMastodon: expected no changes on the second run, got %+v", plan.Items)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Just enough of the Redis protocol (RESP) to push Sidekiq jobs.  The URL
+// forms are the ones Mastodon accepts in REDIS_URL:
+//
+//	redis://[[user]:password@]host[:port][/db]
+//	rediss://[[user]:password@]host[:port][/db]
+//	unix:///path/to/redis.sock[?db=N]
+const (
+	RedisDefaultPort   = "6379"
+	redisDialTimeout   = 10 * time.Second
+	redisMaxBulkLength = 512 << 20 // 512 MiB, the Redis maximum
+)
+
+// RedisError is an error reply from the server.
+type RedisError string
+
+func (err RedisError) Error() string {
+	return string(err)
+}
+
+type RedisClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func DialRedis(ctx context.Context, rawURL string) (*RedisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
+	}
+
+	var network, address string
+	var db string
+	switch u.Scheme {
+	case "redis", "rediss":
+		network = "tcp"
+		address = u.Host
+		if u.Port() == "" {
+			address = net.JoinHostPort(u.Hostname(), RedisDefaultPort)
+		}
+		db = strings.Trim(u.Path, "/")
+	case "unix":
+		network = "unix"
+		address = u.Path
+		db = u.Query().Get("db")
+	default:
+		return nil, fmt.Errorf("Redis URL %q: expected a redis, rediss, or unix URL", u.Redacted())
+	}
+
+	dialer := net.Dialer{Timeout: redisDialTimeout}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	if u.Scheme == "rediss" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("failed to connect to Redis: TLS handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	c := &RedisClient{conn: conn, r: bufio.NewReader(conn)}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if password, hasPassword := u.User.Password(); hasPassword {
+		if user := u.User.Username(); user != "" {
+			_, err = c.Do("AUTH", user, password)
+		} else {
+			_, err = c.Do("AUTH", password)
+		}
+		if err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("failed to authenticate to Redis: %w", err)
+		}
+	}
+
+	if db != "" && db != "0" {
+		_, err = c.Do("SELECT", db)
+		if err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("failed to select Redis database %q: %w", db, err)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *RedisClient) Close() error {
+	return c.conn.Close()
+}
+
+// Do sends one command and returns its reply, which is a string, an int64,
+// nil, or a []any of those.
+func (c *RedisClient) Do(args ...string) (any, error) {
+	var sb strings.Builder
+	sb.WriteString("*")
+	sb.WriteString(strconv.Itoa(len(args)))
+	sb.WriteString("\r\n")
+	for _, arg := range args {
+		sb.WriteString("$")
+		sb.WriteString(strconv.Itoa(len(arg)))
+		sb.WriteString("\r\n")
+		sb.WriteString(arg)
+		sb.WriteString("\r\n")
+	}
+
+	_, err := io.WriteString(c.conn, sb.String())
+	if err != nil {
+		return nil, fmt.Errorf("Redis %s: I/O error: %w", args[0], err)
+	}
+
+	reply, err := c.readReply()
+	if err != nil {
+		var redisErr RedisError
+		if errors.As(err, &redisErr) {
+			return nil, fmt.Errorf("Redis %s: %w", args[0], err)
+		}
+		return nil, fmt.Errorf("Redis %s: I/O error: %w", args[0], err)
+	}
+	return reply, nil
+}
+
+func (c *RedisClient) readReply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+
+	case '-':
+		return nil, RedisError(body)
+
+	case ':':
+		n, err := strconv.ParseInt(body, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed integer reply %q", line)
+		}
+		return n, nil
+
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n > redisMaxBulkLength {
+			return nil, fmt.Errorf("malformed bulk reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(c.r, buf)
+		if err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("malformed array reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		out := make([]any, 0, minInt(n, 1024))
+		var firstErr error
+		for i := 0; i < n; i++ {
+			elem, err := c.readReply()
+			var redisErr RedisError
+			switch {
+			case errors.As(err, &redisErr):
+				// Keep reading, so that the connection stays in sync.
+				if firstErr == nil {
+					firstErr = err
+				}
+			case err != nil:
+				return nil, err
+			}
+			out = append(out, elem)
+		}
+		return out, firstErr
+
+	default:
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+}
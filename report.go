@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+const SkipReasonLocalDecision = "local admin decision"
+
+type ApplyReport struct {
+	Instance        string     `json:"instance,omitempty"`
+	Software        string     `json:"software"`
+	DryRun          bool       `json:"dryRun"`
+	Committed       bool       `json:"committed"`
+	LimitViolations []string   `json:"limitViolations,omitempty"`
+	PublishedAt     time.Time  `json:"publishedAt"`
+	StartedAt       time.Time  `json:"startedAt"`
+	FinishedAt      time.Time  `json:"finishedAt"`
+	DurationMS      int64      `json:"durationMs"`
+	Totals          PlanTotals `json:"totals"`
+	Inserted        []PlanItem `json:"inserted"`
+	Updated         []PlanItem `json:"updated"`
+	Deleted         []PlanItem `json:"deleted"`
+	Skipped         []PlanItem `json:"skipped"`
+}
+
+func NewApplyReport(software string, file BlockFile, plan Plan, startedAt time.Time, finishedAt time.Time) ApplyReport {
+	return ApplyReport{
+		Software:    software,
+		PublishedAt: file.PublishedAt,
+		StartedAt:   startedAt,
+		FinishedAt:  finishedAt,
+		DurationMS:  finishedAt.Sub(startedAt).Milliseconds(),
+		Totals:      plan.Totals(),
+		Inserted:    plan.ItemsWithAction(InsertAction),
+		Updated:     plan.ItemsWithAction(UpdateAction),
+		Deleted:     plan.ItemsWithAction(DeleteAction),
+		Skipped:     plan.ItemsWithAction(SkipAction),
+	}
+}
+
+func (report ApplyReport) WriteJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	e.SetEscapeHTML(false)
+	return e.Encode(report)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyReportWriteJSON(t *testing.T) {
+	publishedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	startedAt := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	finishedAt := startedAt.Add(1500 * time.Millisecond)
+	inserted := MastodonDomainBlock{Domain: "new.example", PrivateComment: WellKnownPrivateComment, PublicComment: "spam & scams", Severity: SeveritySuspend}
+	file := BlockFile{PublishedAt: publishedAt}
+	plan := Plan{Items: []PlanItem{
+		{Domain: "local.example", Action: SkipAction, Reason: SkipReasonLocalDecision},
+		{Domain: "new.example", Action: InsertAction, New: &inserted},
+	}}
+
+	report := NewApplyReport(MastodonAuto, file, plan, startedAt, finishedAt)
+	report.Instance = "mastodon"
+	report.Committed = true
+	var buf bytes.Buffer
+	err := report.WriteJSON(&buf)
+	if err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"spam & scams"`)) {
+		t.Errorf("WriteJSON: HTML characters should not be escaped: %s", buf.Bytes())
+	}
+
+	var got struct {
+		Instance    string           `json:"instance"`
+		Software    string           `json:"software"`
+		DryRun      bool             `json:"dryRun"`
+		Committed   bool             `json:"committed"`
+		PublishedAt time.Time        `json:"publishedAt"`
+		DurationMS  int64            `json:"durationMs"`
+		Totals      PlanTotals       `json:"totals"`
+		Inserted    []map[string]any `json:"inserted"`
+		Updated     []map[string]any `json:"updated"`
+		Deleted     []map[string]any `json:"deleted"`
+		Skipped     []map[string]any `json:"skipped"`
+	}
+	err = json.Unmarshal(buf.Bytes(), &got)
+	if err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if got.Instance != "mastodon" || got.Software != MastodonAuto || got.DryRun || !got.Committed {
+		t.Errorf("WriteJSON: unexpected header fields: %+v", got)
+	}
+	if !got.PublishedAt.Equal(publishedAt) || got.DurationMS != 1500 {
+		t.Errorf("WriteJSON: expected publishedAt %v and durationMs 1500, got %v and %d", publishedAt, got.PublishedAt, got.DurationMS)
+	}
+	if got.Totals != (PlanTotals{Inserted: 1, Skipped: 1}) {
+		t.Errorf("WriteJSON: unexpected totals %+v", got.Totals)
+	}
+	if got.Updated == nil || got.Deleted == nil || len(got.Updated)+len(got.Deleted) != 0 {
+		t.Errorf("WriteJSON: expected empty lists rather than null for updated and deleted, got %v and %v", got.Updated, got.Deleted)
+	}
+	wantSkipped := []map[string]any{{"domain": "local.example", "action": "skip", "reason": SkipReasonLocalDecision}}
+	if !reflect.DeepEqual(got.Skipped, wantSkipped) {
+		t.Errorf("WriteJSON: skipped:\n\tgot  %v\n\twant %v", got.Skipped, wantSkipped)
+	}
+	if len(got.Inserted) != 1 || got.Inserted[0]["domain"] != "new.example" || got.Inserted[0]["new"] == nil {
+		t.Errorf("WriteJSON: unexpected inserted %v", got.Inserted)
+	}
+}
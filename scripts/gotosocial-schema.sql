@@ -0,0 +1,41 @@
+-- The subset of the GoToSocial database schema that "rapidblock -m apply
+-- -x gotosocial" reads and writes, for testing against a scratch SQLite
+-- database without running GoToSocial itself:
+--
+--   sqlite3 gts.db < scripts/gotosocial-schema.sql
+--   rapidblock -m apply -x gotosocial -D gts.db -d blocklist.json -n
+
+CREATE TABLE IF NOT EXISTS accounts (
+  id CHAR(26) NOT NULL PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  username VARCHAR NOT NULL,
+  domain VARCHAR,
+  UNIQUE (username, domain)
+);
+
+CREATE TABLE IF NOT EXISTS users (
+  id CHAR(26) NOT NULL PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  email VARCHAR UNIQUE,
+  account_id CHAR(26) NOT NULL UNIQUE REFERENCES accounts (id) ON DELETE CASCADE,
+  admin BOOLEAN DEFAULT false,
+  moderator BOOLEAN DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS domain_blocks (
+  id CHAR(26) NOT NULL PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  domain VARCHAR NOT NULL UNIQUE,
+  created_by_account_id CHAR(26) NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+  private_comment VARCHAR,
+  public_comment VARCHAR,
+  obfuscate BOOLEAN DEFAULT false,
+  subscription_id CHAR(26)
+);
+
+INSERT OR IGNORE INTO accounts (id, username) VALUES ('01F8MH17FWEB39HZJ76B6VXSKF', 'admin');
+INSERT OR IGNORE INTO users (id, email, account_id, admin) VALUES ('01F8MGVGPHQ2D3P3X0454H54Z5', 'admin@example.org', '01F8MH17FWEB39HZJ76B6VXSKF', true);
+INSERT OR IGNORE INTO domain_blocks (id, domain, created_by_account_id, private_comment, public_comment) VALUES ('01FEED79PRMVWPRMFHFQM8MJQN', 'local.example', '01F8MH17FWEB39HZJ76B6VXSKF', 'blocked by hand', 'spam');
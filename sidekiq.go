@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const SidekiqDefaultQueue = "default"
+
+// SidekiqJob is a job in the JSON form that Sidekiq's client pushes onto a
+// queue.
+type SidekiqJob struct {
+	Class      string  `json:"class"`
+	Args       []any   `json:"args"`
+	Queue      string  `json:"queue"`
+	Retry      bool    `json:"retry"`
+	JID        string  `json:"jid"`
+	CreatedAt  float64 `json:"created_at"`
+	EnqueuedAt float64 `json:"enqueued_at,omitempty"`
+}
+
+func NewSidekiqJob(class string, args ...any) (SidekiqJob, error) {
+	var raw [12]byte
+	_, err := rand.Read(raw[:])
+	if err != nil {
+		return SidekiqJob{}, fmt.Errorf("failed to generate Sidekiq job ID: %w", err)
+	}
+	return SidekiqJob{
+		Class: class,
+		Args:  args,
+		Queue: SidekiqDefaultQueue,
+		Retry: true,
+		JID:   hex.EncodeToString(raw[:]),
+	}, nil
+}
+
+// EnqueueSidekiqJobs pushes jobs onto their queues in a single MULTI/EXEC
+// transaction, so that either all of them are enqueued or none are.  If
+// namespace is not empty, it is prefixed to every key, as redis-namespace
+// does for Mastodon's REDIS_NAMESPACE.
+func EnqueueSidekiqJobs(ctx context.Context, redisURL string, namespace string, jobs []SidekiqJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	key := func(name string) string {
+		if namespace == "" {
+			return name
+		}
+		return namespace + ":" + name
+	}
+
+	c, err := DialRedis(ctx, redisURL)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	_, err = c.Do("MULTI")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	queues := make(map[string]struct{}, 1)
+	for _, job := range jobs {
+		job.CreatedAt = sidekiqTime(now)
+		job.EnqueuedAt = job.CreatedAt
+		raw, err := json.Marshal(job)
+		if err != nil {
+			return fmt.Errorf("failed to encode Sidekiq job as JSON: %w", err)
+		}
+		if _, found := queues[job.Queue]; !found {
+			queues[job.Queue] = struct{}{}
+			_, err = c.Do("SADD", key("queues"), job.Queue)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = c.Do("LPUSH", key("queue:"+job.Queue), string(raw))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = c.Do("EXEC")
+	return err
+}
+
+// sidekiqTime is t in the form of Sidekiq's timestamps, fractional seconds
+// since the epoch.
+func sidekiqTime(t time.Time) float64 {
+	return float64(t.UnixMicro()) / 1e6
+}
+
+// SidekiqSpool is a directory that holds the jobs of each transaction, in
+// a file of their own, from just before it commits until they have been
+// pushed, so that if Redis cannot be reached after the commit, a later run
+// pushes them instead of them being lost.  Jobs left over from a
+// transaction that did not commit after all are harmless: DomainBlockWorker
+// does nothing for a block that does not exist, and otherwise enforces the
+// block as it stands.  If Dir is empty, nothing is kept, and the jobs of a
+// failed push are lost.
+//
+// The spool must only be loaded or saved to while holding
+// RapidBlockAdvisoryLockKey, so that one run never pushes the jobs of
+// another's uncommitted transaction.
+type SidekiqSpool struct {
+	Dir       string
+	RedisURL  string
+	Namespace string
+}
+
+// Load returns the jobs that earlier runs saved but did not push, and the
+// files that they were read from.
+func (spool SidekiqSpool) Load() ([]SidekiqJob, []string, error) {
+	if spool.Dir == "" {
+		return nil, nil, nil
+	}
+	entries, err := os.ReadDir(spool.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%q: failed to list directory: %w", spool.Dir, err)
+	}
+
+	var jobs []SidekiqJob
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		filePath := filepath.Join(spool.Dir, name)
+		raw, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%q: failed to read file: %w", filePath, err)
+		}
+
+		// Job arguments are decoded as json.Number, so that row IDs are
+		// pushed exactly as they were saved, even past 2**53.
+		var saved []SidekiqJob
+		d := json.NewDecoder(bytes.NewReader(raw))
+		d.UseNumber()
+		err = d.Decode(&saved)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%q: failed to decode JSON: %w", filePath, err)
+		}
+		jobs = append(jobs, saved...)
+		files = append(files, filePath)
+	}
+	return jobs, files, nil
+}
+
+// Save records jobs in a new file, and returns its name.
+func (spool SidekiqSpool) Save(jobs []SidekiqJob) (string, error) {
+	if spool.Dir == "" || len(jobs) == 0 {
+		return "", nil
+	}
+	raw, err := json.Marshal(jobs)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode Sidekiq jobs as JSON: %w", err)
+	}
+	err = os.MkdirAll(spool.Dir, 0o700)
+	if err != nil {
+		return "", fmt.Errorf("%q: failed to create directory: %w", spool.Dir, err)
+	}
+	filePath := filepath.Join(spool.Dir, jobs[0].JID+".json")
+	return filePath, WriteFileAtomic(filePath, raw, true)
+}
+
+// Push enqueues jobs, then removes the files that they were saved in.
+func (spool SidekiqSpool) Push(ctx context.Context, jobs []SidekiqJob, files []string) error {
+	err := EnqueueSidekiqJobs(ctx, spool.RedisURL, spool.Namespace, jobs)
+	if err != nil {
+		return err
+	}
+	for _, filePath := range files {
+		err = os.Remove(filePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%q: failed to remove file: %w", filePath, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis is just enough of a Redis server to record the commands that
+// EnqueueSidekiqJobs sends.  Commands between MULTI and EXEC are queued, as
+// Redis does, and replies[name] overrides the reply to a command.
+type fakeRedis struct {
+	t       *testing.T
+	ln      net.Listener
+	replies map[string]string
+
+	mu       sync.Mutex
+	commands [][]string
+	raw      []string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	srv := &fakeRedis{t: t, ln: ln, replies: make(map[string]string)}
+	t.Cleanup(func() { _ = ln.Close() })
+	go srv.serve()
+	return srv
+}
+
+func (srv *fakeRedis) URL(userinfo string, db string) string {
+	return "redis://" + userinfo + srv.ln.Addr().String() + "/" + db
+}
+
+func (srv *fakeRedis) Commands() [][]string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([][]string(nil), srv.commands...)
+}
+
+func (srv *fakeRedis) serve() {
+	for {
+		conn, err := srv.ln.Accept()
+		if err != nil {
+			return
+		}
+		go srv.handle(conn)
+	}
+}
+
+func (srv *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	inMulti := false
+	queued := 0
+	for {
+		args, raw, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+		srv.mu.Lock()
+		srv.commands = append(srv.commands, args)
+		srv.raw = append(srv.raw, raw)
+		srv.mu.Unlock()
+
+		name := strings.ToUpper(args[0])
+		reply, found := srv.replies[name]
+		switch {
+		case found:
+		case name == "MULTI":
+			inMulti = true
+			reply = "+OK\r\n"
+		case name == "EXEC":
+			inMulti = false
+			reply = "*" + strconv.Itoa(queued) + "\r\n" + strings.Repeat(":1\r\n", queued)
+			queued = 0
+		case inMulti:
+			queued++
+			reply = "+QUEUED\r\n"
+		default:
+			reply = "+OK\r\n"
+		}
+		_, err = io.WriteString(conn, reply)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func readRESPCommand(r *bufio.Reader) ([]string, string, error) {
+	var raw strings.Builder
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, "", err
+	}
+	raw.WriteString(line)
+	if !strings.HasPrefix(line, "*") || !strings.HasSuffix(line, "\r\n") {
+		return nil, "", fmt.Errorf("malformed command %q", line)
+	}
+	n, err := strconv.Atoi(line[1 : len(line)-2])
+	if err != nil {
+		return nil, "", err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, "", err
+		}
+		raw.WriteString(line)
+		size, err := strconv.Atoi(strings.TrimPrefix(line[:len(line)-2], "$"))
+		if err != nil {
+			return nil, "", err
+		}
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, "", err
+		}
+		raw.Write(buf)
+		args[i] = string(buf[:size])
+	}
+	return args, raw.String(), nil
+}
+
+func newTestSidekiqJobs(t *testing.T) []SidekiqJob {
+	t.Helper()
+	var jobs []SidekiqJob
+	for _, args := range [][]any{{int64(101), false}, {int64(102), true}} {
+		job, err := NewSidekiqJob(DomainBlockWorkerClass, args...)
+		if err != nil {
+			t.Fatalf("NewSidekiqJob: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+func decodeTestSidekiqJob(t *testing.T, raw string) map[string]any {
+	t.Helper()
+	var job map[string]any
+	err := json.Unmarshal([]byte(raw), &job)
+	if err != nil {
+		t.Fatalf("failed to decode job %q: %v", raw, err)
+	}
+	return job
+}
+
+func TestEnqueueSidekiqJobs(t *testing.T) {
+	srv := newFakeRedis(t)
+	jobs := newTestSidekiqJobs(t)
+
+	err := EnqueueSidekiqJobs(context.Background(), srv.URL(":sekrit@", "2"), "mastodon", jobs)
+	if err != nil {
+		t.Fatalf("EnqueueSidekiqJobs: %v", err)
+	}
+
+	commands := srv.Commands()
+	var names []string
+	for _, args := range commands {
+		names = append(names, args[0])
+	}
+	expected := []string{"AUTH", "SELECT", "MULTI", "SADD", "LPUSH", "LPUSH", "EXEC"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("commands: expected %v, got %v", expected, names)
+	}
+	if got := srv.raw[0]; got != "*2\r\n$4\r\nAUTH\r\n$6\r\nsekrit\r\n" {
+		t.Errorf("AUTH: unexpected encoding %q", got)
+	}
+	if !reflect.DeepEqual(commands[1], []string{"SELECT", "2"}) {
+		t.Errorf("SELECT: unexpected arguments %q", commands[1])
+	}
+	if !reflect.DeepEqual(commands[3], []string{"SADD", "mastodon:queues", SidekiqDefaultQueue}) {
+		t.Errorf("SADD: unexpected arguments %q", commands[3])
+	}
+	for i, args := range commands[4:6] {
+		if len(args) != 3 || args[1] != "mastodon:queue:"+SidekiqDefaultQueue {
+			t.Fatalf("LPUSH: unexpected arguments %q", args)
+		}
+		job := decodeTestSidekiqJob(t, args[2])
+		if job["class"] != DomainBlockWorkerClass || job["jid"] != jobs[i].JID || job["retry"] != true {
+			t.Errorf("LPUSH: unexpected job %v", job)
+		}
+		if job["enqueued_at"] == nil || job["at"] != nil {
+			t.Errorf("LPUSH: expected enqueued_at and no at, got %v", job)
+		}
+		wantArgs := []any{float64(101 + i), i == 1}
+		if !reflect.DeepEqual(job["args"], wantArgs) {
+			t.Errorf("LPUSH: expected args %v, got %v", wantArgs, job["args"])
+		}
+	}
+}
+
+func TestSidekiqSpool(t *testing.T) {
+	srv := newFakeRedis(t)
+	spool := SidekiqSpool{Dir: filepath.Join(t.TempDir(), "spool"), RedisURL: srv.URL("", "")}
+
+	jobs, files, err := spool.Load()
+	if err != nil || len(jobs) != 0 || len(files) != 0 {
+		t.Fatalf("Load: expected nothing from a missing directory, got %v, %v, %v", jobs, files, err)
+	}
+
+	// Row IDs past 2**53 must survive the round trip exactly.
+	job, err := NewSidekiqJob(DomainBlockWorkerClass, int64(1)<<60+1, false)
+	if err != nil {
+		t.Fatalf("NewSidekiqJob: %v", err)
+	}
+	first, err := spool.Save([]SidekiqJob{job})
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	second, err := spool.Save(newTestSidekiqJobs(t))
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	jobs, files, err = spool.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	sort.Strings(files)
+	want := []string{first, second}
+	sort.Strings(want)
+	if len(jobs) != 3 || !reflect.DeepEqual(files, want) {
+		t.Fatalf("Load: expected 3 jobs from %q, got %d from %q", want, len(jobs), files)
+	}
+
+	srv.replies["EXEC"] = "-EXECABORT Transaction discarded because of previous errors.\r\n"
+	err = spool.Push(context.Background(), jobs, files)
+	if err == nil {
+		t.Fatalf("Push: expected EXECABORT error")
+	}
+	if _, files, _ = spool.Load(); len(files) != 2 {
+		t.Fatalf("Push: a failed push removed the saved jobs, %d file(s) left", len(files))
+	}
+
+	delete(srv.replies, "EXEC")
+	err = spool.Push(context.Background(), jobs, files)
+	if err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if jobs, files, _ = spool.Load(); len(jobs) != 0 || len(files) != 0 {
+		t.Errorf("Push: expected the saved jobs to be removed, got %v from %q", jobs, files)
+	}
+
+	var pushed bool
+	for _, args := range srv.Commands() {
+		if len(args) == 3 && args[0] == "LPUSH" && strings.Contains(args[2], `"args":[1152921504606846977,false]`) {
+			pushed = true
+		}
+	}
+	if !pushed {
+		t.Errorf("Push: the job with a large row ID was not pushed exactly: %q", srv.Commands())
+	}
+}
+
+func TestEnqueueSidekiqJobsError(t *testing.T) {
+	srv := newFakeRedis(t)
+	srv.replies["EXEC"] = "-EXECABORT Transaction discarded because of previous errors.\r\n"
+
+	err := EnqueueSidekiqJobs(context.Background(), srv.URL("", ""), "", newTestSidekiqJobs(t))
+	if err == nil || !strings.Contains(err.Error(), "EXECABORT") {
+		t.Fatalf("EnqueueSidekiqJobs: expected EXECABORT error, got %v", err)
+	}
+}
+
+func TestEnqueueSidekiqJobsNone(t *testing.T) {
+	err := EnqueueSidekiqJobs(context.Background(), "redis://127.0.0.1:1/", "", nil)
+	if err != nil {
+		t.Fatalf("EnqueueSidekiqJobs: expected no connection for no jobs, got %v", err)
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A signature file is either a signature envelope, which is a JSON object,
+// a minisign signature (see minisign.go), or in the legacy format, the bare
+// base-64 Ed25519 signature of the SHA-256 checksum.  The envelope records
+// which key made each signature, when, and how the data was hashed, and the
+// signature covers all of that as well as the checksum.
+const (
+	SignatureSpecV1 = "https://rapidblock.org/spec/v1/signature"
+
+	SigFormatEnvelope = "envelope"
+	SigFormatLegacy   = "legacy"
+	SigFormatMinisign = "minisign"
+
+	AllSigFormats = SigFormatEnvelope + ", " + SigFormatLegacy + ", " + SigFormatMinisign
+
+	SignatureAlgorithmEd25519 = "ed25519"
+	SignatureHashSHA256       = "sha256"
+
+	CanonicalizationNone = "none"
+	CanonicalizationText = "text"
+
+	// The purpose of a signature is part of the signed message, so that a
+	// signature made for one kind of document cannot be passed off as one
+	// made for another: signing a blocklist that happens to contain a key
+	// transition statement does not make that statement valid.
+	SignaturePurposeBlocklist     = "blocklist"
+	SignaturePurposeKeyTransition = "key-transition"
+)
+
+type SignatureEnvelope struct {
+	Spec       string           `json:"@spec"`
+	Signatures []SignatureEntry `json:"signatures"`
+}
+
+type SignatureEntry struct {
+	Purpose          string    `json:"purpose"`
+	KeyFingerprint   string    `json:"keyFingerprint"`
+	SignedAt         time.Time `json:"signedAt"`
+	Algorithm        string    `json:"algorithm"`
+	Hash             string    `json:"hash"`
+	Canonicalization string    `json:"canonicalization"`
+	Checksum         []byte    `json:"checksum"`
+	Signature        []byte    `json:"signature"`
+}
+
+// KeyFingerprint identifies a public key in the style of OpenSSH, as the
+// unpadded base-64 SHA-256 hash of the key.
+func KeyFingerprint(pubKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(pubKey)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+func canonicalizationFor(isText bool) string {
+	if isText {
+		return CanonicalizationText
+	}
+	return CanonicalizationNone
+}
+
+// NewSignatureEntry signs checksum, which was computed with the given
+// canonicalization, along with the purpose and the metadata that describes
+// it.
+func NewSignatureEntry(privKey ed25519.PrivateKey, purpose string, checksum []byte, isText bool, now time.Time) SignatureEntry {
+	entry := SignatureEntry{
+		Purpose:          purpose,
+		KeyFingerprint:   KeyFingerprint(privKey.Public().(ed25519.PublicKey)),
+		SignedAt:         now.UTC().Truncate(time.Second),
+		Algorithm:        SignatureAlgorithmEd25519,
+		Hash:             SignatureHashSHA256,
+		Canonicalization: canonicalizationFor(isText),
+		Checksum:         checksum,
+	}
+	entry.Signature = ed25519.Sign(privKey, entry.signedMessage(checksum))
+	return entry
+}
+
+// signedMessage is the byte string that the entry's signature covers.
+func (entry SignatureEntry) signedMessage(checksum []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(SignatureSpecV1 + "\n")
+	buf.WriteString("purpose: " + entry.Purpose + "\n")
+	buf.WriteString("key: " + entry.KeyFingerprint + "\n")
+	buf.WriteString("signed-at: " + entry.SignedAt.UTC().Format(time.RFC3339Nano) + "\n")
+	buf.WriteString("algorithm: " + entry.Algorithm + "\n")
+	buf.WriteString("hash: " + entry.Hash + "\n")
+	buf.WriteString("canonicalization: " + entry.Canonicalization + "\n")
+	buf.WriteString("checksum: " + base64.StdEncoding.EncodeToString(checksum) + "\n")
+	return buf.Bytes()
+}
+
+func (entry SignatureEntry) IsText() (bool, error) {
+	switch entry.Canonicalization {
+	case CanonicalizationNone:
+		return false, nil
+	case CanonicalizationText:
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown canonicalization %q", entry.Canonicalization)
+	}
+}
+
+// Verify checks that the entry was made for purpose and for data with the
+// given checksum, computed with the entry's canonicalization, and checks
+// its signature.
+func (entry SignatureEntry) Verify(pubKey ed25519.PublicKey, purpose string, checksum []byte) error {
+	if entry.Purpose != purpose {
+		return fmt.Errorf("signature was made for %q, not for %q", entry.Purpose, purpose)
+	}
+	if !entry.IsFor(checksum) {
+		str0 := base64.StdEncoding.EncodeToString(checksum)
+		str1 := base64.StdEncoding.EncodeToString(entry.Checksum)
+		return fmt.Errorf("signature verification failed: signature was made for different data!\n\tSHA-256 checksum (%s): %s\n\tSHA-256 checksum signed by %s: %s", entry.Canonicalization, str0, entry.KeyFingerprint, str1)
+	}
+	if entry.Algorithm != SignatureAlgorithmEd25519 {
+		return fmt.Errorf("unknown signature algorithm %q", entry.Algorithm)
+	}
+	if entry.Hash != SignatureHashSHA256 {
+		return fmt.Errorf("unknown hash algorithm %q", entry.Hash)
+	}
+	if !ed25519.Verify(pubKey, entry.signedMessage(checksum), entry.Signature) {
+		str0 := base64.StdEncoding.EncodeToString(checksum)
+		return fmt.Errorf("signature verification failed!\n\tSHA-256 checksum (%s): %s\n\tEd25519 public key: %s\n\tsigned at: %s", entry.Canonicalization, str0, entry.KeyFingerprint, entry.SignedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// IsFor returns true if the entry was made for data with the given
+// checksum.  The signature itself is not checked.
+func (entry SignatureEntry) IsFor(checksum []byte) bool {
+	return bytes.Equal(entry.Checksum, checksum)
+}
+
+func (env SignatureEnvelope) Encode() ([]byte, error) {
+	raw, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode JSON data: %w", err)
+	}
+	return append(raw, '\n'), nil
+}
+
+// IsSignatureEnvelope returns true if raw looks like a signature envelope
+// rather than a legacy signature.
+func IsSignatureEnvelope(raw []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{"))
+}
+
+func DecodeSignatureEnvelope(raw []byte) (SignatureEnvelope, error) {
+	var env SignatureEnvelope
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.DisallowUnknownFields()
+	err := d.Decode(&env)
+	if err != nil {
+		return env, fmt.Errorf("failed to decode signature envelope: %w", err)
+	}
+	if env.Spec != SignatureSpecV1 {
+		return env, fmt.Errorf("unsupported signature envelope %q, expected %q", env.Spec, SignatureSpecV1)
+	}
+	for i, entry := range env.Signatures {
+		if len(entry.Checksum) != sha256.Size {
+			return env, fmt.Errorf("signature #%d has checksum of wrong length: expected %d bytes, got %d bytes", i+1, sha256.Size, len(entry.Checksum))
+		}
+		if len(entry.Signature) != ed25519.SignatureSize {
+			return env, fmt.Errorf("signature #%d has wrong length: expected %d bytes, got %d bytes", i+1, ed25519.SignatureSize, len(entry.Signature))
+		}
+	}
+	return env, nil
+}
+
+// Add adds entry, replacing any earlier signature by the same key, and
+// returns true if it replaced one.
+func (env *SignatureEnvelope) Add(entry SignatureEntry) bool {
+	for i := range env.Signatures {
+		if env.Signatures[i].KeyFingerprint == entry.KeyFingerprint {
+			env.Signatures[i] = entry
+			return true
+		}
+	}
+	env.Signatures = append(env.Signatures, entry)
+	return false
+}
+
+// DropStale removes the signatures that were made for data other than the
+// data whose checksum is given, and returns them.
+func (env *SignatureEnvelope) DropStale(checksum func(isText bool) []byte) []SignatureEntry {
+	var kept, dropped []SignatureEntry
+	for _, entry := range env.Signatures {
+		isText, err := entry.IsText()
+		if err != nil || !entry.IsFor(checksum(isText)) {
+			dropped = append(dropped, entry)
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	env.Signatures = kept
+	return dropped
+}
+
+// A SignatureReport says, for each signer, whether its signature counted
+// toward the threshold.  Valid and invalid signers signed the file;
+// missing signers are currently valid keys in the keyring that did not;
+// unknown signers are keys that are not in the keyring at all.  A key and
+// the keys it introduced by key transition count as a single signer, so
+// that one compromised key cannot meet a threshold by itself.
+type SignatureReport struct {
+	Threshold int
+	Signers   []SignerResult
+
+	// Checksum is the checksum verified by the first valid signature.
+	Checksum []byte
+
+	// TrustedComment is the trusted comment of a valid minisign signature.
+	TrustedComment string
+}
+
+// SignedData gives VerifySignatureData the data that was signed, in the
+// form that each signature format needs.
+type SignedData struct {
+	// Checksum returns the SHA-256 checksum of the data, with or without
+	// text canonicalization.
+	Checksum func(isText bool) []byte
+
+	// Raw returns the data itself.
+	Raw func() []byte
+}
+
+type SignerResult struct {
+	Fingerprint string
+	Key         *TrustedKey
+	Status      SignerStatus
+	Err         error
+}
+
+type SignerStatus string
+
+const (
+	SignerValid   SignerStatus = "valid"
+	SignerInvalid SignerStatus = "invalid"
+	SignerMissing SignerStatus = "missing"
+	SignerUnknown SignerStatus = "unknown"
+)
+
+// ValidCount returns the number of distinct holders with valid signatures.
+func (r SignatureReport) ValidCount() int {
+	holders := make(map[string]struct{}, len(r.Signers))
+	for _, signer := range r.Signers {
+		if signer.Status == SignerValid {
+			holders[signer.Key.HolderFingerprint()] = struct{}{}
+		}
+	}
+	return len(holders)
+}
+
+// Err returns nil if enough signers were valid to meet the threshold.
+// When a single signature was required and a single one failed, its error
+// is returned as is, since it has the details.
+func (r SignatureReport) Err() error {
+	valid := r.ValidCount()
+	if valid >= r.Threshold {
+		return nil
+	}
+
+	var failed []SignerResult
+	for _, signer := range r.Signers {
+		if signer.Status == SignerInvalid {
+			failed = append(failed, signer)
+		}
+	}
+	if r.Threshold == 1 && len(failed) == 1 {
+		return failed[0].Err
+	}
+
+	if len(r.Signers) == 0 {
+		return fmt.Errorf("signature envelope contains no signatures")
+	}
+	lines := make([]string, len(r.Signers))
+	for i, signer := range r.Signers {
+		lines[i] = signer.String()
+	}
+	return fmt.Errorf("found %d valid signer(s), need at least %d\n\t%s", valid, r.Threshold, strings.Join(lines, "\n\t"))
+}
+
+func (signer SignerResult) String() string {
+	who := signer.Fingerprint
+	if signer.Key != nil {
+		who = signer.Key.String()
+	}
+	str := fmt.Sprintf("%-7s  %s", signer.Status, who)
+	if signer.Err != nil {
+		reason, _, _ := strings.Cut(signer.Err.Error(), "\n")
+		str += ": " + reason
+	}
+	return str
+}
+
+// VerifySignatureData verifies a signature file in any format against the
+// keys in keyring that are valid at time now, and requires valid signatures
+// by at least threshold distinct keys.  isText is only consulted for legacy
+// signatures, which do not record it, and for the checksum reported for
+// minisign signatures, which do not use it.
+//
+// The error is non-nil if the file cannot be decoded or the threshold is
+// not met; in the latter case, the report is still filled in.
+func VerifySignatureData(keyring Keyring, raw []byte, data SignedData, isText bool, threshold int, now time.Time) (SignatureReport, error) {
+	report := SignatureReport{Threshold: threshold}
+	validKeys := keyring.ValidKeys(now)
+	signed := make(map[string]bool, len(validKeys))
+
+	switch {
+	case IsMinisignFile(raw):
+		sig, err := DecodeMinisignSignature(raw)
+		if err != nil {
+			return report, err
+		}
+
+		// A minisign signature names its key by key ID only.
+		index := keyring.FindKeyID(sig.KeyID)
+		if index < 0 {
+			report.Signers = append(report.Signers, SignerResult{Fingerprint: "minisign key ID " + MinisignKeyIDString(sig.KeyID), Status: SignerUnknown})
+			break
+		}
+		key := &keyring.Keys[index]
+		signed[key.Fingerprint()] = true
+		result := SignerResult{Fingerprint: key.Fingerprint(), Key: key, Status: SignerInvalid}
+		if !key.ValidAt(now) {
+			err = fmt.Errorf("the key is %s", key.Validity())
+		} else {
+			err = sig.Verify(key.PublicKey, data.Raw())
+		}
+		result.Err = err
+		if err == nil {
+			result.Status = SignerValid
+			report.Checksum = data.Checksum(isText)
+			report.TrustedComment = sig.TrustedComment
+		}
+		report.Signers = append(report.Signers, result)
+
+	case !IsSignatureEnvelope(raw):
+		signature, err := DecodeKeySig(raw, ed25519.SignatureSize)
+		if err != nil {
+			return report, err
+		}
+		if len(validKeys) == 0 {
+			return report, fmt.Errorf("no key in the keyring is valid at %s", now.UTC().Format(time.RFC3339))
+		}
+		checksum := data.Checksum(isText)
+		for i := range validKeys {
+			key := &validKeys[i]
+			if ed25519.Verify(key.PublicKey, checksum, signature) {
+				signed[key.Fingerprint()] = true
+				report.Checksum = checksum
+				report.Signers = append(report.Signers, SignerResult{Fingerprint: key.Fingerprint(), Key: key, Status: SignerValid})
+				break
+			}
+		}
+		if report.Checksum == nil {
+			// A legacy signature does not say who made it.
+			var err error
+			if len(validKeys) == 1 {
+				err = verifySignature(validKeys[0].PublicKey, checksum, signature, isText)
+			} else {
+				str0 := base64.StdEncoding.EncodeToString(checksum)
+				err = fmt.Errorf("signature verification failed with each of %d valid keys!\n\tSHA-256 checksum: %s\n\tkeys: %s\n\t%s", len(validKeys), str0, describeKeys(validKeys), textHint(isText))
+			}
+			report.Signers = append(report.Signers, SignerResult{Fingerprint: "legacy signature", Status: SignerInvalid, Err: err})
+		}
+
+	default:
+		env, err := DecodeSignatureEnvelope(raw)
+		if err != nil {
+			return report, err
+		}
+
+		for _, entry := range env.Signatures {
+			fingerprint := entry.KeyFingerprint
+			if signed[fingerprint] {
+				continue
+			}
+			signed[fingerprint] = true
+
+			index := keyring.Find(fingerprint)
+			if index < 0 {
+				report.Signers = append(report.Signers, SignerResult{Fingerprint: fingerprint, Status: SignerUnknown})
+				continue
+			}
+			key := &keyring.Keys[index]
+			result := SignerResult{Fingerprint: fingerprint, Key: key, Status: SignerInvalid}
+
+			var checksum []byte
+			entryIsText, err := entry.IsText()
+			if err == nil && !key.ValidAt(now) {
+				err = fmt.Errorf("the key is %s", key.Validity())
+			}
+			if err == nil {
+				checksum = data.Checksum(entryIsText)
+				err = entry.Verify(key.PublicKey, SignaturePurposeBlocklist, checksum)
+			}
+			result.Err = err
+			if err == nil {
+				result.Status = SignerValid
+				if report.Checksum == nil {
+					report.Checksum = checksum
+				}
+			}
+			report.Signers = append(report.Signers, result)
+		}
+	}
+
+	validHolders := make(map[string]bool, len(report.Signers))
+	for _, signer := range report.Signers {
+		if signer.Status == SignerValid {
+			validHolders[signer.Key.HolderFingerprint()] = true
+		}
+	}
+	for i := range validKeys {
+		key := &validKeys[i]
+		if !signed[key.Fingerprint()] && !validHolders[key.HolderFingerprint()] {
+			report.Signers = append(report.Signers, SignerResult{Fingerprint: key.Fingerprint(), Key: key, Status: SignerMissing})
+		}
+	}
+	return report, report.Err()
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignatureEnvelopeRoundTrip(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 678, time.UTC)
+	pubKey, privKey := newTestKey(t)
+	data := []byte("line one\r\nline two\r\n")
+
+	env := SignatureEnvelope{Spec: SignatureSpecV1}
+	env.Add(NewSignatureEntry(privKey, SignaturePurposeBlocklist, checksumBytes(data, true), true, now))
+	raw, err := env.Encode()
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if !IsSignatureEnvelope(raw) || IsSignatureEnvelope([]byte(base64.StdEncoding.EncodeToString(make([]byte, 64)))) {
+		t.Errorf("IsSignatureEnvelope: cannot tell an envelope from a legacy signature")
+	}
+
+	got, err := DecodeSignatureEnvelope(raw)
+	if err != nil {
+		t.Fatalf("DecodeSignatureEnvelope: %v", err)
+	}
+	if !reflect.DeepEqual(got, env) {
+		t.Fatalf("DecodeSignatureEnvelope:\n\tgot  %+v\n\twant %+v", got, env)
+	}
+	entry := got.Signatures[0]
+	if entry.KeyFingerprint != KeyFingerprint(pubKey) || !entry.SignedAt.Equal(now.Truncate(time.Second)) {
+		t.Errorf("entry: unexpected key or time: %+v", entry)
+	}
+	if isText, err := entry.IsText(); err != nil || !isText {
+		t.Errorf("IsText: expected true, got %v, %v", isText, err)
+	}
+	if err := entry.Verify(pubKey, SignaturePurposeBlocklist, checksumBytes(data, true)); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestDecodeSignatureEnvelopeErrors(t *testing.T) {
+	_, privKey := newTestKey(t)
+	entry := NewSignatureEntry(privKey, SignaturePurposeBlocklist, checksumBytes([]byte("data"), false), false, time.Now())
+	encode := func(env SignatureEnvelope) string {
+		raw, err := env.Encode()
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		return string(raw)
+	}
+	shortChecksum := entry
+	shortChecksum.Checksum = shortChecksum.Checksum[:16]
+	shortSignature := entry
+	shortSignature.Signature = shortSignature.Signature[:32]
+
+	type testCase struct {
+		name    string
+		raw     string
+		wantErr string
+	}
+	testCases := []testCase{
+		{name: "not-json", raw: "{", wantErr: "failed to decode signature envelope"},
+		{name: "unknown-field", raw: `{"@spec":"` + SignatureSpecV1 + `","signatures":[],"comment":"hi"}`, wantErr: `unknown field "comment"`},
+		{name: "wrong-spec", raw: `{"@spec":"https://example.com/spec","signatures":[]}`, wantErr: "unsupported signature envelope"},
+		{name: "short-checksum", raw: encode(SignatureEnvelope{Spec: SignatureSpecV1, Signatures: []SignatureEntry{entry, shortChecksum}}), wantErr: "signature #2 has checksum of wrong length: expected 32 bytes, got 16 bytes"},
+		{name: "short-signature", raw: encode(SignatureEnvelope{Spec: SignatureSpecV1, Signatures: []SignatureEntry{shortSignature}}), wantErr: "signature #1 has wrong length: expected 64 bytes, got 32 bytes"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecodeSignatureEnvelope([]byte(tc.raw))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("DecodeSignatureEnvelope: expected an error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSignatureEntryVerify(t *testing.T) {
+	pubKey, privKey := newTestKey(t)
+	otherPubKey, _ := newTestKey(t)
+	checksum := checksumBytes([]byte("data"), false)
+	otherChecksum := checksumBytes([]byte("other data"), false)
+	entry := NewSignatureEntry(privKey, SignaturePurposeBlocklist, checksum, false, time.Now())
+
+	type testCase struct {
+		name     string
+		mutate   func(entry *SignatureEntry)
+		pubKey   ed25519.PublicKey
+		purpose  string
+		checksum []byte
+		wantErr  string
+	}
+	testCases := []testCase{
+		{name: "ok"},
+		{name: "wrong-purpose", purpose: SignaturePurposeKeyTransition, wantErr: `signature was made for "blocklist", not for "key-transition"`},
+		{name: "other-data", checksum: otherChecksum, wantErr: "signature was made for different data"},
+		{name: "other-key", pubKey: otherPubKey, wantErr: "signature verification failed!"},
+		{name: "unknown-algorithm", mutate: func(entry *SignatureEntry) { entry.Algorithm = "rsa" }, wantErr: `unknown signature algorithm "rsa"`},
+		{name: "unknown-hash", mutate: func(entry *SignatureEntry) { entry.Hash = "md5" }, wantErr: `unknown hash algorithm "md5"`},
+		{name: "tampered-time", mutate: func(entry *SignatureEntry) { entry.SignedAt = entry.SignedAt.Add(time.Second) }, wantErr: "signature verification failed!"},
+		{name: "tampered-canonicalization", mutate: func(entry *SignatureEntry) { entry.Canonicalization = CanonicalizationText }, wantErr: "signature verification failed!"},
+		{name: "relabeled-purpose", mutate: func(entry *SignatureEntry) { entry.Purpose = SignaturePurposeKeyTransition }, purpose: SignaturePurposeKeyTransition, wantErr: "signature verification failed!"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := entry
+			if tc.mutate != nil {
+				tc.mutate(&e)
+			}
+			if tc.pubKey == nil {
+				tc.pubKey = pubKey
+			}
+			if tc.purpose == "" {
+				tc.purpose = SignaturePurposeBlocklist
+			}
+			if tc.checksum == nil {
+				tc.checksum = checksum
+			}
+			err := e.Verify(tc.pubKey, tc.purpose, tc.checksum)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("Verify: unexpected error: %v", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("Verify: expected an error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSignatureEnvelopeDropStale(t *testing.T) {
+	_, privKeyA := newTestKey(t)
+	_, privKeyB := newTestKey(t)
+	_, privKeyC := newTestKey(t)
+	data := []byte("current\r\n")
+	env := SignatureEnvelope{Spec: SignatureSpecV1}
+	env.Add(NewSignatureEntry(privKeyA, SignaturePurposeBlocklist, checksumBytes(data, false), false, time.Now()))
+	env.Add(NewSignatureEntry(privKeyB, SignaturePurposeBlocklist, checksumBytes([]byte("stale\r\n"), false), false, time.Now()))
+	env.Add(NewSignatureEntry(privKeyC, SignaturePurposeBlocklist, checksumBytes(data, true), true, time.Now()))
+	if !env.Add(NewSignatureEntry(privKeyA, SignaturePurposeBlocklist, checksumBytes(data, false), false, time.Now())) {
+		t.Errorf("Add: expected a second signature by the same key to replace the first")
+	}
+
+	dropped := env.DropStale(func(isText bool) []byte {
+		return checksumBytes(data, isText)
+	})
+	if len(dropped) != 1 || dropped[0].KeyFingerprint != KeyFingerprint(privKeyB.Public().(ed25519.PublicKey)) {
+		t.Errorf("DropStale: expected only the signature by B to be dropped, got %+v", dropped)
+	}
+	if len(env.Signatures) != 2 {
+		t.Errorf("DropStale: expected 2 signatures to be kept, got %d", len(env.Signatures))
+	}
+}
+
+func TestVerifySignatureDataLegacy(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	pubKeyA, privKeyA := newTestKey(t)
+	pubKeyB, _ := newTestKey(t)
+	pubKeyC, _ := newTestKey(t)
+	data := []byte("line one\r\n")
+	legacySig := func(isText bool) []byte {
+		return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(privKeyA, checksumBytes(data, isText))) + "\n")
+	}
+
+	type testCase struct {
+		name    string
+		keys    []ed25519.PublicKey
+		raw     []byte
+		isText  bool
+		wantErr string
+	}
+	testCases := []testCase{
+		{name: "ok", keys: []ed25519.PublicKey{pubKeyA}, raw: legacySig(false)},
+		{name: "ok-text", keys: []ed25519.PublicKey{pubKeyA}, raw: legacySig(true), isText: true},
+		{name: "second-key", keys: []ed25519.PublicKey{pubKeyB, pubKeyA}, raw: legacySig(false)},
+		{name: "wrong-text", keys: []ed25519.PublicKey{pubKeyA}, raw: legacySig(true), wantErr: "maybe try again with --text?"},
+		{name: "wrong-key", keys: []ed25519.PublicKey{pubKeyB}, raw: legacySig(false), wantErr: "signature verification failed!"},
+		{name: "wrong-keys", keys: []ed25519.PublicKey{pubKeyB, pubKeyC}, raw: legacySig(false), wantErr: "signature verification failed with each of 2 valid keys!"},
+		{name: "no-keys", raw: legacySig(false), wantErr: "no key in the keyring is valid"},
+		{name: "bad-length", keys: []ed25519.PublicKey{pubKeyA}, raw: []byte("AAAA\n"), wantErr: "data has wrong length"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var kr Keyring
+			for _, pubKey := range tc.keys {
+				kr.Add(TrustedKey{PublicKey: pubKey})
+			}
+			report, err := VerifySignatureData(kr, tc.raw, signedBytes(data), tc.isText, 1, now)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("VerifySignatureData: unexpected error: %v", err)
+				}
+				if !bytes.Equal(report.Checksum, checksumBytes(data, tc.isText)) {
+					t.Errorf("VerifySignatureData: unexpected checksum in report")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("VerifySignatureData: expected an error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestVerifySignatureDataThreshold(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	data := []byte("data")
+	otherData := []byte("other data")
+
+	names := make(map[string]string)
+	privKeys := make(map[string]ed25519.PrivateKey)
+	var kr Keyring
+	for _, name := range []string{"a", "a2", "b", "c", "expired", "unknown"} {
+		pubKey, privKey := newTestKey(t)
+		names[KeyFingerprint(pubKey)] = name
+		privKeys[name] = privKey
+		key := TrustedKey{Name: name, PublicKey: pubKey}
+		switch name {
+		case "a2":
+			key.Holder = KeyFingerprint(privKeys["a"].Public().(ed25519.PublicKey))
+		case "expired":
+			key.NotAfter = now.Add(-time.Hour)
+		case "unknown":
+			continue
+		}
+		kr.Add(key)
+	}
+
+	type signature struct {
+		name string
+		data []byte
+	}
+	type testCase struct {
+		name       string
+		signatures []signature
+		threshold  int
+		want       []string
+		wantErr    string
+	}
+	testCases := []testCase{
+		{
+			name:       "met",
+			signatures: []signature{{"a", data}, {"b", data}},
+			threshold:  2,
+			want:       []string{"valid a", "valid b", "missing c"},
+		},
+		{
+			name:       "same-holder",
+			signatures: []signature{{"a", data}, {"a2", data}},
+			threshold:  2,
+			want:       []string{"valid a", "valid a2", "missing b", "missing c"},
+			wantErr:    "found 1 valid signer(s), need at least 2",
+		},
+		{
+			name:       "unknown-signer",
+			signatures: []signature{{"unknown", data}, {"c", data}},
+			threshold:  1,
+			want:       []string{"unknown unknown", "valid c", "missing a", "missing a2", "missing b"},
+		},
+		{
+			name:       "invalid-signer",
+			signatures: []signature{{"a", otherData}, {"b", data}},
+			threshold:  2,
+			want:       []string{"invalid a", "valid b", "missing a2", "missing c"},
+			wantErr:    "invalid  \"a\" (" + KeyFingerprint(privKeys["a"].Public().(ed25519.PublicKey)) + "): signature verification failed: signature was made for different data!",
+		},
+		{
+			name:       "single-invalid",
+			signatures: []signature{{"b", otherData}},
+			threshold:  1,
+			want:       []string{"invalid b", "missing a", "missing a2", "missing c"},
+			wantErr:    "signature verification failed: signature was made for different data!\n\tSHA-256 checksum (none): ",
+		},
+		{
+			name:       "expired",
+			signatures: []signature{{"expired", data}},
+			threshold:  1,
+			want:       []string{"invalid expired", "missing a", "missing a2", "missing b", "missing c"},
+			wantErr:    "the key is valid until 2026-05-31T23:00:00Z",
+		},
+		{
+			name:       "duplicate-signer",
+			signatures: []signature{{"a", data}, {"a", otherData}},
+			threshold:  2,
+			want:       []string{"valid a", "missing b", "missing c"},
+			wantErr:    "found 1 valid signer(s), need at least 2",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := SignatureEnvelope{Spec: SignatureSpecV1}
+			for _, sig := range tc.signatures {
+				env.Signatures = append(env.Signatures, NewSignatureEntry(privKeys[sig.name], SignaturePurposeBlocklist, checksumBytes(sig.data, false), false, now))
+			}
+			raw, err := env.Encode()
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			report, err := VerifySignatureData(kr, raw, signedBytes(data), false, tc.threshold, now)
+			var got []string
+			for _, signer := range report.Signers {
+				got = append(got, string(signer.Status)+" "+names[signer.Fingerprint])
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("VerifySignatureData: signers:\n\tgot  %q\n\twant %q", got, tc.want)
+			}
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("VerifySignatureData: unexpected error: %v", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("VerifySignatureData: expected an error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}